@@ -0,0 +1,167 @@
+// Package assert evaluates the assertions of a step against the output of
+// its nodes.
+package assert
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// StepOutputLine returns a line of the output of the latest run of an
+// earlier step in the iteration: on the given node, or on the only node the
+// step ran on, e.g. the node that added a file.
+func StepOutputLine(iteration *report.IterationResult, name string, node int, line int) (string, bool) {
+	if iteration == nil {
+		return "", false
+	}
+	// The last step result is the one of the step itself.
+	for i := len(iteration.Steps) - 2; i >= 0; i-- {
+		step := iteration.Steps[i]
+		if step.Name != name {
+			continue
+		}
+		for _, result := range step.Nodes {
+			if result.Node == node || len(step.Nodes) == 1 {
+				if value, ok := result.Line(line); ok {
+					return value, true
+				}
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// EvaluateAssertions checks assertions against the output of a node. Like
+// before, it stops at the first assertion whose line is missing from the
+// output, or was dropped by the output_limit, and reports whether all of
+// them could be evaluated.
+func EvaluateAssertions(assertions []config.Assertion, result *report.NodeResult, env []string, iteration *report.IterationResult) ([]report.AssertionResult, bool) {
+	node, out := result.Node, result.Output
+	var results []report.AssertionResult
+	for _, assertion := range assertions {
+		if assertion.Command != "" {
+			results = append(results, evaluateCommand(assertion, node, out, env))
+			continue
+		}
+		if assertion.ShouldHaveLines != nil {
+			lines := len(OutputLines(out)) + result.DroppedLines
+			results = append(results, report.AssertionResult{
+				Line:     assertion.Line,
+				Expected: assertion.ShouldHaveLines.String(),
+				Actual:   fmt.Sprintf("%d lines", lines),
+				Passed:   assertion.ShouldHaveLines.Matches(lines),
+			})
+			continue
+		}
+		var lineToAssert string
+		if assertion.JQ != "" {
+			var err error
+			lineToAssert, err = evaluateJQ(assertion.JQ, out)
+			if err != nil {
+				lineToAssert = "error: " + err.Error()
+			}
+			if assertion.ShouldBeEqualTo == "" && assertion.ShouldNotBeEqualTo == "" && assertion.ShouldContain == "" &&
+				assertion.ShouldNotContain == "" && assertion.ShouldBeEqualToVarOnNode == nil && assertion.ShouldBeEqualToStep == nil {
+				assertion.ShouldBeEqualTo = "true"
+			}
+		} else if assertion.WholeOutput {
+			lineToAssert = strings.Join(OutputLines(out), "\n")
+		} else if line, ok := result.Line(assertion.Line); ok {
+			lineToAssert = line
+		} else {
+			return results, false
+		}
+		var expected string
+		var passed bool
+		switch {
+		case assertion.ShouldBeEqualToVarOnNode != nil:
+			on := assertion.ShouldBeEqualToVarOnNode
+			expected = config.LookupVariable(fmt.Sprintf("%s_%d", on.Var, on.Node), env)
+			if expected == "" {
+				color.Red("Variable %s was not saved on node %d", on.Var, on.Node)
+			}
+			passed = expected != "" && lineToAssert == expected
+		case assertion.ShouldBeEqualToStep != nil:
+			from := assertion.ShouldBeEqualToStep
+			value, ok := StepOutputLine(iteration, from.FromStep, node, from.Line)
+			if !ok {
+				color.Red("Step %s has no line %d on node %d", from.FromStep, from.Line, node)
+			}
+			expected = value
+			passed = ok && lineToAssert == value
+		case assertion.ShouldNotBeEqualTo != "":
+			value := assertionValue(assertion.ShouldNotBeEqualTo, node, env)
+			expected = "not " + value
+			passed = lineToAssert != value
+		case assertion.ShouldContain != "":
+			value := assertionValue(assertion.ShouldContain, node, env)
+			expected = "contains " + value
+			passed = strings.Contains(lineToAssert, value)
+		case assertion.ShouldNotContain != "":
+			value := assertionValue(assertion.ShouldNotContain, node, env)
+			expected = "does not contain " + value
+			passed = !strings.Contains(lineToAssert, value)
+		default:
+			expected = assertionValue(assertion.ShouldBeEqualTo, node, env)
+			passed = lineToAssert == expected
+		}
+		results = append(results, report.AssertionResult{
+			Line:     assertion.Line,
+			Expected: expected,
+			Actual:   lineToAssert,
+			Passed:   passed,
+		})
+	}
+	return results, true
+}
+
+// EvaluateStderrAssertions checks the stderr of a node.
+func EvaluateStderrAssertions(assertions []config.StderrAssertion, stderr []string) []report.AssertionResult {
+	var results []report.AssertionResult
+	actual := strings.Join(stderr, "\n")
+	for _, assertion := range assertions {
+		if assertion.Empty {
+			results = append(results, report.AssertionResult{Expected: "empty stderr", Actual: actual, Passed: actual == ""})
+		}
+		if assertion.Matches != "" {
+			matched, _ := regexp.MatchString(assertion.Matches, actual)
+			results = append(results, report.AssertionResult{Expected: "stderr matching " + assertion.Matches, Actual: actual, Passed: matched})
+		}
+	}
+	return results
+}
+
+// OutputLines drops the empty line left after the final newline of a
+// command's output.
+func OutputLines(out []string) []string {
+	if len(out) != 0 && out[len(out)-1] == "" {
+		return out[:len(out)-1]
+	}
+	return out
+}
+
+// assertionValue resolves what an assertion compares to: the variable it
+// names if one was saved, or else the value itself as a literal.
+func assertionValue(value string, node int, env []string) string {
+	value = config.ForNode(value, node)
+	if saved := config.LookupVariable(value, env); saved != "" {
+		return saved
+	}
+	return value
+}
+
+// AllPassed tells whether every assertion passed.
+func AllPassed(assertions []report.AssertionResult) bool {
+	for _, assertion := range assertions {
+		if !assertion.Passed {
+			return false
+		}
+	}
+	return true
+}
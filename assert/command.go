@@ -0,0 +1,58 @@
+package assert
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// commandTimeout bounds how long the command of an assertion may take.
+const commandTimeout = time.Minute
+
+// evaluateCommand pipes the output of a node to the command of an assertion,
+// run with sh on the machine of the runner, which passes when it exits with
+// 0. The command gets the step environment and the node as NODE, and what it
+// prints is the actual value of the assertion.
+func evaluateCommand(assertion config.Assertion, node int, out []string, env []string) report.AssertionResult {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+	command := config.ForNode(assertion.Command, node)
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = os.Environ()
+	for _, e := range env {
+		found := config.EnvVarRegexp.FindStringSubmatch(e)
+		if len(found) == 3 {
+			cmd.Env = append(cmd.Env, found[1]+"="+found[2])
+		}
+	}
+	cmd.Env = append(cmd.Env, "NODE="+strconv.Itoa(node))
+	lines := OutputLines(out)
+	if len(lines) != 0 {
+		cmd.Stdin = strings.NewReader(strings.Join(lines, "\n") + "\n")
+	}
+	printed := new(bytes.Buffer)
+	cmd.Stdout = printed
+	cmd.Stderr = printed
+	err := cmd.Run()
+	actual := strings.TrimRight(printed.String(), "\n")
+	switch {
+	case ctx.Err() != nil:
+		actual = fmt.Sprintf("timed out after %s", commandTimeout)
+	case err != nil && actual == "":
+		actual = err.Error()
+	}
+	return report.AssertionResult{
+		Line:     assertion.Line,
+		Expected: "command succeeding: " + command,
+		Actual:   actual,
+		Passed:   err == nil,
+	}
+}
@@ -0,0 +1,44 @@
+package assert
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/itchyny/gojq"
+)
+
+// ParseJQ parses a jq expression of an assertion or a when.
+func ParseJQ(expression string) (*gojq.Query, error) {
+	return gojq.Parse(expression)
+}
+
+// evaluateJQ runs a jq expression on the output of a node, parsed as JSON,
+// and returns its first result as text: strings as they are, anything else
+// as JSON, e.g. `.Peers | length >= 4` gives "true".
+func evaluateJQ(expression string, out []string) (string, error) {
+	query, err := ParseJQ(expression)
+	if err != nil {
+		return "", err
+	}
+	var input interface{}
+	err = json.Unmarshal([]byte(strings.Join(out, "\n")), &input)
+	if err != nil {
+		return "", fmt.Errorf("output is not JSON: %s", err)
+	}
+	value, ok := query.Run(input).Next()
+	if !ok {
+		return "", fmt.Errorf("%s gave no result", expression)
+	}
+	if err, ok := value.(error); ok {
+		return "", err
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	text, err := gojq.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/runner"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// defaultSelector matches the go-ipfs deployment created by init.sh.
+const defaultSelector = "run=go-ipfs-stress"
+
+// configPath is the defaults file given with --config.
+var configPath string
+
+func newRootCommand() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "kubernetes-ipfs",
+		Short: "Run tests against ipfs nodes on Kubernetes",
+		Long: "kubernetes-ipfs runs test files describing steps on ipfs nodes deployed on\n" +
+			"Kubernetes, and checks their outcome.",
+		SilenceUsage: true,
+	}
+	root.PersistentFlags().StringVar(&configPath, "config", "", "file with defaults for every test (default ~/"+runner.DefaultsFile+")")
+	root.PersistentFlags().BoolVar(&runner.InCluster, "in-cluster", false, "talk to the cluster with the service account of the pod running kubernetes-ipfs")
+	root.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if runner.InCluster {
+			return runner.UseServiceAccount()
+		}
+		return nil
+	}
+	root.AddCommand(newRunCommand(), newSoakCommand(), newJobCommand(), newServeCommand(), newValidateCommand(), newListCommand(), newScaleCommand(), newCleanCommand(), newInitCommand())
+	return root
+}
+
+func newRunCommand() *cobra.Command {
+	opts := &runner.Options{Values: make(config.SetValues)}
+	cmd := &cobra.Command{
+		Use:   "run <testfile|builtin:name>",
+		Short: "Run a test file or a built-in scenario",
+		Long: "Run a test file or a built-in scenario. The exit code is 0 when the\n" +
+			"expectations of the test were met, 1 otherwise.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var err error
+			opts.Defaults, err = runner.LoadDefaults(configPath)
+			if err != nil {
+				return err
+			}
+			runTest(args[0], opts)
+			return nil
+		},
+	}
+	addRunFlags(cmd.Flags(), opts)
+	return cmd
+}
+
+// addRunFlags adds the flags of a run, shared by run and soak.
+func addRunFlags(flags *pflag.FlagSet, opts *runner.Options) {
+	flags.StringVar(&opts.ReportFormat, "report", "", "write a report of the run in the given format (sqlite, influx, json, tap)")
+	flags.StringVar(&opts.ReportFile, "report-file", "", "file to write the report to")
+	flags.BoolVar(&opts.Anonymize, "anonymize", false, "replace pod names, IPs and cluster endpoints with pseudonyms in the summary and report")
+	flags.StringVar(&opts.Pushgateway, "pushgateway", "", "push the results of every iteration to the Prometheus Pushgateway at this URL")
+	flags.StringVar(&opts.Grafana, "grafana", "", "annotate the test and its steps on the Grafana at this URL")
+	flags.StringVar(&opts.EventsOut, "events-out", "", "stream the progress of the run to this file as newline-delimited JSON")
+	flags.StringVar(&opts.HTMLReport, "html-report", "", "write a self-contained HTML report of the run to this file")
+	flags.StringVar(&opts.OutputDir, "output-dir", "", "write the full output of every node of every step below this directory")
+	flags.StringVar(&opts.BaselinePath, "baseline", "", "compare step timings to a run saved with --report json")
+	flags.Float64Var(&opts.RegressionThreshold, "regression-threshold", 20, "percentage by which a timing may exceed the baseline")
+	flags.BoolVar(&opts.WarnOnRegression, "warn-on-regression", false, "only warn instead of failing when timings regress")
+	flags.Var(opts.Values, "set", "set a template value of the test, as key=value (repeatable)")
+	flags.IntVar(&opts.Nodes, "nodes", 0, "override the number of nodes of the test")
+	flags.IntVar(&opts.Times, "times", 0, "override the number of iterations of the test")
+	flags.StringVar(&opts.Selector, "selector", "", "override the selector of the test's pods")
+	flags.Float64Var(&opts.TimeoutScale, "timeout-scale", 1, "multiply every step and poll timeout by this factor")
+	flags.Int64Var(&opts.Seed, "seed", 0, "seed of the run's randomness, printed in the summary to reproduce a run (default random)")
+	flags.StringVar(&opts.StateFile, "state-file", "", "save the progress of the run to this file after every step, for --resume")
+	flags.BoolVar(&opts.Resume, "resume", false, "go on with the run interrupted while saving its progress to --state-file")
+	flags.BoolVar(&opts.Watch, "watch", false, "run the test again whenever its file or libraries change, cancelling the run going on")
+	flags.BoolVar(&opts.Step, "step", false, "pause before every step to run, skip or abort it")
+	flags.BoolVar(&opts.FailFast, "fail-fast", false, "stop the run at the first failing step, still tearing down and reporting")
+	flags.StringSliceVar(&opts.Tags, "tags", nil, "only run the steps carrying one of these tags (comma separated)")
+	flags.StringSliceVar(&opts.SkipTags, "skip-tags", nil, "don't run the steps carrying one of these tags (comma separated)")
+	flags.StringVar(&opts.Backend, "backend", "kubernetes", "where the nodes run: kubernetes, iptb for the nodes of a local iptb testbed, or docker for local containers")
+}
+
+func newValidateCommand() *cobra.Command {
+	values := make(config.SetValues)
+	cmd := &cobra.Command{
+		Use:   "validate <testfile|builtin:name>...",
+		Short: "Check test files without running them",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defaults, err := runner.LoadDefaults(configPath)
+			if err != nil {
+				return err
+			}
+			defaults.SetValues(values)
+			failed := false
+			for _, path := range args {
+				test, err := config.LoadTest(path, values)
+				if err == nil && len(test.Matrix) != 0 {
+					err = runner.ValidateMatrix(path, test.Matrix, values, defaults)
+				} else if err == nil {
+					defaults.ApplyTo(&test.Config)
+					err = runner.ValidateTest(test)
+				}
+				if err != nil {
+					color.Red("%s: %s", path, err)
+					failed = true
+					continue
+				}
+				color.Green("%s: ok", path)
+			}
+			if failed {
+				return fmt.Errorf("some tests are invalid")
+			}
+			return nil
+		},
+	}
+	cmd.Flags().Var(values, "set", "set a template value of the tests, as key=value (repeatable)")
+	return cmd
+}
+
+func newListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List the built-in scenarios",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, name := range config.BuiltinScenarios() {
+				fmt.Println(config.BuiltinPrefix + name)
+			}
+		},
+	}
+}
+
+func newScaleCommand() *cobra.Command {
+	cfg := &config.Config{}
+	cmd := &cobra.Command{
+		Use:   "scale <nodes>",
+		Short: "Scale the ipfs deployment and wait for its pods",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			nodes, err := strconv.Atoi(args[0])
+			if err != nil || nodes < 0 {
+				return fmt.Errorf("invalid number of nodes: %s", args[0])
+			}
+			cfg.Nodes = nodes
+			defaults, err := runner.LoadDefaults(configPath)
+			if err != nil {
+				return err
+			}
+			defaults.ApplyTo(cfg)
+			if cfg.Selector == "" {
+				cfg.Selector = defaultSelector
+			}
+			runner.Namespace = cfg.Namespace
+			return runner.ScaleTo(cfg)
+		},
+	}
+	cmd.Flags().StringVar(&cfg.Selector, "selector", "", "selector of the deployment's pods (default "+defaultSelector+")")
+	cmd.Flags().StringVar(&cfg.Deployment, "deployment", "", "name of the deployment (default "+config.DEPLOYMENT_NAME+")")
+	cmd.Flags().StringVar(&cfg.WorkloadKind, "workload-kind", "", "kind of the workload, deployment, statefulset or daemonset (default deployment)")
+	return cmd
+}
+
+func newCleanCommand() *cobra.Command {
+	var selector string
+	var locks bool
+	cmd := &cobra.Command{
+		Use:   "clean",
+		Short: "Remove what crashed or interrupted runs left behind",
+		Long: "Remove what crashed or interrupted runs left behind: partition\n" +
+			"NetworkPolicies and pod labels, traffic shaping on the pods, and with\n" +
+			"--locks the lock ConfigMaps (only when no other run is going on).",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			defaults, err := runner.LoadDefaults(configPath)
+			if err != nil {
+				return err
+			}
+			cfg := &config.Config{Selector: selector}
+			defaults.ApplyTo(cfg)
+			if cfg.Selector == "" {
+				cfg.Selector = defaultSelector
+			}
+			runner.Namespace = cfg.Namespace
+			return clean(cfg.Selector, locks)
+		},
+	}
+	cmd.Flags().StringVar(&selector, "selector", "", "selector of the pods to clean up (default "+defaultSelector+")")
+	cmd.Flags().BoolVar(&locks, "locks", false, "also delete the lock ConfigMaps")
+	return cmd
+}
+
+// clean undoes the cluster changes tests make and normally revert
+// themselves.
+func clean(selector string, locks bool) error {
+	color.Blue("### Deleting partition NetworkPolicies")
+	err := runner.Kubectl("delete", "networkpolicy", "-l", "kubernetes-ipfs/partition")
+	if err != nil {
+		return err
+	}
+	pods, err := runner.GetPodsBySelector(selector)
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods.Items {
+		for label := range pod.Metadata.Labels {
+			if strings.HasPrefix(label, runner.PartitionLabel) {
+				color.Blue("### Removing %s from %s", label, pod.Metadata.Name)
+				err = runner.Kubectl("label", "pod", pod.Metadata.Name, label+"-")
+				if err != nil {
+					return err
+				}
+			}
+		}
+		color.Blue("### Resetting traffic shaping on %s", pod.Metadata.Name)
+		runner.RunInPod(pod.Metadata.Name, runner.ShapeResetCmd(nil), nil, 30)
+	}
+	if locks {
+		names, err := runner.KubectlOutput("get", "configmap", "-o", "name")
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if strings.HasPrefix(name, "configmap/"+runner.LockConfigMap("")) {
+				color.Blue("### Deleting lock %s", name)
+				err = runner.Kubectl("delete", name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// boundOps are the comparisons a Bound accepts, longest first so that ">="
+// isn't read as ">".
+var boundOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// Bound is an expected count. In a test file it is either a plain number,
+// which must be matched exactly, or a comparison like ">= 95".
+type Bound struct {
+	Op    string
+	Value int
+	// Set records whether the test file gave the bound at all.
+	Set bool
+}
+
+func (b *Bound) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	err := unmarshal(&s)
+	if err != nil {
+		return err
+	}
+	bound, err := parseBound(s)
+	if err != nil {
+		return err
+	}
+	*b = bound
+	return nil
+}
+
+func parseBound(s string) (Bound, error) {
+	s = strings.TrimSpace(s)
+	op := "=="
+	for _, candidate := range boundOps {
+		if strings.HasPrefix(s, candidate) {
+			op = candidate
+			s = strings.TrimSpace(strings.TrimPrefix(s, candidate))
+			break
+		}
+	}
+	value, err := strconv.Atoi(s)
+	if err != nil {
+		return Bound{}, fmt.Errorf("invalid expected count %q, want a number optionally preceded by one of %s", s, strings.Join(boundOps, " "))
+	}
+	return Bound{Op: op, Value: value, Set: true}, nil
+}
+
+// Matches reports whether n satisfies the bound. An unset bound expects zero.
+func (b Bound) Matches(n int) bool {
+	switch b.Op {
+	case ">=":
+		return n >= b.Value
+	case "<=":
+		return n <= b.Value
+	case "!=":
+		return n != b.Value
+	case ">":
+		return n > b.Value
+	case "<":
+		return n < b.Value
+	default:
+		return n == b.Value
+	}
+}
+
+func (b Bound) String() string {
+	if b.Op == "" || b.Op == "==" {
+		return strconv.Itoa(b.Value)
+	}
+	return b.Op + " " + strconv.Itoa(b.Value)
+}
+
+// LineCount is the number of lines an output should have: either a plain
+// number, or any of exact, min and max.
+type LineCount struct {
+	Exact *int `yaml:"exact"`
+	Min   *int `yaml:"min"`
+	Max   *int `yaml:"max"`
+}
+
+func (c *LineCount) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var exact int
+	if err := unmarshal(&exact); err == nil {
+		c.Exact = &exact
+		return nil
+	}
+	type plain LineCount
+	return unmarshal((*plain)(c))
+}
+
+// Matches reports whether n lines satisfy the count.
+func (c LineCount) Matches(n int) bool {
+	return (c.Exact == nil || n == *c.Exact) && (c.Min == nil || n >= *c.Min) && (c.Max == nil || n <= *c.Max)
+}
+
+func (c LineCount) String() string {
+	var parts []string
+	if c.Exact != nil {
+		parts = append(parts, strconv.Itoa(*c.Exact))
+	}
+	if c.Min != nil {
+		parts = append(parts, "at least "+strconv.Itoa(*c.Min))
+	}
+	if c.Max != nil {
+		parts = append(parts, "at most "+strconv.Itoa(*c.Max))
+	}
+	return strings.Join(parts, " and ") + " lines"
+}
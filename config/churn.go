@@ -0,0 +1,30 @@
+package config
+
+// Churn takes nodes down and brings them back in the background while the
+// steps of every iteration run, one node every Rate.
+type Churn struct {
+	// Rate is the time between two nodes going down, e.g. "30s".
+	Rate string `yaml:"rate"`
+	// Duration is how long churn lasts from the start of the iteration,
+	// e.g. "5m", the whole iteration when not set.
+	Duration string `yaml:"duration"`
+	// Nodes churned, all of the group's, or the test's, when not set.
+	OnGroup string `yaml:"on_group"`
+	OnNode  int    `yaml:"on_node"`
+	EndNode int    `yaml:"end_node"`
+	// Mode is daemon, which kills the ipfs daemon and restarts it, pod,
+	// which deletes the pod for its workload to replace, or scale, which
+	// scales the workload down a replica and back up. daemon when not set.
+	Mode string `yaml:"mode"`
+	// Downtime before a daemon is restarted or a workload scaled back up,
+	// e.g. "10s", none when not set.
+	Downtime string `yaml:"downtime"`
+}
+
+// ChurnMode returns how churn takes nodes down.
+func (churn *Churn) ChurnMode() string {
+	if churn.Mode == "" {
+		return "daemon"
+	}
+	return churn.Mode
+}
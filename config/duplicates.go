@@ -0,0 +1,9 @@
+package config
+
+// DuplicateBlocks reads the bitswap stats of every node of a step, and
+// computes the share of the blocks it received that it already had.
+type DuplicateBlocks struct {
+	// MaxRatio the duplicate blocks may reach, e.g. 0.1 for 10% of the
+	// blocks received, not checked when not set.
+	MaxRatio *float64 `yaml:"max_ratio"`
+}
@@ -0,0 +1,20 @@
+package config
+
+// Gateway fetches content over the HTTP gateway of every node of a step, and
+// checks the response: its status, the SHA-256 of its body and how long it
+// took. The node's output is a line of JSON describing the response.
+type Gateway struct {
+	// CID fetched as /ipfs/<cid>, or Path fetched as is, e.g.
+	// /ipns/$NAME/index.html.
+	CID  string `yaml:"cid"`
+	Path string `yaml:"path"`
+	// URL is a gateway used instead of the node's, reached from the
+	// runner, e.g. a service like http://ipfs-gateway.default.svc:8080.
+	URL string `yaml:"url"`
+	// Status the response must have, 200 when not set.
+	Status int `yaml:"status"`
+	// SHA256 the body must have, in hex, e.g. $SUM.
+	SHA256 string `yaml:"sha256"`
+	// MaxLatency the whole fetch may take, e.g. "2s".
+	MaxLatency string `yaml:"max_latency"`
+}
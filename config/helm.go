@@ -0,0 +1,15 @@
+package config
+
+// HelmRelease is a Helm chart installed, or upgraded, with the test's values
+// before the test runs, e.g. an ipfs or ipfs-cluster chart.
+type HelmRelease struct {
+	Release string `yaml:"release"`
+	Chart   string `yaml:"chart"`
+	Version string `yaml:"version"`
+	// Values files, relative to the test file, and values set by the test,
+	// which take precedence
+	ValuesFiles []string               `yaml:"values_files"`
+	Values      map[string]interface{} `yaml:"values"`
+	// Uninstall the release after the test
+	Uninstall bool `yaml:"uninstall"`
+}
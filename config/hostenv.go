@@ -0,0 +1,19 @@
+package config
+
+// HostVariable is a variable of the environment kubernetes-ipfs runs in,
+// passed on to the steps. Values of secret ones are masked in the logs.
+type HostVariable struct {
+	Name   string `yaml:"name"`
+	Secret bool   `yaml:"secret"`
+}
+
+// UnmarshalYAML accepts a plain name as well as a name and secret flag.
+func (v *HostVariable) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if unmarshal(&name) == nil {
+		v.Name = name
+		return nil
+	}
+	type plain HostVariable
+	return unmarshal((*plain)(v))
+}
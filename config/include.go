@@ -0,0 +1,104 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Library is a file of named step sequences shared by tests, e.g. connecting
+// every node to the others before the steps under test.
+type Library struct {
+	Include   []string          `yaml:"include"`
+	Sequences map[string][]Step `yaml:"sequences"`
+}
+
+// includeSteps loads the libraries, relative to dir, and replaces the steps
+// that use one of their sequences with its steps. Libraries are rendered with
+// the same template data as the test and may include other libraries. It
+// also returns the paths of the libraries it loaded.
+func includeSteps(dir string, includes []string, steps []Step, data map[string]interface{}) ([]Step, []string, error) {
+	sequences := make(map[string][]Step)
+	loaded := make(map[string]bool)
+	err := loadLibraries(dir, includes, data, sequences, loaded)
+	if err != nil {
+		return nil, nil, err
+	}
+	paths := make([]string, 0, len(loaded))
+	for path := range loaded {
+		paths = append(paths, path)
+	}
+	steps, err = expandSteps(steps, sequences, nil)
+	return steps, paths, err
+}
+
+func loadLibraries(dir string, includes []string, data map[string]interface{}, sequences map[string][]Step, loaded map[string]bool) error {
+	for _, include := range includes {
+		path := include
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		if loaded[path] {
+			continue
+		}
+		loaded[path] = true
+		fileData, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rendered, err := render(path, fileData, data)
+		if err != nil {
+			return err
+		}
+		library := new(Library)
+		err = yaml.UnmarshalStrict(rendered, library)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		for name, steps := range library.Sequences {
+			if _, ok := sequences[name]; ok {
+				return fmt.Errorf("%s: sequence %s is defined twice", path, name)
+			}
+			sequences[name] = steps
+		}
+		err = loadLibraries(filepath.Dir(path), library.Include, data, sequences, loaded)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expandSteps replaces the steps using a sequence with the sequence's steps,
+// which may use other sequences in turn. using holds the sequences being
+// expanded, to catch cycles.
+func expandSteps(steps []Step, sequences map[string][]Step, using []string) ([]Step, error) {
+	var expanded []Step
+	for _, step := range steps {
+		if step.Use == "" {
+			expanded = append(expanded, step)
+			continue
+		}
+		if !reflect.DeepEqual(step, Step{Use: step.Use}) {
+			return nil, fmt.Errorf("step using %s can't set anything else", step.Use)
+		}
+		sequence, ok := sequences[step.Use]
+		if !ok {
+			return nil, fmt.Errorf("unknown sequence %s", step.Use)
+		}
+		for _, name := range using {
+			if name == step.Use {
+				return nil, fmt.Errorf("sequence %s uses itself", step.Use)
+			}
+		}
+		inner, err := expandSteps(sequence, sequences, append(using, step.Use))
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, inner...)
+	}
+	return expanded, nil
+}
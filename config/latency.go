@@ -0,0 +1,26 @@
+package config
+
+// Latency measures how long the content a node adds takes to be found by the
+// other nodes of a step. The provider adds it, then every other node looks
+// it up until it succeeds: with findprovs, until the DHT lists the provider
+// for its CID; with resolve, until the IPNS name the provider published it
+// under resolves to it. The step's timeout bounds each node's lookups.
+type Latency struct {
+	// Provider is the node adding the content, among those of the step's
+	// group when it has one.
+	Provider int `yaml:"provider"`
+	// Measure is findprovs, the default, or resolve.
+	Measure string `yaml:"measure"`
+	// Content added by the provider, unique to the step and iteration when
+	// empty, so every measure starts from a CID nobody provides yet.
+	Content string `yaml:"content"`
+}
+
+// Lookup returns what the nodes of a latency step wait for, findprovs when
+// the step doesn't say.
+func (latency *Latency) Lookup() string {
+	if latency.Measure == "" {
+		return "findprovs"
+	}
+	return latency.Measure
+}
@@ -0,0 +1,10 @@
+package config
+
+// Notify posts the outcome of a run to a webhook when the run completes or
+// aborts. The body is the template rendered with the summary, plus Passed,
+// Error and a one line Message; the default posts the message the way Slack's
+// incoming webhooks expect it.
+type Notify struct {
+	URL      string `yaml:"url"`
+	Template string `yaml:"template"`
+}
@@ -0,0 +1,17 @@
+package config
+
+// Partition splits the test pods into sides that can only reach pods on
+// their own side, using one NetworkPolicy per side. It only affects pod
+// network traffic, so kubectl exec keeps working, and it needs a network
+// plugin that enforces NetworkPolicies.
+type Partition struct {
+	Name  string `yaml:"name"`
+	Sides []Side `yaml:"sides"`
+}
+
+// Side is one side of a partition, selected like the nodes of a step.
+type Side struct {
+	OnGroup string `yaml:"on_group"`
+	OnNode  int    `yaml:"on_node"`
+	EndNode int    `yaml:"end_node"`
+}
@@ -0,0 +1,13 @@
+package config
+
+// assignPhases puts the steps below a step starting a phase in that phase,
+// until the next one starts.
+func assignPhases(steps []Step) {
+	phase := ""
+	for i := range steps {
+		if steps[i].Phase != "" {
+			phase = steps[i].Phase
+		}
+		steps[i].Phase = phase
+	}
+}
@@ -0,0 +1,9 @@
+package config
+
+// Poll makes a step re-run its command every Interval seconds until all of
+// its assertions pass, giving up after Timeout seconds. The step's own
+// timeout still applies to each attempt.
+type Poll struct {
+	Interval int `yaml:"interval"`
+	Timeout  int `yaml:"timeout"`
+}
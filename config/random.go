@@ -0,0 +1,33 @@
+package config
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// runRand is the source of the randomness of a run, seeded with the run's
+// seed so a run can be reproduced with --seed. Only template rendering uses
+// it, which happens one test at a time.
+var runRand = rand.New(rand.NewSource(1))
+
+// SeedRun seeds the run's randomness, with a seed of its own when none is
+// given, and returns the seed.
+func SeedRun(seed int64) int64 {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	runRand.Seed(seed)
+	color.Cyan("## Seed %d, reproduce with --seed %d", seed, seed)
+	return seed
+}
+
+// randInt returns a random number from min to max, both included.
+func randInt(min, max interface{}) int {
+	low, high := toInt(min), toInt(max)
+	if high < low {
+		return low
+	}
+	return low + runRand.Intn(high-low+1)
+}
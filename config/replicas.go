@@ -0,0 +1,13 @@
+package config
+
+// Replicas checks on which nodes of a step a CID is pinned, or stored with
+// blocks, and that enough of them have it.
+type Replicas struct {
+	CID string `yaml:"cid"`
+	// Blocks checks that the nodes store the block of the CID, pinned or
+	// not, with ipfs block stat --offline, instead of ipfs pin ls.
+	Blocks bool `yaml:"blocks"`
+	// Count is how many nodes must have it, as a number or min and max,
+	// all of the step's when not set.
+	Count *LineCount `yaml:"count"`
+}
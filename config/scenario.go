@@ -0,0 +1,229 @@
+package config
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// BuiltinPrefix marks a test path naming one of the scenarios shipped with
+// the binary.
+const BuiltinPrefix = "builtin:"
+
+// nodeIndexPlaceholder is left in the test by the rendering at load time and
+// replaced with the node number when a step runs on a node.
+const nodeIndexPlaceholder = "{{.NodeIndex}}"
+
+//go:embed scenarios/*.yml
+var scenarios embed.FS
+
+// SetValues collects the key=value pairs given with --set.
+type SetValues map[string]interface{}
+
+func (v SetValues) String() string {
+	pairs := make([]string, 0, len(v))
+	for key, value := range v {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (v SetValues) Type() string {
+	return "key=value"
+}
+
+func (v SetValues) Set(pair string) error {
+	parts := strings.SplitN(pair, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return fmt.Errorf("expected key=value, got %s", pair)
+	}
+	v[parts[0]] = parts[1]
+	return nil
+}
+
+// BuiltinScenarios lists the names of the shipped scenarios.
+func BuiltinScenarios() []string {
+	entries, _ := scenarios.ReadDir("scenarios")
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".yml"))
+	}
+	return names
+}
+
+// LoadTest reads a test file, or a built-in scenario, renders it as a
+// template with the given values and parses it.
+func LoadTest(filePath string, values SetValues) (*Test, error) {
+	var fileData []byte
+	var err error
+	if strings.HasPrefix(filePath, BuiltinPrefix) {
+		name := strings.TrimPrefix(filePath, BuiltinPrefix)
+		fileData, err = scenarios.ReadFile(path.Join("scenarios", name+".yml"))
+		if err != nil {
+			return nil, fmt.Errorf("unknown built-in scenario %s, available: %s", name, strings.Join(BuiltinScenarios(), ", "))
+		}
+	} else {
+		fileData, err = ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	data := map[string]interface{}{"NodeIndex": nodeIndexPlaceholder}
+	for key, value := range values {
+		data[key] = value
+	}
+	rendered, err := render(filePath, fileData, data)
+	if err != nil {
+		return nil, err
+	}
+
+	test := new(Test)
+	err = yaml.Unmarshal(rendered, test)
+	if err != nil {
+		return nil, err
+	}
+	// Includes of built-in scenarios are relative to the working directory.
+	dir := "."
+	if !strings.HasPrefix(filePath, BuiltinPrefix) {
+		dir = filepath.Dir(filePath)
+	}
+	test.Steps, test.Libraries, err = includeSteps(dir, test.Include, test.Steps, data)
+	if err != nil {
+		return nil, err
+	}
+	assignPhases(test.Steps)
+	test.Config.Manifest = relativeTo(dir, test.Config.Manifest)
+	for i := range test.Config.Groups {
+		test.Config.Groups[i].Manifest = relativeTo(dir, test.Config.Groups[i].Manifest)
+	}
+	for _, release := range test.Config.Helm {
+		for i, file := range release.ValuesFiles {
+			release.ValuesFiles[i] = relativeTo(dir, file)
+		}
+	}
+	return test, nil
+}
+
+// relativeTo resolves a path given relative to the directory of a test.
+func relativeTo(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
+
+// render executes a test file, or a library it includes, as a template.
+func render(name string, fileData []byte, data map[string]interface{}) ([]byte, error) {
+	tmpl, err := template.New(name).Option("missingkey=zero").Funcs(templateFuncs).Parse(string(fileData))
+	if err != nil {
+		return nil, err
+	}
+	var rendered bytes.Buffer
+	err = tmpl.Execute(&rendered, data)
+	if err != nil {
+		return nil, err
+	}
+	return rendered.Bytes(), nil
+}
+
+// ForNode fills in the node number of a command, argument or assertion.
+func ForNode(s string, node int) string {
+	return strings.Replace(s, nodeIndexPlaceholder, strconv.Itoa(node), -1)
+}
+
+// templateFuncs are available in test files. Values given with --set are
+// strings, so the arithmetic helpers convert their arguments.
+var templateFuncs = template.FuncMap{
+	"default": func(def interface{}, value interface{}) interface{} {
+		if value == nil || value == "" {
+			return def
+		}
+		return value
+	},
+	"add": func(a, b interface{}) int { return toInt(a) + toInt(b) },
+	"sub": func(a, b interface{}) int { return toInt(a) - toInt(b) },
+	"mul": func(a, b interface{}) int { return toInt(a) * toInt(b) },
+	"seq": func(start, end interface{}) []int {
+		var seq []int
+		for i := toInt(start); i <= toInt(end); i++ {
+			seq = append(seq, i)
+		}
+		return seq
+	},
+	// Random from the run's seed, e.g. a node to kill
+	"randInt": randInt,
+}
+
+func toInt(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case string:
+		i, _ := strconv.Atoi(v)
+		return i
+	default:
+		i, _ := strconv.Atoi(fmt.Sprint(v))
+		return i
+	}
+}
+
+// SplitWords splits a raw command into its words like a shell would, minus
+// any expansion: words are separated by blanks, quotes group them and a
+// backslash escapes the next character outside of single quotes.
+func SplitWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %s", s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
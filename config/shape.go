@@ -0,0 +1,35 @@
+package config
+
+import (
+	"strings"
+)
+
+// Shape describes network conditions emulated with `tc netem` on a node's
+// outgoing traffic. With ToGroup, only traffic towards that group's pods is
+// affected. The pods need the NET_ADMIN capability and the tc binary.
+type Shape struct {
+	Latency string `yaml:"latency"`
+	Jitter  string `yaml:"jitter"`
+	Loss    string `yaml:"loss"`
+	Rate    string `yaml:"rate"`
+	Device  string `yaml:"device"`
+	ToGroup string `yaml:"to_group"`
+}
+
+// Netem returns the netem parameters for the shape.
+func (shape *Shape) Netem() string {
+	var params []string
+	if shape.Latency != "" {
+		params = append(params, "delay", shape.Latency)
+		if shape.Jitter != "" {
+			params = append(params, shape.Jitter)
+		}
+	}
+	if shape.Loss != "" {
+		params = append(params, "loss", shape.Loss)
+	}
+	if shape.Rate != "" {
+		params = append(params, "rate", shape.Rate)
+	}
+	return strings.Join(params, " ")
+}
@@ -0,0 +1,29 @@
+package config
+
+// Stress puts the nodes of a step under CPU, memory or IO load with
+// stress-ng for a while, and can shrink the resources of their pods for as
+// long, to test how they hold up under resource pressure.
+type Stress struct {
+	// CPU is the number of workers loading the CPU, each at CPULoad
+	// percent, 100 when not set.
+	CPU     int `yaml:"cpu"`
+	CPULoad int `yaml:"cpu_load"`
+	// Memory kept allocated and written to, e.g. "256M".
+	Memory string `yaml:"memory"`
+	// IO is the number of workers syncing to disk.
+	IO int `yaml:"io"`
+	// Duration of the load, e.g. "60s".
+	Duration string `yaml:"duration"`
+	// Background leaves the load running past the step, so the steps after
+	// it run under it.
+	Background bool `yaml:"background"`
+	// Limits the first container of the pods is resized to in place for
+	// the duration, e.g. cpu: 500m and memory: 512Mi.
+	Limits map[string]string `yaml:"limits"`
+}
+
+// Load tells whether the stress runs stress-ng, rather than only resizing
+// the pods.
+func (stress *Stress) Load() bool {
+	return stress.CPU != 0 || stress.Memory != "" || stress.IO != 0
+}
@@ -0,0 +1,412 @@
+// Package config holds the tests of kubernetes-ipfs, their steps and
+// configuration, and loads them from their YAML files.
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var DEPLOYMENT_NAME = "go-ipfs-stress"
+
+// Output is
+type Output struct {
+	Line   int    `yaml:"line"`
+	SaveTo string `yaml:"save_to"`
+	// Save the line of an earlier step's output instead of this step's
+	FromStep string `yaml:"from_step"`
+}
+
+// StepOutput names a line of the output of an earlier step in the iteration,
+// on the same node or on the only node the step ran on.
+type StepOutput struct {
+	FromStep string `yaml:"from_step"`
+	Line     int    `yaml:"line"`
+}
+
+// Assertion is
+type Assertion struct {
+	Line            int    `yaml:"line"`
+	ShouldBeEqualTo string `yaml:"should_be_equal_to"`
+	// Other comparisons, taking a variable or a literal like ShouldBeEqualTo
+	ShouldNotBeEqualTo string `yaml:"should_not_be_equal_to"`
+	ShouldContain      string `yaml:"should_contain"`
+	ShouldNotContain   string `yaml:"should_not_contain"`
+	// Compare the whole output, all lines joined, instead of one line
+	WholeOutput bool `yaml:"whole_output"`
+	// Check how many lines the output has instead of comparing
+	ShouldHaveLines *LineCount `yaml:"should_have_lines"`
+	// Compare the result of a jq expression on the output, parsed as JSON.
+	// Without a comparison, the result must be true.
+	JQ string `yaml:"jq"`
+	// Compare to a variable as saved by another node
+	ShouldBeEqualToVarOnNode *VarOnNode `yaml:"should_be_equal_to_var_on_node"`
+	// Compare to a line of an earlier step's output
+	ShouldBeEqualToStep *StepOutput `yaml:"should_be_equal_to_step"`
+	// Pipe the output to a local command instead of comparing, passing
+	// when it exits with 0
+	Command string `yaml:"command"`
+}
+
+// StderrAssertion checks what a node printed on stderr: that it printed
+// nothing, or that its stderr matches a regular expression.
+type StderrAssertion struct {
+	Empty   bool   `yaml:"empty"`
+	Matches string `yaml:"matches"`
+}
+
+// VarOnNode names the value a variable got on a given node.
+type VarOnNode struct {
+	Var  string `yaml:"var"`
+	Node int    `yaml:"node"`
+}
+
+// Step is
+type Step struct {
+	Name        string      `yaml:"name"`
+	OnGroup     string      `yaml:"on_group"`
+	OnNode      int         `yaml:"on_node"`
+	EndNode     int         `yaml:"end_node"`
+	CMD         string      `yaml:"cmd"`
+	Timeout     int         `yaml:"timeout"`
+	Outputs     []Output    `yaml:"outputs"`
+	Inputs      []string    `yaml:"inputs"`
+	Assertions  []Assertion `yaml:"assertions"`
+	SaveAllTo   string      `yaml:"save_all_to"`
+	WriteToFile string      `yaml:"write_to_file"`
+	Expected    *Expected   `yaml:"expected"`
+	Tags        []string    `yaml:"tags"`
+	Lock        string      `yaml:"lock"`
+
+	// Exit code CMD must return, 0 when not set, and checks of its stderr
+	ExpectExitCode   *int              `yaml:"expect_exit_code"`
+	StderrAssertions []StderrAssertion `yaml:"stderr_assertions"`
+
+	// Feed CMD a variable, or the output of an earlier step, on stdin
+	StdinFrom     string `yaml:"stdin_from"`
+	StdinFromStep string `yaml:"stdin_from_step"`
+
+	// Pause the run instead of doing anything on the nodes, e.g. "10s"
+	Wait string `yaml:"wait"`
+
+	// Re-run CMD until its assertions pass
+	Poll *Poll `yaml:"poll"`
+
+	// Built-in operation run instead of CMD, its arguments, and which of
+	// its result fields to save to which variables.
+	Op   string            `yaml:"op"`
+	Args map[string]string `yaml:"args"`
+	Save map[string]string `yaml:"save"`
+
+	// Time a cat on every node, its time to first byte and throughput, instead
+	// of saving what it fetched
+	MeasureTransfer bool `yaml:"measure_transfer"`
+
+	// Custom step type run instead of CMD, registered by a program embedding
+	// the runner or provided by a plugin, taking Args as well.
+	Type string `yaml:"type"`
+
+	// Traffic shaping applied to the step's nodes, and its removal
+	Shape      *Shape `yaml:"shape"`
+	ShapeReset bool   `yaml:"shape_reset"`
+
+	// Reset the repos of the step's nodes: gc unpins everything and collects
+	// the garbage, wipe deletes their blocks and datastore
+	ResetRepo string `yaml:"reset_repo"`
+
+	// Chaos: take the step's nodes down ("pod" deletes the pod, "daemon"
+	// kills the ipfs daemon), and wait for them to come back
+	KillNode          string `yaml:"kill_node"`
+	WaitForReschedule bool   `yaml:"wait_for_reschedule"`
+
+	// Network partition created or healed by the step
+	Partition *Partition `yaml:"partition"`
+	Heal      string     `yaml:"heal"`
+
+	// Time for the content added by a node to be found by the others over
+	// the DHT, measured instead of running CMD
+	Latency *Latency `yaml:"latency"`
+
+	// Collect the bitswap ledgers between every pair of the step's nodes
+	// instead of running CMD
+	BitswapLedgers bool `yaml:"bitswap_ledgers"`
+
+	// Ratio of duplicate blocks the step's nodes received, from their
+	// bitswap stats, computed instead of running CMD
+	DuplicateBlocks *DuplicateBlocks `yaml:"duplicate_blocks"`
+
+	// Load put on the step's nodes, and resources their pods are resized
+	// to, instead of running CMD
+	Stress *Stress `yaml:"stress"`
+
+	// Content fetched over the nodes' HTTP gateway instead of running CMD
+	Gateway *Gateway `yaml:"gateway"`
+
+	// Nodes of the step a CID must be pinned or stored on, checked instead
+	// of running CMD
+	Replicas *Replicas `yaml:"replicas"`
+
+	// PromQL query run against the cluster's Prometheus instead of CMD
+	PromQL string `yaml:"promql"`
+
+	// ipfs-cluster helpers, run on the cluster pods instead of CMD
+	ClusterPin     string `yaml:"cluster_pin"`
+	Replication    int    `yaml:"replication"`
+	ClusterStatus  string `yaml:"cluster_status"`
+	AssertPinnedOn int    `yaml:"assert_pinned_on"`
+
+	// Named sequence of an included library, run in place of this step
+	Use string `yaml:"use"`
+
+	// Phase starting with this step, e.g. "setup", which the steps below
+	// belong to until the next phase starts
+	Phase string `yaml:"phase"`
+
+	// Steps this one waits for. Once a step has depends_on, every step only
+	// waits for its own and runs alongside the others.
+	DependsOn []string `yaml:"depends_on"`
+
+	// Exec the words of CMD without a shell, for images that have none
+	Raw bool `yaml:"raw"`
+
+	// Nodes the step runs on at once, all of them when not set
+	MaxParallel int `yaml:"max_parallel"`
+
+	// jq expression over the state of the run, the step only runs when it
+	// holds, e.g. ".iteration == 1"
+	When string `yaml:"when"`
+}
+
+// Config is
+type Config struct {
+	Nodes           int       `yaml:"nodes"`
+	Selector        string    `yaml:"selector"`
+	Deployment      string    `yaml:"deployment"`
+	WorkloadKind    string    `yaml:"workload_kind"`
+	Groups          []Group   `yaml:"groups"`
+	ClusterSelector string    `yaml:"cluster_selector"`
+	Times           int       `yaml:"times"`
+	GraceShutdown   string    `yaml:"grace_shutdown"`
+	WaitForScrape   bool      `yaml:"wait_for_scrape"`
+	MonitorPods     bool      `yaml:"monitor_pods"`
+	SampleResources string    `yaml:"sample_resources"`
+	Observe         *Observe  `yaml:"observe"`
+	Expected        Expected  `yaml:"expected"`
+	PrivateNetwork  bool      `yaml:"private_network"`
+	RestartCmd      string    `yaml:"restart_cmd"`
+	Prometheus      string    `yaml:"prometheus"`
+	Notify          *Notify   `yaml:"notify"`
+	Namespace       string    `yaml:"namespace"`
+	DefaultTimeout  int       `yaml:"default_timeout"`
+	Variables       Variables `yaml:"variables"`
+	Shell           string    `yaml:"shell"`
+	MaxParallel     int       `yaml:"max_parallel"`
+	KubectlRate     float64   `yaml:"kubectl_rate"`
+	OutputLimit     int       `yaml:"output_limit"`
+	GlobalTimeout   string    `yaml:"global_timeout"`
+	FailFast        bool      `yaml:"fail_fast"`
+
+	// Iterations run at once, each on its own share of the nodes
+	ParallelIterations int `yaml:"parallel_iterations"`
+
+	// Reset the repos of the nodes before every iteration, with gc or wipe
+	// as for the reset_repo of a step
+	ResetRepo string `yaml:"reset_repo"`
+
+	// Remove the bootstrap peers of the nodes and disable MDNS before the
+	// first iteration, so they only connect to each other
+	Isolate bool `yaml:"isolate"`
+
+	// How the nodes are connected to each other before the first iteration
+	Topology *Topology `yaml:"topology"`
+
+	// Iterations run before the measured ones and left out of the results
+	WarmupIterations int `yaml:"warmup_iterations"`
+
+	// Variables of the runner's environment passed to the steps, and
+	// variables whose values are masked in everything the run writes
+	EnvFromHost []HostVariable    `yaml:"env_from_host"`
+	Secrets     map[string]string `yaml:"secrets"`
+
+	// Helm charts installed before the test
+	Helm []HelmRelease `yaml:"helm"`
+
+	// How long to wait for new pods to replace nodes whose pod went away or
+	// isn't ready, before every step. Unset, churn fails the steps.
+	WaitForReplacement string `yaml:"wait_for_replacement"`
+
+	// Nodes taken down and brought back in the background during every
+	// iteration
+	Churn *Churn `yaml:"churn"`
+
+	Provision `yaml:",inline"`
+
+	// Group is the group a config was made from, nil for the test's.
+	Group *Group `yaml:"-"`
+}
+
+// Group is a named set of nodes with its own selector and deployment, e.g.
+// providers and leechers. Steps address a group with on_group, and their
+// node numbers count from the start of that group.
+type Group struct {
+	Name       string `yaml:"name"`
+	Selector   string `yaml:"selector"`
+	Deployment string `yaml:"deployment"`
+	Nodes      int    `yaml:"nodes"`
+	Provision  `yaml:",inline"`
+
+	// Kind of the workload, deployment, statefulset or daemonset
+	WorkloadKind string `yaml:"workload_kind"`
+
+	// Kubeconfig context and namespace of a group living in another
+	// cluster, the test's when empty
+	Context   string `yaml:"context"`
+	Namespace string `yaml:"namespace"`
+}
+
+// Config returns the group as a Config for provisioning, scaling and pod
+// lookup.
+func (g Group) Config() *Config {
+	return &Config{Nodes: g.Nodes, Selector: g.Selector, Deployment: g.Deployment, WorkloadKind: g.WorkloadKind, Provision: g.Provision,
+		Group: &g}
+}
+
+// Remote reports whether the group lives in another cluster or namespace
+// than the test.
+func (g Group) Remote() bool {
+	return g.Context != "" || g.Namespace != ""
+}
+
+// Provision describes how a deployment's pod template is patched before the
+// test: where its pods may be scheduled, the container's image, and how it is
+// started (e.g. daemon flags like --enable-pubsub-experiment).
+type Provision struct {
+	Arch         string            `yaml:"arch"`
+	NodeSelector map[string]string `yaml:"node_selector"`
+	Image        string            `yaml:"image"`
+	Command      []string          `yaml:"command"`
+	Args         []string          `yaml:"args"`
+
+	// Manifest creating the deployment when it doesn't exist, relative to
+	// the test file, and whether to delete what it created after the test
+	Manifest       string `yaml:"manifest"`
+	DeleteManifest bool   `yaml:"delete_manifest"`
+}
+
+// Observe turns the grace_shutdown period into an observation window during
+// which the listed collectors (bandwidth, peers, logs) keep sampling the test
+// nodes. Without collectors, all of them run.
+type Observe struct {
+	Interval int      `yaml:"interval"`
+	Collect  []string `yaml:"collect"`
+}
+
+// Expected is the outcome a run, tag or step is expected to have. Counts
+// left out must be zero, unless a success rate is given, in which case only
+// the counts that are given are checked.
+type Expected struct {
+	Successes Bound `yaml:"successes"`
+	Failures  Bound `yaml:"failures"`
+	Timeouts  Bound `yaml:"timeouts"`
+	Errors    Bound `yaml:"errors"`
+	// SuccessRate is the minimum percentage of outcomes that must be
+	// successes.
+	SuccessRate *float64 `yaml:"success_rate"`
+	// Tags holds expectations for the steps carrying a tag. Outcomes of
+	// those steps are checked there and left out of the totals above.
+	Tags map[string]Expected `yaml:"tags"`
+}
+
+// NodeConfig is a set of `ipfs config` settings applied to a range of nodes
+// before the steps run. Without on_node it applies to every node.
+type NodeConfig struct {
+	OnNode   int                    `yaml:"on_node"`
+	EndNode  int                    `yaml:"end_node"`
+	Settings map[string]interface{} `yaml:"settings"`
+}
+
+// Test is
+type Test struct {
+	Name       string       `yaml:"name"`
+	Config     Config       `yaml:"config"`
+	NodeConfig []NodeConfig `yaml:"node_config"`
+	Steps      []Step       `yaml:"steps"`
+	// Tags are carried by every step, for --tags and --skip-tags.
+	Tags []string `yaml:"tags"`
+	// Include lists libraries of step sequences the steps can use.
+	Include []string `yaml:"include"`
+	// Matrix lists values the test is run with, once per combination.
+	Matrix map[string][]interface{} `yaml:"matrix"`
+
+	// Libraries are the paths of the libraries included.
+	Libraries []string `yaml:"-"`
+}
+
+// ApplyDefaultTimeout gives the steps without a timeout of their own the
+// test's default_timeout.
+func (test *Test) ApplyDefaultTimeout() {
+	for i := range test.Steps {
+		if test.Steps[i].Timeout == 0 {
+			test.Steps[i].Timeout = test.Config.DefaultTimeout
+		}
+	}
+}
+
+// LookupVariable finds the value of a variable in the step environment, or
+// returns "" if it was not saved. RESULT="abc abc" gives abc abc, without the
+// quotes, for RESULT.
+func LookupVariable(name string, env []string) string {
+	rex := regexp.MustCompile(fmt.Sprintf("(?s)^%s=\"(.*)\"$", name))
+	for _, e := range env {
+		found := rex.FindStringSubmatch(e)
+		if len(found) == 2 && found[1] != "" {
+			return found[1]
+		}
+	}
+	return ""
+}
+
+// TargetsNodes tells whether the step runs on a range of the main nodes.
+func (step *Step) TargetsNodes() bool {
+	return !IsClusterStep(step) && step.PromQL == "" && step.Wait == "" && !step.WaitForReschedule && step.Partition == nil && step.Heal == ""
+}
+
+// ParseWait parses the duration of a wait step: a Go duration like "1m30s",
+// or a plain number of seconds.
+func ParseWait(wait string) (time.Duration, error) {
+	seconds, err := strconv.Atoi(wait)
+	if err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(wait)
+}
+
+// deploymentName returns the deployment backing the config's pods.
+func (cfg *Config) deploymentName() string {
+	if cfg.Deployment == "" {
+		return DEPLOYMENT_NAME
+	}
+	return cfg.Deployment
+}
+
+// Workload returns the deployment, or the StatefulSet or DaemonSet of the
+// workload_kind, backing the config's pods, as kubectl names it.
+func (cfg *Config) Workload() string {
+	if cfg.WorkloadKind == "statefulset" || cfg.WorkloadKind == "daemonset" {
+		return cfg.WorkloadKind + "/" + cfg.deploymentName()
+	}
+	return "deployment/" + cfg.deploymentName()
+}
+
+// ValidWorkloadKind tells whether kind is a workload_kind the tests can run
+// against.
+func ValidWorkloadKind(kind string) bool {
+	return kind == "" || kind == "deployment" || kind == "statefulset" || kind == "daemonset"
+}
+
+// IsClusterStep tells whether the step talks to ipfs-cluster.
+func IsClusterStep(step *Step) bool {
+	return step.ClusterPin != "" || step.ClusterStatus != "" || step.AssertPinnedOn != 0
+}
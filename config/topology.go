@@ -0,0 +1,18 @@
+package config
+
+// Topology connects the nodes of a test to each other with ipfs swarm
+// connect before the first iteration, instead of leaving it to whatever
+// peers they discover over the DHT. Nodes are numbered across the test's
+// nodes, then its groups' in order.
+type Topology struct {
+	// Shape is mesh, ring, star, random (k-regular) or explicit.
+	Shape string `yaml:"shape"`
+	// Center is the node of a star every other one connects to, 1 when
+	// not set.
+	Center int `yaml:"center"`
+	// Degree is how many peers every node of a random topology has.
+	Degree int `yaml:"degree"`
+	// Edges are the peers of the nodes of an explicit topology, e.g.
+	// 1: [2, 3], connected both ways.
+	Edges map[int][]int `yaml:"edges"`
+}
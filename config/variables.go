@@ -0,0 +1,139 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Scopes of the variables saved by steps.
+const (
+	// Dropped once the step saving it finished, after its own assertions.
+	ScopeStep = "step"
+	// Dropped at the end of every iteration, the default.
+	ScopeIteration = "iteration"
+	// Kept for the whole run, across iterations.
+	ScopeRun = "run"
+)
+
+// Variables configures how long the variables saved by steps live.
+type Variables struct {
+	// Scope of every variable not listed in Scopes
+	Scope  string            `yaml:"scope"`
+	Scopes map[string]string `yaml:"scopes"`
+}
+
+var nodeVariableRegexp = regexp.MustCompile(`^(\w+)_\d+$`)
+
+// scope returns the scope of a variable. The per node copies of a variable,
+// NAME_<node>, share its scope.
+func (v *Variables) scope(name string) string {
+	if scope, ok := v.Scopes[name]; ok {
+		return scope
+	}
+	if found := nodeVariableRegexp.FindStringSubmatch(name); found != nil {
+		if scope, ok := v.Scopes[found[1]]; ok {
+			return scope
+		}
+	}
+	if v.Scope != "" {
+		return v.Scope
+	}
+	return ScopeIteration
+}
+
+// Keep returns the variables of env having one of the scopes.
+func (v *Variables) Keep(env []string, scopes ...string) []string {
+	var kept []string
+	for _, e := range env {
+		found := EnvVarRegexp.FindStringSubmatch(e)
+		if len(found) != 3 {
+			continue
+		}
+		for _, scope := range scopes {
+			if v.scope(found[1]) == scope {
+				kept = append(kept, e)
+				break
+			}
+		}
+	}
+	return kept
+}
+
+// Validate checks the scopes of the variables.
+func (v *Variables) Validate() error {
+	scopes := map[string]bool{"": true, ScopeStep: true, ScopeIteration: true, ScopeRun: true}
+	if !scopes[v.Scope] {
+		return fmt.Errorf("unknown variable scope %s", v.Scope)
+	}
+	for name, scope := range v.Scopes {
+		if !scopes[scope] {
+			return fmt.Errorf("unknown scope %s of variable %s", scope, name)
+		}
+	}
+	return nil
+}
+
+// SetVariable sets a variable of env, replacing its previous value. It
+// returns a copy, as env may be shared with nodes still running.
+func SetVariable(env []string, name string, value string) []string {
+	assignment := name + "=\"" + value + "\""
+	env = append(make([]string, 0, len(env)+1), env...)
+	for i, e := range env {
+		found := EnvVarRegexp.FindStringSubmatch(e)
+		if len(found) == 3 && found[1] == name {
+			env[i] = assignment
+			return env
+		}
+	}
+	return append(env, assignment)
+}
+
+// MergeVariables sets the variables of more in env.
+func MergeVariables(env []string, more []string) []string {
+	for _, e := range more {
+		found := EnvVarRegexp.FindStringSubmatch(e)
+		if len(found) == 3 {
+			env = SetVariable(env, found[1], found[2])
+		}
+	}
+	return env
+}
+
+// SaveVariable adds a variable to the step environment, both under its name
+// and under name_<node>, so the value saved by each node of a range stays
+// reachable, e.g. as HASH_{{.NodeIndex}} in a later step.
+func SaveVariable(env []string, name string, node int, value string) []string {
+	env = SetVariable(env, name, value)
+	return SetVariable(env, name+"_"+strconv.Itoa(node), value)
+}
+
+// EnvVarRegexp matches a NAME="value" entry of a step environment.
+var EnvVarRegexp = regexp.MustCompile(`(?s)^(\w+)="(.*)"$`)
+
+// HostFallback lets ExpandEnv fall back to the runner's own environment. It
+// is turned off for the tests submitted to serve, which would read the
+// server's credentials with it.
+var HostFallback = true
+
+// ExpandEnv replaces $VAR and ${VAR} in s with values saved in the step
+// environment, falling back to the runner's own environment.
+func ExpandEnv(s string, env []string) string {
+	values := make(map[string]string)
+	for _, e := range env {
+		found := EnvVarRegexp.FindStringSubmatch(e)
+		if len(found) == 3 {
+			values[found[1]] = found[2]
+		}
+	}
+	return os.Expand(s, func(name string) string {
+		if value, ok := values[name]; ok {
+			return value
+		}
+		if !HostFallback {
+			return ""
+		}
+		return os.Getenv(name)
+	})
+}
@@ -0,0 +1,173 @@
+package main
+
+// dashboardPage is the web UI of serve: the runs, the live progress of the
+// selected one, step by step across its nodes, and its report once it
+// finished. It asks for the token when the API wants one and keeps it in the
+// session storage of the tab, out of URLs and links.
+const dashboardPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kubernetes-ipfs</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+tr.run { cursor: pointer; }
+tr.selected { background: #eef4fb; }
+.pass, .passed { color: #2a7d2a; }
+.fail, .failed, .error { color: #b22222; }
+.timeout, .running { color: #c77700; }
+.nodes span { display: inline-block; width: 1.6em; margin: 1px; text-align: center; font-size: 0.8em; color: #fff; background: #999; }
+.nodes span.pass { background: #2a7d2a; }
+.nodes span.fail { background: #b22222; }
+.nodes span.timeout { background: #c77700; }
+</style>
+</head>
+<body>
+<h1>kubernetes-ipfs runs</h1>
+<table id="runs">
+<tr><th>Run</th><th>Test</th><th>Status</th><th>Submitted</th><th>Successes</th><th>Failures</th><th>Timeouts</th></tr>
+</table>
+<div id="run"></div>
+<script>
+let token = sessionStorage.getItem("token") || "";
+let selected = null, stream = null;
+
+// api fetches a path of the API with the token, asking for it again when
+// the server turns it down.
+async function api(path, options) {
+	for (;;) {
+		const headers = token ? {Authorization: "Bearer " + token} : {};
+		const response = await fetch(path, Object.assign({headers}, options));
+		if (response.status !== 401) return response;
+		const entered = prompt("Token of the server");
+		if (entered === null) return response;
+		token = entered;
+		sessionStorage.setItem("token", token);
+	}
+}
+
+function cell(row, text, cls) {
+	const td = row.insertCell();
+	td.textContent = text;
+	if (cls) td.className = cls;
+	return td;
+}
+
+async function refresh() {
+	const response = await api("runs");
+	if (!response.ok) return;
+	const runs = await response.json() || [];
+	const table = document.getElementById("runs");
+	while (table.rows.length > 1) table.deleteRow(1);
+	for (const run of runs.reverse()) {
+		const row = table.insertRow();
+		row.className = "run" + (run.ID === selected ? " selected" : "");
+		row.onclick = () => select(run.ID);
+		const summary = run.Summary || {};
+		cell(row, run.ID);
+		cell(row, run.Name);
+		cell(row, run.Status + (run.Error ? ": " + run.Error : ""), run.Status);
+		cell(row, new Date(run.Submitted).toLocaleString());
+		cell(row, summary.Successes ?? "", "pass");
+		cell(row, summary.Failures ?? "", "fail");
+		cell(row, summary.Timeouts ?? "", "timeout");
+	}
+}
+
+// select follows the events of a run, showing a row per step of every
+// iteration with a box per node, colored as its assertions come in.
+async function select(id) {
+	selected = id;
+	if (stream) stream.abort();
+	stream = new AbortController();
+	const div = document.getElementById("run");
+	div.innerHTML = "<h2></h2><p><a>Report</a> (once the run finished)</p><table><tr><th>Iteration</th><th>Step</th><th>Nodes</th><th>Outcome</th></tr></table><pre class=\"fail\"></pre>";
+	div.querySelector("h2").textContent = "Run " + id;
+	div.querySelector("a").href = "#";
+	div.querySelector("a").onclick = async (e) => {
+		e.preventDefault();
+		const response = await api("runs/" + id + "/html");
+		if (!response.ok) return;
+		open(URL.createObjectURL(await response.blob()), "_blank");
+	};
+	const table = div.querySelector("table"), incidents = div.querySelector("pre");
+	const steps = {};
+	const stepRow = (event) => {
+		const key = event.iteration + "/" + event.step;
+		if (!steps[key]) {
+			const row = table.insertRow();
+			cell(row, event.iteration);
+			cell(row, event.step + ". " + (event.name || ""));
+			steps[key] = {row, nodes: cell(row, "", "nodes"), outcome: cell(row, "running", "running"), boxes: {}};
+		}
+		return steps[key];
+	};
+	const box = (step, node) => {
+		if (!step.boxes[node]) {
+			const span = document.createElement("span");
+			span.textContent = node;
+			step.nodes.appendChild(span);
+			step.boxes[node] = span;
+		}
+		return step.boxes[node];
+	};
+	const handle = (event) => {
+		switch (event.event) {
+		case "step_started":
+			stepRow(event);
+			break;
+		case "node_output": {
+			const node = box(stepRow(event), event.node);
+			node.title = event.pod + "\n" + (event.output || []).join("\n");
+			if (event.timed_out) node.className = "timeout";
+			else if (!node.className) node.className = "pass";
+			break;
+		}
+		case "assertion_result":
+			if (!event.passed) {
+				const node = box(stepRow(event), event.node);
+				node.className = "fail";
+				node.title += "\nexpected " + event.expected + ", got " + event.actual;
+			}
+			break;
+		case "step_finished": {
+			const step = stepRow(event);
+			step.row.cells[1].textContent = event.step + ". " + event.name;
+			step.outcome.textContent = event.successes + " passed, " + event.failures + " failed, " + event.timeouts + " timed out, " + event.duration.toFixed(1) + "s";
+			step.outcome.className = event.failures || event.timeouts || event.errors ? "fail" : "pass";
+			break;
+		}
+		case "pod_incident":
+			incidents.textContent += "iteration " + event.iteration + ", node " + event.node + " (" + event.pod + "): " + event.message + "\n";
+			break;
+		case "run_finished":
+			refresh();
+			break;
+		}
+	};
+	try {
+		const response = await api("runs/" + id + "/events", {signal: stream.signal});
+		const reader = response.body.getReader(), decoder = new TextDecoder();
+		let buffered = "";
+		for (;;) {
+			const {value, done} = await reader.read();
+			if (done) break;
+			buffered += decoder.decode(value, {stream: true});
+			const lines = buffered.split("\n");
+			buffered = lines.pop();
+			lines.filter((line) => line).forEach((line) => handle(JSON.parse(line)));
+		}
+	} catch (e) {
+		// A newer selection aborted the stream.
+	}
+	refresh();
+}
+
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/runner"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// jobOptions are the flags of the job command.
+type jobOptions struct {
+	image          string
+	serviceAccount string
+	results        string
+	keep           bool
+}
+
+func newJobCommand() *cobra.Command {
+	opts := &jobOptions{}
+	cmd := &cobra.Command{
+		Use:   "job <testfile> [-- run flags]",
+		Short: "Run a test from inside the cluster as a Job",
+		Long: "Package the test file into a ConfigMap, run it with --in-cluster in a Job,\n" +
+			"stream the Job's logs and save its JSON report to --results. Flags after --\n" +
+			"are passed to run. The exit code is the one of the run.",
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.image == "" {
+				return fmt.Errorf("job needs the --image of kubernetes-ipfs to run")
+			}
+			os.Exit(runJob(args[0], args[1:], opts))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&opts.image, "image", "", "container image with kubernetes-ipfs and kubectl on its PATH")
+	cmd.Flags().StringVar(&opts.serviceAccount, "service-account", "kubernetes-ipfs", "service account the Job runs as, allowed to manage the test's pods")
+	cmd.Flags().StringVar(&opts.results, "results", "results.json", "file to save the JSON report of the run to")
+	cmd.Flags().StringVar(&runner.Namespace, "namespace", "", "namespace of the Job (default the current one)")
+	cmd.Flags().BoolVar(&opts.keep, "keep", false, "keep the Job and its ConfigMap after the run")
+	return cmd
+}
+
+// runJob runs the test in the cluster as a Job and returns the exit code of
+// the run, or 1 when the Job couldn't run.
+func runJob(testFile string, runArgs []string, opts *jobOptions) int {
+	name := "kubernetes-ipfs-" + time.Now().Format("20060102-150405")
+	file := filepath.Base(testFile)
+	color.Cyan("## Creating ConfigMap %s with %s", name, testFile)
+	err := runner.Kubectl("create", "configmap", name, "--from-file="+file+"="+testFile)
+	if err != nil {
+		color.Red("Failed to create the ConfigMap: %s", err)
+		return 1
+	}
+	if !opts.keep {
+		defer runner.Kubectl("delete", "configmap", name)
+	}
+
+	script := fmt.Sprintf("kubernetes-ipfs --in-cluster run /tests/%s --report json --report-file /tmp/report.json \"$@\"; code=$?; "+
+		"echo %s; cat /tmp/report.json; exit $code", runner.ShellQuote(file), runner.ShellQuote(runner.JobResultsMarker))
+	job := map[string]interface{}{
+		"apiVersion": "batch/v1",
+		"kind":       "Job",
+		"metadata":   map[string]interface{}{"name": name, "labels": map[string]string{"app": "kubernetes-ipfs"}},
+		"spec": map[string]interface{}{
+			"backoffLimit": 0,
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"serviceAccountName": opts.serviceAccount,
+					"restartPolicy":      "Never",
+					"containers": []interface{}{map[string]interface{}{
+						"name":         "kubernetes-ipfs",
+						"image":        opts.image,
+						"command":      append([]string{"sh", "-c", script, "sh"}, runArgs...),
+						"volumeMounts": []interface{}{map[string]string{"name": "tests", "mountPath": "/tests"}},
+					}},
+					"volumes": []interface{}{map[string]interface{}{"name": "tests", "configMap": map[string]string{"name": name}}},
+				},
+			},
+		},
+	}
+	manifest, err := json.Marshal(job)
+	if err != nil {
+		color.Red("Failed to create the Job: %s", err)
+		return 1
+	}
+	color.Cyan("## Starting Job %s", name)
+	err = runner.KubectlWithInput(manifest, "apply", "-f", "-")
+	if err != nil {
+		color.Red("Failed to create the Job: %s", err)
+		return 1
+	}
+	if !opts.keep {
+		defer runner.Kubectl("delete", "job", name, "--cascade=background")
+	}
+
+	err = waitForJobPod(name, time.Now().Add(5*time.Minute))
+	if err != nil {
+		color.Red("The Job didn't start: %s", err)
+		return 1
+	}
+	results, err := streamJobLogs(name)
+	if err != nil {
+		color.Red("Failed to stream the logs of the Job: %s", err)
+		return 1
+	}
+	if len(results) != 0 {
+		err = ioutil.WriteFile(opts.results, results, 0664)
+		if err != nil {
+			color.Red("Failed to save the results: %s", err)
+		} else {
+			color.Cyan("## Results saved to %s", opts.results)
+		}
+	} else {
+		color.Red("The Job didn't report results")
+	}
+
+	// The Job may still be wrapping up after its logs ended. Its pod exits
+	// with the code of the run.
+	for i := 0; i < 20; i++ {
+		codes, err := runner.KubectlOutput("get", "pods", "--selector=job-name="+name,
+			"--output=jsonpath={.items[*].status.containerStatuses[*].state.terminated.exitCode}")
+		if err == nil && len(codes) != 0 {
+			code, err := strconv.Atoi(codes[0])
+			if err == nil {
+				return code
+			}
+		}
+		// A pod gone without an exit code, e.g. evicted, only shows in the
+		// failures of the Job.
+		failed, err := runner.KubectlOutput("get", "job", name, "--output=jsonpath={.status.failed}")
+		if err == nil && len(failed) != 0 && failed[0] != "0" {
+			return 1
+		}
+		time.Sleep(3 * time.Second)
+	}
+	color.Red("The Job didn't finish")
+	return 1
+}
+
+// waitForJobPod waits until the pod of the Job runs or already ran, so its
+// logs can be followed.
+func waitForJobPod(name string, deadline time.Time) error {
+	for time.Now().Before(deadline) {
+		phases, err := runner.KubectlOutput("get", "pods", "--selector=job-name="+name, "--output=jsonpath={.items[*].status.phase}")
+		if err != nil {
+			return err
+		}
+		for _, phase := range phases {
+			if phase != "Pending" {
+				return nil
+			}
+		}
+		time.Sleep(3 * time.Second)
+	}
+	return fmt.Errorf("its pod is still pending")
+}
+
+// streamJobLogs prints the logs of the Job as they come and returns what
+// follows the results marker.
+func streamJobLogs(name string) ([]byte, error) {
+	cmd := runner.KubectlCommand("logs", "--follow", "job/"+name)
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	errbuf := new(bytes.Buffer)
+	cmd.Stderr = errbuf
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+	var results bytes.Buffer
+	inResults := false
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case inResults:
+			results.WriteString(line + "\n")
+		case line == runner.JobResultsMarker:
+			inResults = true
+		default:
+			fmt.Println(line)
+		}
+	}
+	err = cmd.Wait()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s", err, errbuf.String())
+	}
+	return results.Bytes(), nil
+}
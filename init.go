@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/template"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// scaffold holds the values the init templates are rendered with.
+type scaffold struct {
+	Nodes      int
+	Deployment string
+}
+
+// The test scaffold is itself rendered by loadTest, so the template actions
+// meant for that are escaped.
+const testScaffold = `# A kubernetes-ipfs test: every iteration runs the steps in order, and the run
+# passes when the outcomes match the expected ones.
+name: Add a file on one node and cat it on the others
+config:
+  # Number of ipfs nodes the test needs; the deployment is scaled up to it.
+  nodes: {{"{{"}} default {{.Nodes}} .nodes {{"}}"}}
+  # Pods the test runs on, and the deployment they belong to.
+  selector: run={{.Deployment}}
+  deployment: {{.Deployment}}
+  # Iterations of the steps.
+  times: {{"{{"}} default 1 .times {{"}}"}}
+  # Every assertion counts as a success or a failure, every step exceeding its
+  # timeout on a node as a timeout.
+  expected:
+    successes: {{"{{"}} mul (sub (default {{.Nodes}} .nodes) 1) (default 1 .times) {{"}}"}}
+    failures: 0
+    timeouts: 0
+steps:
+  - name: Add a random file
+    on_node: 1
+    cmd: head -c 1024 /dev/urandom | base64 > /tmp/file.txt && cat /tmp/file.txt | head -1 && ipfs add -q /tmp/file.txt
+    timeout: 30
+    # Output lines can be saved to variables for later steps.
+    outputs:
+    - line: 0
+      save_to: LINE
+    - line: 1
+      save_to: HASH
+  - name: Cat it on every other node
+    on_node: 2
+    end_node: {{"{{"}} default {{.Nodes}} .nodes {{"}}"}}
+    cmd: ipfs cat $HASH | head -1
+    timeout: 30
+    # should_be_equal_to takes a saved variable or a literal value.
+    assertions:
+    - line: 0
+      should_be_equal_to: LINE
+`
+
+const deploymentScaffold = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: {{.Deployment}}
+  labels:
+    name: go-ipfs
+spec:
+  replicas: {{.Nodes}}
+  selector:
+    matchLabels:
+      run: {{.Deployment}}
+  template:
+    metadata:
+      labels:
+        run: {{.Deployment}}
+      annotations:
+        prometheus.io/scrape: "true"
+        prometheus.io/port: "5001"
+        prometheus.io/path: "debug/metrics/prometheus"
+    spec:
+      containers:
+      - name: go-ipfs
+        image: "ipfs/go-ipfs:latest"
+        ports:
+        - containerPort: 4001
+          name: "swarm"
+          protocol: "TCP"
+        - containerPort: 5001
+          name: "api"
+          protocol: "TCP"
+`
+
+func newInitCommand() *cobra.Command {
+	values := scaffold{}
+	var deploymentFile string
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "init [testfile]",
+		Short: "Write a commented example test to start from",
+		Long: "Write a commented example test (test.yml by default) to start from, and\n" +
+			"with --deployment-file a go-ipfs Deployment manifest it runs against.",
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := "test.yml"
+			if len(args) == 1 {
+				path = args[0]
+			}
+			err := writeScaffold(path, testScaffold, values, force)
+			if err != nil {
+				return err
+			}
+			if deploymentFile != "" {
+				err = writeScaffold(deploymentFile, deploymentScaffold, values, force)
+				if err != nil {
+					return err
+				}
+				color.Green("Create the deployment with: kubectl apply -f %s", deploymentFile)
+			}
+			color.Green("Run the test with: kubernetes-ipfs run %s", path)
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&values.Nodes, "nodes", 3, "number of nodes of the example")
+	cmd.Flags().StringVar(&values.Deployment, "deployment", config.DEPLOYMENT_NAME, "name of the go-ipfs deployment")
+	cmd.Flags().StringVar(&deploymentFile, "deployment-file", "", "also write a matching Deployment manifest to this file")
+	cmd.Flags().BoolVar(&force, "force", false, "overwrite existing files")
+	return cmd
+}
+
+func writeScaffold(path string, text string, values scaffold, force bool) error {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flags, 0664)
+	if os.IsExist(err) {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	color.Blue("### Writing %s", path)
+	return template.Must(template.New(path).Parse(text)).Execute(f, values)
+}
@@ -0,0 +1,79 @@
+package report
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// LoadSummary reads a summary written with --report json.
+func LoadSummary(path string) (*Summary, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	summary := new(Summary)
+	err = json.Unmarshal(data, summary)
+	if err != nil {
+		return nil, err
+	}
+	return summary, nil
+}
+
+// timings averages the duration of every step over the iterations, keyed by
+// step index and name, along with the metrics measured in seconds.
+func timings(summary *Summary) map[string]float64 {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			key := "step " + strconv.Itoa(step.Index) + " " + step.Name
+			sums[key] += step.End.Sub(step.Start).Seconds()
+			counts[key]++
+		}
+	}
+	for _, metric := range summary.Metrics {
+		if strings.HasSuffix(metric.Name, "_seconds") {
+			key := "metric " + metric.Name
+			sums[key] += metric.Value
+			counts[key]++
+		}
+	}
+	for key := range sums {
+		sums[key] /= float64(counts[key])
+	}
+	return sums
+}
+
+// CompareBaseline reports every timing of the run that is more than
+// threshold percent slower than in the baseline, and whether there was any.
+// Timings missing from either run are skipped.
+func CompareBaseline(baseline *Summary, summary *Summary, threshold float64) bool {
+	before := timings(baseline)
+	after := timings(summary)
+	keys := make([]string, 0, len(after))
+	for key := range after {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	regressed := false
+	for _, key := range keys {
+		old, ok := before[key]
+		if !ok || old == 0 {
+			continue
+		}
+		change := 100 * (after[key] - old) / old
+		if change > threshold {
+			color.Red("Regression in %s: %.3fs against %.3fs in the baseline (+%.1f%%)", key, after[key], old, change)
+			regressed = true
+		}
+	}
+	if !regressed {
+		color.Green("No regression against the baseline beyond %g%%", threshold)
+	}
+	return regressed
+}
@@ -0,0 +1,169 @@
+package report
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// WriteHTMLReport writes a self-contained HTML page with the summary of a
+// run, the results of every step and node with their output, and a chart of
+// the step durations, for readers who don't use the CLI.
+func WriteHTMLReport(path string, summary *Summary) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return HTMLReport(f, summary)
+}
+
+// ledgerTable is the matrix of bitswap ledgers of a step, as the HTML report
+// shows it.
+type ledgerTable struct {
+	Nodes []int
+	Rows  []ledgerRow
+}
+
+// ledgerRow holds the ledgers a node keeps of the nodes of its ledgerTable.
+type ledgerRow struct {
+	Node    int
+	Ledgers []*Ledger
+}
+
+// HTMLReport writes the page of WriteHTMLReport to w.
+func HTMLReport(w io.Writer, summary *Summary) error {
+	longest := time.Duration(0)
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			if d := step.End.Sub(step.Start); d > longest {
+				longest = d
+			}
+		}
+	}
+	funcs := template.FuncMap{
+		"duration": func(step *StepResult) string {
+			return step.End.Sub(step.Start).Round(time.Millisecond).String()
+		},
+		"width": func(step *StepResult) float64 {
+			if longest == 0 {
+				return 0
+			}
+			return 100 * step.End.Sub(step.Start).Seconds() / longest.Seconds()
+		},
+		"join": strings.Join,
+		"seconds": func(seconds float64) string {
+			return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond).String()
+		},
+		"mebibytes": func(bytes float64) string {
+			return fmt.Sprintf("%.1fMi", bytes/(1<<20))
+		},
+		"ledgers": func(ledgers []Ledger) ledgerTable {
+			nodes, rows := LedgerMatrix(ledgers)
+			table := ledgerTable{Nodes: nodes}
+			for i, row := range rows {
+				table.Rows = append(table.Rows, ledgerRow{Node: nodes[i], Ledgers: row})
+			}
+			return table
+		},
+	}
+	tmpl, err := template.New("report").Funcs(funcs).Parse(htmlReportTemplate)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, summary)
+}
+
+const htmlReportTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+td, th { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+.pass { color: #2a7d2a; }
+.fail { color: #b22222; }
+.timeout { color: #c77700; }
+.bar { background: #4a90d9; height: 1em; }
+.chart td { border: none; }
+pre { background: #f5f5f5; padding: 0.5em; overflow-x: auto; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<table>
+<tr><th>Started</th><td>{{.Start.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Ended</th><td>{{.End.Format "2006-01-02 15:04:05"}}</td></tr>
+<tr><th>Nodes</th><td>{{.Nodes}}</td></tr>
+<tr><th>Iterations</th><td>{{.TestsRan}}</td></tr>
+<tr><th>Successes</th><td class="pass">{{.Successes}}</td></tr>
+<tr><th>Failures</th><td class="fail">{{.Failures}}</td></tr>
+<tr><th>Timeouts</th><td class="timeout">{{.Timeouts}}</td></tr>
+<tr><th>Errors</th><td class="fail">{{.Errors}}</td></tr>
+{{if .Retries}}<tr><th>Retried kubectl commands</th><td>{{.Retries}}</td></tr>
+{{end}}</table>
+{{if .Phases}}
+<h3>Phases</h3>
+<table>
+<tr><th>Phase</th><th>Steps</th><th>Successes</th><th>Failures</th><th>Timeouts</th><th>Errors</th><th>Time</th></tr>
+{{range .Phases}}<tr><td>{{.Name}}</td><td>{{.Steps}}</td><td class="pass">{{.Successes}}</td><td class="fail">{{.Failures}}</td><td class="timeout">{{.Timeouts}}</td><td class="fail">{{.Errors}}</td><td>{{seconds .Seconds}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Transfers}}
+<h3>Transfers</h3>
+<table>
+<tr><th>Step</th><th>Transfers</th><th>TTFB mean</th><th>TTFB median</th><th>TTFB p95</th><th>MB/s mean</th><th>MB/s median</th><th>MB/s p95</th></tr>
+{{range .Transfers}}<tr><td>{{.Step}}</td><td>{{.Transfers}}</td><td>{{printf "%.3fs" .TTFB.Mean}}</td><td>{{printf "%.3fs" .TTFB.Median}}</td><td>{{printf "%.3fs" .TTFB.P95}}</td><td>{{printf "%.2f" .Throughput.Mean}}</td><td>{{printf "%.2f" .Throughput.Median}}</td><td>{{printf "%.2f" .Throughput.P95}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Mapping}}
+<h3>Nodes</h3>
+<table>
+<tr><th>Node</th><th>Group</th><th>Pod</th></tr>
+{{range .Mapping}}<tr><td>{{.Node}}</td><td>{{.Group}}</td><td>{{.Pod}}</td></tr>
+{{end}}</table>
+{{end}}{{range .Iterations}}
+<h2>Iteration {{.Index}}{{if .Incidents}} <span class="fail">(pod incidents)</span>{{end}}</h2>
+{{if .Incidents}}<table>
+<tr><th>Time</th><th>Node</th><th>Pod</th><th>Incident</th></tr>
+{{range .Incidents}}<tr><td>{{.Time.Format "15:04:05"}}</td><td>{{.Node}}{{if .Group}} ({{.Group}}){{end}}</td><td>{{.Pod}}</td><td class="fail">{{.Message}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Churn}}<h3>Churn</h3>
+<table>
+<tr><th>Time</th><th>Node</th><th>Pod</th><th>Action</th></tr>
+{{range .Churn}}<tr><td>{{.Time.Format "15:04:05"}}</td><td>{{if .Node}}{{.Node}}{{end}}{{if .Group}} ({{.Group}}){{end}}</td><td>{{.Pod}}</td><td{{if .Error}} class="fail"{{end}}>{{.Action}}{{if .Error}}: {{.Error}}{{end}}</td></tr>
+{{end}}</table>{{end}}
+{{if .Resources}}<h3>Resources</h3>
+<table>
+<tr><th>Node</th><th>Pod</th><th>CPU peak</th><th>CPU average</th><th>Memory peak</th><th>Memory average</th></tr>
+{{range .Resources}}<tr><td>{{.Node}}{{if .Group}} ({{.Group}}){{end}}</td><td>{{.Pod}}</td><td>{{printf "%.0fm" .CPUPeak}}</td><td>{{printf "%.0fm" .CPUAverage}}</td><td>{{mebibytes .MemoryPeak}}</td><td>{{mebibytes .MemoryAverage}}</td></tr>
+{{end}}</table>{{end}}
+<h3>Step durations</h3>
+<table class="chart">
+{{range .Steps}}<tr><td>{{.Index}}. {{.Name}}</td><td style="width: 30em"><div class="bar" style="width: {{width .}}%"></div></td><td>{{duration .}}</td></tr>
+{{end}}</table>
+{{range .Steps}}
+<h3>{{.Index}}. {{.Name}}{{if .Phase}} <small>({{.Phase}})</small>{{end}}</h3>
+<p>{{if .CMD}}<code>{{.CMD}}</code> &middot; {{end}}{{duration .}} &middot;
+<span class="pass">{{.Successes}} passed</span>, <span class="fail">{{.Failures}} failed</span>, <span class="timeout">{{.Timeouts}} timed out</span>{{if .Errors}}, <span class="fail">{{.Errors}} couldn't run</span>{{end}}</p>
+{{if .Nodes}}<table>
+<tr><th>Node</th><th>Pod</th><th>Assertions</th><th>Output</th></tr>
+{{range .Nodes}}<tr>
+<td>{{.Node}}</td>
+<td>{{.Pod}}{{if .TimedOut}} <span class="timeout">timed out</span>{{end}}{{if .Error}} <span class="fail">{{.Error}}</span>{{end}}</td>
+<td>{{range .Assertions}}<div class="{{if .Passed}}pass{{else}}fail{{end}}">{{.Expected}} / {{.Actual}}</div>{{end}}</td>
+<td><details><summary>{{len .Output}} lines</summary><pre>{{join .Output "\n"}}</pre>{{if .Stderr}}<pre class="fail">{{join .Stderr "\n"}}</pre>{{end}}</details></td>
+</tr>
+{{end}}</table>{{end}}
+{{if .Ledgers}}{{with ledgers .Ledgers}}<table>
+<tr><th>Sent by \ to</th>{{range .Nodes}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr><th>{{.Node}}</th>{{range .Ledgers}}<td>{{if .}}{{.Sent}}{{else}}-{{end}}</td>{{end}}</tr>
+{{end}}</table>{{end}}{{end}}
+{{end}}
+{{end}}
+</body>
+</html>
+`
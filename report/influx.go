@@ -0,0 +1,75 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeInfluxReport writes the run as InfluxDB line protocol: one point per
+// step and node, and one per metric. A path starting with http:// or
+// https:// is a write endpoint (e.g. http://influxdb:8086/write?db=ipfs) the
+// points are posted to, anything else a file they are appended to.
+func writeInfluxReport(path string, summary *Summary) error {
+	var points bytes.Buffer
+	test := influxEscape(summary.Name)
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			duration := step.End.Sub(step.Start).Seconds()
+			for _, node := range step.Nodes {
+				passed, failed := 0, 0
+				for _, assertion := range node.Assertions {
+					if assertion.Passed {
+						passed++
+					} else {
+						failed++
+					}
+				}
+				fmt.Fprintf(&points, "kubernetes_ipfs_step,test=%s,iteration=%d,step=%s,node=%d duration_seconds=%s,output_bytes=%di,assertions_passed=%di,assertions_failed=%di,timed_out=%t,success=%t %d\n",
+					test, iteration.Index, influxEscape(step.Name), node.Node,
+					strconv.FormatFloat(duration, 'f', -1, 64), len(strings.Join(node.Output, "\n")),
+					passed, failed, node.TimedOut, !node.TimedOut && failed == 0, step.End.UnixNano())
+			}
+		}
+	}
+	for _, metric := range summary.Metrics {
+		group := ""
+		if metric.Group != "" {
+			group = ",group=" + influxEscape(metric.Group)
+		}
+		fmt.Fprintf(&points, "kubernetes_ipfs_metric,test=%s,name=%s%s,node=%d value=%s %d\n",
+			test, influxEscape(metric.Name), group, metric.Node, strconv.FormatFloat(metric.Value, 'f', -1, 64), metric.Time.UnixNano())
+	}
+
+	if !IsURL(path) {
+		return AppendToFile(path, points.Bytes())
+	}
+	client := http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Post(path, "text/plain; charset=utf-8", &points)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		out, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("influx report error: %s %s", resp.Status, out)
+	}
+	return nil
+}
+
+// IsURL tells whether a report is written to a URL rather than a file.
+func IsURL(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://")
+}
+
+// influxEscape escapes a tag value for the line protocol.
+func influxEscape(s string) string {
+	if s == "" {
+		return "none"
+	}
+	return strings.NewReplacer(`\`, `\\`, ",", `\,`, "=", `\=`, " ", `\ `, "\n", `\n`).Replace(s)
+}
@@ -0,0 +1,44 @@
+package report
+
+import (
+	"sort"
+)
+
+// Ledger is what the bitswap ledger of a node says of its exchanges with
+// another node: the bytes it sent and received, and the blocks exchanged.
+type Ledger struct {
+	Node      int
+	Peer      int
+	Sent      uint64
+	Received  uint64
+	Exchanged uint64
+}
+
+// LedgerMatrix arranges ledgers in a row per node and a column per peer,
+// both in the order of the returned nodes, with nil where a node has no
+// ledger of a peer.
+func LedgerMatrix(ledgers []Ledger) ([]int, [][]*Ledger) {
+	index := make(map[int]int)
+	var nodes []int
+	for _, ledger := range ledgers {
+		for _, node := range []int{ledger.Node, ledger.Peer} {
+			if _, ok := index[node]; !ok {
+				index[node] = 0
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	sort.Ints(nodes)
+	for i, node := range nodes {
+		index[node] = i
+	}
+	rows := make([][]*Ledger, len(nodes))
+	for i := range rows {
+		rows[i] = make([]*Ledger, len(nodes))
+	}
+	for i := range ledgers {
+		ledger := &ledgers[i]
+		rows[index[ledger.Node]][index[ledger.Peer]] = ledger
+	}
+	return nodes, rows
+}
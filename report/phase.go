@@ -0,0 +1,61 @@
+package report
+
+import (
+	"time"
+)
+
+// PhaseSummary breaks the outcomes and the time of a run down to the steps
+// of one of its phases.
+type PhaseSummary struct {
+	Outcomes
+	Name string
+	// Steps is the number of steps in the phase.
+	Steps int
+	// Seconds is the time spent in the phase, from its first step starting
+	// to its last one ending, added up over the iterations.
+	Seconds float64
+}
+
+// RunPhases adds up the outcomes and the time of every phase of the run, in
+// the order they first ran.
+func RunPhases(summary *Summary) []PhaseSummary {
+	var phases []PhaseSummary
+	index := make(map[string]int)
+	counted := make(map[int]bool)
+	for _, iteration := range summary.Iterations {
+		start, end := make(map[string]time.Time), make(map[string]time.Time)
+		for _, step := range iteration.Steps {
+			if step.Phase == "" {
+				continue
+			}
+			i, ok := index[step.Phase]
+			if !ok {
+				i = len(phases)
+				index[step.Phase] = i
+				phases = append(phases, PhaseSummary{Name: step.Phase})
+			}
+			phase := &phases[i]
+			phase.Successes += step.Successes
+			phase.Failures += step.Failures
+			phase.Timeouts += step.Timeouts
+			phase.Errors += step.Errors
+			if !counted[step.Index] {
+				counted[step.Index] = true
+				phase.Steps++
+			}
+			if step.Skipped {
+				continue
+			}
+			if first, ok := start[step.Phase]; !ok || step.Start.Before(first) {
+				start[step.Phase] = step.Start
+			}
+			if step.End.After(end[step.Phase]) {
+				end[step.Phase] = step.End
+			}
+		}
+		for name, first := range start {
+			phases[index[name]].Seconds += end[name].Sub(first).Seconds()
+		}
+	}
+	return phases
+}
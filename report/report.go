@@ -0,0 +1,192 @@
+package report
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WriteReport writes the summary of a run to path in the requested format.
+func WriteReport(format string, path string, summary *Summary) error {
+	switch format {
+	case "sqlite":
+		return writeSQLiteReport(path, summary)
+	case "influx":
+		return writeInfluxReport(path, summary)
+	case "tap":
+		return writeTAPReport(path, summary)
+	case "json":
+		data, err := json.MarshalIndent(summary, "", "  ")
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(path, data, 0664)
+	default:
+		return fmt.Errorf("unknown report format: %s", format)
+	}
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS runs (
+	id INTEGER PRIMARY KEY,
+	name TEXT,
+	nodes INTEGER,
+	started TEXT,
+	ended TEXT,
+	successes INTEGER,
+	failures INTEGER,
+	timeouts INTEGER
+);
+CREATE TABLE IF NOT EXISTS iterations (
+	run_id INTEGER REFERENCES runs(id),
+	iteration INTEGER,
+	started TEXT,
+	ended TEXT,
+	PRIMARY KEY (run_id, iteration)
+);
+CREATE TABLE IF NOT EXISTS steps (
+	run_id INTEGER REFERENCES runs(id),
+	iteration INTEGER,
+	step INTEGER,
+	name TEXT,
+	cmd TEXT,
+	started TEXT,
+	ended TEXT,
+	duration_seconds REAL,
+	PRIMARY KEY (run_id, iteration, step)
+);
+CREATE TABLE IF NOT EXISTS node_results (
+	run_id INTEGER REFERENCES runs(id),
+	iteration INTEGER,
+	step INTEGER,
+	node INTEGER,
+	pod TEXT,
+	timed_out INTEGER,
+	output TEXT,
+	PRIMARY KEY (run_id, iteration, step, node)
+);
+CREATE TABLE IF NOT EXISTS node_stderr (
+	run_id INTEGER REFERENCES runs(id),
+	iteration INTEGER,
+	step INTEGER,
+	node INTEGER,
+	output TEXT,
+	PRIMARY KEY (run_id, iteration, step, node)
+);
+CREATE TABLE IF NOT EXISTS assertions (
+	run_id INTEGER REFERENCES runs(id),
+	iteration INTEGER,
+	step INTEGER,
+	node INTEGER,
+	line INTEGER,
+	expected TEXT,
+	actual TEXT,
+	passed INTEGER
+);
+CREATE TABLE IF NOT EXISTS metrics (
+	run_id INTEGER REFERENCES runs(id),
+	time TEXT,
+	node INTEGER,
+	pod TEXT,
+	name TEXT,
+	value REAL
+);
+CREATE TABLE IF NOT EXISTS logs (
+	run_id INTEGER REFERENCES runs(id),
+	node INTEGER,
+	pod TEXT,
+	output TEXT
+);
+`
+
+// writeSQLiteReport appends the run to the SQLite database at path, creating
+// the tables on first use. The statements are fed to the sqlite3 command line
+// tool, so every run lands in the same database and can be queried together.
+func writeSQLiteReport(path string, summary *Summary) error {
+	var sql bytes.Buffer
+	sql.WriteString("BEGIN;\n")
+	sql.WriteString(sqliteSchema)
+
+	runID := summary.Start.UnixNano()
+	fmt.Fprintf(&sql, "INSERT INTO runs VALUES (%d, %s, %d, %s, %s, %d, %d, %d);\n",
+		runID, sqlQuote(summary.Name), summary.Nodes, sqlTime(summary.Start), sqlTime(summary.End),
+		summary.Successes, summary.Failures, summary.Timeouts)
+	for _, iteration := range summary.Iterations {
+		fmt.Fprintf(&sql, "INSERT INTO iterations VALUES (%d, %d, %s, %s);\n",
+			runID, iteration.Index, sqlTime(iteration.Start), sqlTime(iteration.End))
+		for _, step := range iteration.Steps {
+			fmt.Fprintf(&sql, "INSERT INTO steps VALUES (%d, %d, %d, %s, %s, %s, %s, %s);\n",
+				runID, iteration.Index, step.Index, sqlQuote(step.Name), sqlQuote(step.CMD),
+				sqlTime(step.Start), sqlTime(step.End), sqlFloat(step.End.Sub(step.Start).Seconds()))
+			for _, node := range step.Nodes {
+				fmt.Fprintf(&sql, "INSERT INTO node_results VALUES (%d, %d, %d, %d, %s, %d, %s);\n",
+					runID, iteration.Index, step.Index, node.Node, sqlQuote(node.Pod),
+					sqlBool(node.TimedOut), sqlQuote(strings.Join(node.Output, "\n")))
+				if len(node.Stderr) != 0 {
+					fmt.Fprintf(&sql, "INSERT INTO node_stderr VALUES (%d, %d, %d, %d, %s);\n",
+						runID, iteration.Index, step.Index, node.Node, sqlQuote(strings.Join(node.Stderr, "\n")))
+				}
+				for _, assertion := range node.Assertions {
+					fmt.Fprintf(&sql, "INSERT INTO assertions VALUES (%d, %d, %d, %d, %d, %s, %s, %d);\n",
+						runID, iteration.Index, step.Index, node.Node, assertion.Line,
+						sqlQuote(assertion.Expected), sqlQuote(assertion.Actual), sqlBool(assertion.Passed))
+				}
+			}
+		}
+	}
+	for _, metric := range summary.Metrics {
+		fmt.Fprintf(&sql, "INSERT INTO metrics VALUES (%d, %s, %d, %s, %s, %s);\n",
+			runID, sqlTime(metric.Time), metric.Node, sqlQuote(metric.Pod), sqlQuote(metric.Name), sqlFloat(metric.Value))
+	}
+	for _, log := range summary.Logs {
+		fmt.Fprintf(&sql, "INSERT INTO logs VALUES (%d, %d, %s, %s);\n",
+			runID, log.Node, sqlQuote(log.Pod), sqlQuote(strings.Join(log.Lines, "\n")))
+	}
+	sql.WriteString("COMMIT;\n")
+
+	cmd := exec.Command("sqlite3", "-bail", path)
+	cmd.Stdin = &sql
+	errout := new(bytes.Buffer)
+	cmd.Stderr = errout
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("sqlite report error: %s %s", err, errout.String())
+	}
+	return nil
+}
+
+// AppendToFile appends data to the file at path, creating it if needed.
+func AppendToFile(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0664)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	return err
+}
+
+func sqlQuote(s string) string {
+	return "'" + strings.Replace(s, "'", "''", -1) + "'"
+}
+
+func sqlTime(t time.Time) string {
+	return sqlQuote(t.Format(time.RFC3339Nano))
+}
+
+func sqlFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+func sqlBool(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
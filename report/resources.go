@@ -0,0 +1,51 @@
+package report
+
+import (
+	"strconv"
+)
+
+// ResourceUsage is the CPU and memory a node's pod used during an iteration,
+// as sampled from the metrics-server with sample_resources.
+type ResourceUsage struct {
+	Node    int
+	Group   string `json:",omitempty"`
+	Pod     string
+	Samples int
+	// CPU in millicores
+	CPUPeak    float64
+	CPUAverage float64
+	// Memory in bytes
+	MemoryPeak    float64
+	MemoryAverage float64
+}
+
+// RunResources combines the usage of every node over the iterations of the
+// run.
+func RunResources(summary *Summary) []ResourceUsage {
+	var combined []ResourceUsage
+	index := make(map[string]int)
+	for _, iteration := range summary.Iterations {
+		for _, usage := range iteration.Resources {
+			key := usage.Group + "/" + strconv.Itoa(usage.Node)
+			i, ok := index[key]
+			if !ok {
+				index[key] = len(combined)
+				combined = append(combined, usage)
+				continue
+			}
+			run := &combined[i]
+			samples := float64(run.Samples + usage.Samples)
+			run.CPUAverage = (run.CPUAverage*float64(run.Samples) + usage.CPUAverage*float64(usage.Samples)) / samples
+			run.MemoryAverage = (run.MemoryAverage*float64(run.Samples) + usage.MemoryAverage*float64(usage.Samples)) / samples
+			if usage.CPUPeak > run.CPUPeak {
+				run.CPUPeak = usage.CPUPeak
+			}
+			if usage.MemoryPeak > run.MemoryPeak {
+				run.MemoryPeak = usage.MemoryPeak
+			}
+			run.Samples += usage.Samples
+			run.Pod = usage.Pod
+		}
+	}
+	return combined
+}
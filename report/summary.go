@@ -0,0 +1,215 @@
+// Package report holds the summary of a run and writes it out as a report.
+package report
+
+import (
+	"fmt"
+	"time"
+)
+
+// Summary is
+type Summary struct {
+	Name       string
+	Nodes      int
+	Start      time.Time
+	End        time.Time
+	Successes  int
+	Failures   int
+	TestsToRun int
+	TestsRan   int
+	Timeouts   int
+	Errors     int
+	Iterations []*IterationResult
+	Metrics    []Metric
+	Logs       []NodeLog
+	// Aborted tells why the run stopped early, leaving a partial summary.
+	Aborted string `json:",omitempty"`
+	// Parameters are the matrix values the run was made with.
+	Parameters map[string]interface{} `json:",omitempty"`
+	// Seed reproduces the randomness of the run with --seed.
+	Seed int64
+	// Mapping lists the pods that served as the nodes of the run.
+	Mapping []NodePod `json:",omitempty"`
+	// Retries counts the kubectl commands tried again after failing
+	// because of the API server or the network.
+	Retries int64 `json:",omitempty"`
+	// Phases break the outcomes and the time of the run down to the phases
+	// of the test.
+	Phases []PhaseSummary `json:",omitempty"`
+	// Transfers sum up the transfers timed by the steps.
+	Transfers []TransferSummary `json:",omitempty"`
+}
+
+// IterationResult records one full pass over the test steps.
+type IterationResult struct {
+	Index int
+	Start time.Time
+	End   time.Time
+	Steps []*StepResult
+	// Warmup marks the warm-up iterations, which stay out of the summary.
+	Warmup bool `json:"-"`
+
+	// Incidents flag an iteration whose pods restarted, were OOM killed or
+	// evicted while it ran, with monitor_pods.
+	Incidents []PodIncident `json:",omitempty"`
+	// Resources is the CPU and memory used by each node, with
+	// sample_resources.
+	Resources []ResourceUsage `json:",omitempty"`
+	// Churn is the timeline of the nodes churn took down and brought back.
+	Churn []ChurnEvent `json:",omitempty"`
+	// Errors counts what went wrong in the iteration outside of its steps,
+	// like the nodes whose repo reset_repo couldn't reset.
+	Errors int `json:",omitempty"`
+}
+
+func (iteration *IterationResult) String() string {
+	if iteration.Warmup {
+		return fmt.Sprintf("warm-up %d", iteration.Index)
+	}
+	return fmt.Sprintf("iteration %d", iteration.Index)
+}
+
+// StepResult records the outcome of a single step within an iteration.
+type StepResult struct {
+	Outcomes
+	Index int
+	Name  string
+	CMD   string
+	Tags  []string
+	Start time.Time
+	End   time.Time
+	Nodes []*NodeResult
+	// Skipped is set when the step's `when` didn't hold, or when it was
+	// skipped with --step.
+	Skipped bool `json:",omitempty"`
+	// Phase is the phase the step belongs to.
+	Phase string `json:",omitempty"`
+	// Ledgers are the bitswap ledgers between the nodes of a
+	// bitswap_ledgers step.
+	Ledgers []Ledger `json:",omitempty"`
+}
+
+// NodeResult records what a step produced on a single node.
+type NodeResult struct {
+	Node       int
+	Pod        string
+	Output     []string
+	Fields     map[string]string
+	Stderr     []string
+	TimedOut   bool
+	ExitCode   int
+	Assertions []AssertionResult
+	// DroppedLines counts the lines left out of Output by the output_limit,
+	// from the one at DroppedFrom on. OutputFile holds all of them with
+	// --output-dir.
+	DroppedLines int    `json:",omitempty"`
+	DroppedFrom  int    `json:",omitempty"`
+	OutputFile   string `json:",omitempty"`
+	// Retries counts the times kubectl failed to reach the pod and was
+	// tried again.
+	Retries int `json:",omitempty"`
+	// Error tells why kubectl couldn't run the step on the node at all,
+	// which says nothing about ipfs.
+	Error string `json:",omitempty"`
+	// Transfer is the timing of a cat with measure_transfer.
+	Transfer *Transfer `json:",omitempty"`
+	// HTTPStatus is the status the API of the node replied to an op with,
+	// when it wasn't 200. An op run through the CLI has its ExitCode.
+	HTTPStatus int `json:",omitempty"`
+}
+
+// Line returns a line of the output by its number in the whole output, as
+// if no line had been dropped, and whether it was kept.
+func (n *NodeResult) Line(index int) (string, bool) {
+	if n.DroppedLines != 0 && index >= n.DroppedFrom {
+		if index < n.DroppedFrom+n.DroppedLines {
+			return "", false
+		}
+		index -= n.DroppedLines
+	}
+	if index < 0 || index >= len(n.Output) {
+		return "", false
+	}
+	return n.Output[index], true
+}
+
+// AssertionResult records a single evaluated assertion.
+type AssertionResult struct {
+	Line     int
+	Expected string
+	Actual   string
+	Passed   bool
+}
+
+// NodeLog holds the daemon logs a node produced during the observation
+// window.
+type NodeLog struct {
+	Node  int
+	Pod   string
+	Lines []string
+}
+
+// Metric is a named measurement taken during the run. Node is 0 for
+// run-wide metrics, and Group is empty for those of the main nodes.
+type Metric struct {
+	Time  time.Time
+	Node  int
+	Group string `json:",omitempty"`
+	Pod   string
+	Name  string
+	Value float64
+}
+
+// Outcomes counts what happened in some part of a run.
+type Outcomes struct {
+	Successes int
+	Failures  int
+	Timeouts  int
+	// Errors count the nodes kubectl couldn't run the step on, e.g. because
+	// their pod was gone.
+	Errors int
+}
+
+// Outcomes adds up the outcomes of the steps of the iteration.
+func (iteration *IterationResult) Outcomes() Outcomes {
+	var total Outcomes
+	for _, step := range iteration.Steps {
+		total.Successes += step.Successes
+		total.Failures += step.Failures
+		total.Timeouts += step.Timeouts
+		total.Errors += step.Errors
+	}
+	total.Errors += iteration.Errors
+	return total
+}
+
+// NodePod records a pod that served as a node of the run. A node whose pod
+// was replaced shows up once per pod, in the order they served.
+type NodePod struct {
+	Node  int
+	Group string `json:",omitempty"`
+	Pod   string
+}
+
+// ChurnEvent is churn taking a node down or bringing it back: killed and
+// restarted for its daemon, deleted for its pod, scaled_down and scaled_up
+// for its workload, which picks the pod itself.
+type ChurnEvent struct {
+	Time   time.Time
+	Node   int    `json:",omitempty"`
+	Group  string `json:",omitempty"`
+	Pod    string `json:",omitempty"`
+	Action string
+	Error  string `json:",omitempty"`
+}
+
+// PodIncident is something that happened to the pod of a node during an
+// iteration besides its steps: a container restart, an OOM kill or an
+// eviction.
+type PodIncident struct {
+	Time    time.Time
+	Node    int
+	Group   string `json:",omitempty"`
+	Pod     string
+	Kind    string
+	Message string
+}
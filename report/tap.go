@@ -0,0 +1,54 @@
+package report
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// writeTAPReport writes the run as Test Anything Protocol version 13, one
+// test line per assertion and per timed out node, so runs plug into prove
+// and sharness based pipelines. A path of "-" writes to stdout.
+func writeTAPReport(path string, summary *Summary) error {
+	var lines bytes.Buffer
+	count := 0
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			if step.Skipped {
+				count++
+				fmt.Fprintf(&lines, "ok %d - iteration %d step %d %s # SKIP\n", count, iteration.Index, step.Index, tapEscape(step.Name))
+				continue
+			}
+			for _, node := range step.Nodes {
+				what := fmt.Sprintf("iteration %d step %d %s node %d", iteration.Index, step.Index, tapEscape(step.Name), node.Node)
+				if node.TimedOut {
+					count++
+					fmt.Fprintf(&lines, "not ok %d - %s timed out\n", count, what)
+					continue
+				}
+				for _, assertion := range node.Assertions {
+					count++
+					if assertion.Passed {
+						fmt.Fprintf(&lines, "ok %d - %s\n", count, what)
+						continue
+					}
+					fmt.Fprintf(&lines, "not ok %d - %s\n", count, what)
+					fmt.Fprintf(&lines, "  ---\n  expected: %q\n  actual: %q\n  ...\n", assertion.Expected, assertion.Actual)
+				}
+			}
+		}
+	}
+	out := fmt.Sprintf("TAP version 13\n1..%d\n", count) + lines.String()
+	if path == "-" {
+		_, err := os.Stdout.WriteString(out)
+		return err
+	}
+	return ioutil.WriteFile(path, []byte(out), 0664)
+}
+
+// tapEscape keeps a description from being read as a directive.
+func tapEscape(s string) string {
+	return strings.Replace(s, "#", `\#`, -1)
+}
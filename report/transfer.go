@@ -0,0 +1,82 @@
+package report
+
+import (
+	"math"
+	"sort"
+	"strconv"
+)
+
+// Transfer is how a node fetched content with a timed cat.
+type Transfer struct {
+	Bytes int64
+	// TTFB is the time until the first byte came out, and Seconds the time
+	// of the whole transfer.
+	TTFB    float64
+	Seconds float64
+	// Throughput is in MB/s.
+	Throughput float64
+}
+
+// Stats sum up measures: their mean, median and 95th percentile.
+type Stats struct {
+	Mean   float64
+	Median float64
+	P95    float64
+}
+
+// NewStats sums up measures, of which there is at least one.
+func NewStats(values []float64) Stats {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	var sum float64
+	for _, value := range sorted {
+		sum += value
+	}
+	n := len(sorted)
+	median := sorted[n/2]
+	if n%2 == 0 {
+		median = (sorted[n/2-1] + sorted[n/2]) / 2
+	}
+	// The nearest rank, so that a few measures don't make one up.
+	p95 := sorted[int(math.Ceil(0.95*float64(n)))-1]
+	return Stats{Mean: sum / float64(n), Median: median, P95: p95}
+}
+
+// TransferSummary sums up the transfers of a step over its nodes and the
+// iterations.
+type TransferSummary struct {
+	Step       string
+	Transfers  int
+	TTFB       Stats
+	Throughput Stats
+}
+
+// RunTransfers sums up the transfers of every step that timed them, in the
+// order of the steps.
+func RunTransfers(summary *Summary) []TransferSummary {
+	var steps []int
+	names := make(map[int]string)
+	ttfb, throughput := make(map[int][]float64), make(map[int][]float64)
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			for _, node := range step.Nodes {
+				if node.Transfer == nil {
+					continue
+				}
+				if _, ok := names[step.Index]; !ok {
+					steps = append(steps, step.Index)
+					names[step.Index] = strconv.Itoa(step.Index) + ". " + step.Name
+				}
+				ttfb[step.Index] = append(ttfb[step.Index], node.Transfer.TTFB)
+				throughput[step.Index] = append(throughput[step.Index], node.Transfer.Throughput)
+			}
+		}
+	}
+	sort.Ints(steps)
+	transfers := make([]TransferSummary, 0, len(steps))
+	for _, index := range steps {
+		transfers = append(transfers, TransferSummary{Step: names[index], Transfers: len(ttfb[index]),
+			TTFB: NewStats(ttfb[index]), Throughput: NewStats(throughput[index])})
+	}
+	return transfers
+}
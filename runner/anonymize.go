@@ -0,0 +1,214 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+var ipv4Regexp = regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}\b`)
+
+// ipv6Regexp matches IPv6 addresses, full or with :: in them, but not times
+// like 12:34:56.
+var ipv6Regexp = regexp.MustCompile(`\b(?:[0-9a-fA-F]{1,4}:){7}[0-9a-fA-F]{1,4}\b|` +
+	`\b(?:[0-9a-fA-F]{1,4}:){1,6}:(?:[0-9a-fA-F]{1,4}(?::[0-9a-fA-F]{1,4}){0,5}\b)?|` +
+	`::[0-9a-fA-F]{1,4}(?::[0-9a-fA-F]{1,4}){0,6}\b`)
+
+// anonymizer replaces pod names, IP addresses and cluster endpoints with
+// pseudonyms, so reports can be shared outside of the cluster's owners. The
+// same identifier always maps to the same pseudonym within a run, and pods
+// are named after their group and node index so pseudonyms also line up
+// across runs.
+// A nil anonymizer leaves everything untouched.
+type anonymizer struct {
+	pseudonyms map[string]string
+	counts     map[string]int
+}
+
+func newAnonymizer() *anonymizer {
+	return &anonymizer{
+		pseudonyms: make(map[string]string),
+		counts:     make(map[string]int),
+	}
+}
+
+// learn registers an identifier under the given kind ("pod", "ip",
+// "endpoint") and returns its pseudonym.
+func (a *anonymizer) learn(kind string, real string) string {
+	if pseudonym, ok := a.pseudonyms[real]; ok {
+		return pseudonym
+	}
+	a.counts[kind]++
+	pseudonym := fmt.Sprintf("%s-%d", kind, a.counts[kind])
+	a.pseudonyms[real] = pseudonym
+	return pseudonym
+}
+
+// learnPods registers every pod that shows up in the results, named after the
+// group and node index it served as. The mapping holds the group of every
+// pod, in the order they served, so it goes first.
+func (a *anonymizer) learnPods(summary *report.Summary) {
+	for _, pod := range summary.Mapping {
+		a.learnPod(pod.Group, pod.Node, pod.Pod)
+	}
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			for _, node := range step.Nodes {
+				a.learnPod("", node.Node, node.Pod)
+			}
+		}
+	}
+}
+
+// learnPod registers a pod as pod-<node>, or <group>-pod-<node> for the
+// nodes of a group. The pods serving as a node after the first one, e.g.
+// replacing it, get their number after a dot, as in pod-3.2.
+func (a *anonymizer) learnPod(group string, node int, pod string) {
+	if _, ok := a.pseudonyms[pod]; ok || pod == "" {
+		return
+	}
+	base := fmt.Sprintf("pod-%d", node)
+	if group != "" {
+		base = group + "-" + base
+	}
+	a.counts[base]++
+	pseudonym := base
+	if a.counts[base] > 1 {
+		pseudonym = fmt.Sprintf("%s.%d", base, a.counts[base])
+	}
+	a.pseudonyms[pod] = pseudonym
+}
+
+// learnClusterEndpoint registers the API server of the current kubectl
+// context.
+func (a *anonymizer) learnClusterEndpoint() {
+	var out bytes.Buffer
+	cmd := exec.Command("kubectl", "config", "view", "--minify", "-o", "jsonpath={.clusters[0].cluster.server}")
+	cmd.Stdout = &out
+	if cmd.Run() != nil {
+		return
+	}
+	server, err := url.Parse(strings.TrimSpace(out.String()))
+	if err != nil || server.Hostname() == "" {
+		return
+	}
+	if !ipv4Regexp.MatchString(server.Hostname()) && !ipv6Regexp.MatchString(server.Hostname()) {
+		a.learn("endpoint", server.Hostname())
+	}
+}
+
+// scrub replaces every known identifier and any IP address in s.
+func (a *anonymizer) scrub(s string) string {
+	if a == nil {
+		return s
+	}
+	s = ipv4Regexp.ReplaceAllStringFunc(s, func(ip string) string {
+		return a.learn("ip", ip)
+	})
+	s = ipv6Regexp.ReplaceAllStringFunc(s, func(ip string) string {
+		return a.learn("ip", ip)
+	})
+	// Replace longer identifiers first so a pod name never gets clobbered by
+	// a shorter one it contains.
+	reals := make([]string, 0, len(a.pseudonyms))
+	for real := range a.pseudonyms {
+		reals = append(reals, real)
+	}
+	sort.Slice(reals, func(i, j int) bool { return len(reals[i]) > len(reals[j]) })
+	for _, real := range reals {
+		s = strings.Replace(s, real, a.pseudonyms[real], -1)
+	}
+	return s
+}
+
+// summary returns a copy of summary with all identifiers scrubbed.
+func (a *anonymizer) summary(summary *report.Summary) *report.Summary {
+	return scrubSummary(summary, a.scrub)
+}
+
+// scrubSummary returns a copy of summary with scrub applied to the commands,
+// pods, outputs, fields, errors, assertions and logs.
+func scrubSummary(summary *report.Summary, scrub func(string) string) *report.Summary {
+	anon := *summary
+	anon.Iterations = make([]*report.IterationResult, 0, len(summary.Iterations))
+	for _, iteration := range summary.Iterations {
+		i := *iteration
+		i.Steps = make([]*report.StepResult, 0, len(iteration.Steps))
+		for _, step := range iteration.Steps {
+			s := *step
+			s.CMD = scrub(step.CMD)
+			s.Nodes = make([]*report.NodeResult, 0, len(step.Nodes))
+			for _, node := range step.Nodes {
+				n := *node
+				n.Pod = scrub(node.Pod)
+				n.Output = make([]string, len(node.Output))
+				for index, line := range node.Output {
+					n.Output[index] = scrub(line)
+				}
+				n.Stderr = make([]string, len(node.Stderr))
+				for index, line := range node.Stderr {
+					n.Stderr[index] = scrub(line)
+				}
+				if node.Fields != nil {
+					n.Fields = make(map[string]string, len(node.Fields))
+					for name, value := range node.Fields {
+						n.Fields[name] = scrub(value)
+					}
+				}
+				n.Error = scrub(node.Error)
+				n.OutputFile = scrub(node.OutputFile)
+				n.Assertions = make([]report.AssertionResult, len(node.Assertions))
+				for index, assertion := range node.Assertions {
+					assertion.Expected = scrub(assertion.Expected)
+					assertion.Actual = scrub(assertion.Actual)
+					n.Assertions[index] = assertion
+				}
+				s.Nodes = append(s.Nodes, &n)
+			}
+			i.Steps = append(i.Steps, &s)
+		}
+		i.Incidents = make([]report.PodIncident, len(iteration.Incidents))
+		for index, incident := range iteration.Incidents {
+			incident.Pod = scrub(incident.Pod)
+			incident.Message = scrub(incident.Message)
+			i.Incidents[index] = incident
+		}
+		i.Churn = make([]report.ChurnEvent, len(iteration.Churn))
+		for index, event := range iteration.Churn {
+			event.Pod = scrub(event.Pod)
+			event.Error = scrub(event.Error)
+			i.Churn[index] = event
+		}
+		i.Resources = make([]report.ResourceUsage, len(iteration.Resources))
+		for index, usage := range iteration.Resources {
+			usage.Pod = scrub(usage.Pod)
+			i.Resources[index] = usage
+		}
+		anon.Iterations = append(anon.Iterations, &i)
+	}
+	anon.Metrics = make([]report.Metric, len(summary.Metrics))
+	for index, metric := range summary.Metrics {
+		metric.Pod = scrub(metric.Pod)
+		anon.Metrics[index] = metric
+	}
+	anon.Logs = make([]report.NodeLog, len(summary.Logs))
+	for index, log := range summary.Logs {
+		lines := make([]string, len(log.Lines))
+		for l, line := range log.Lines {
+			lines[l] = scrub(line)
+		}
+		anon.Logs[index] = report.NodeLog{Node: log.Node, Pod: scrub(log.Pod), Lines: lines}
+	}
+	anon.Mapping = make([]report.NodePod, len(summary.Mapping))
+	for index, pod := range summary.Mapping {
+		pod.Pod = scrub(pod.Pod)
+		anon.Mapping[index] = pod
+	}
+	return &anon
+}
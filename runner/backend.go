@@ -0,0 +1,177 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+)
+
+// Backend is where the nodes of a test run: pods of a Kubernetes cluster, or
+// local nodes standing in for them on a laptop or in CI. Local nodes are
+// handled as pods named after them.
+type Backend interface {
+	// Nodes makes sure the nodes of the config run and returns them.
+	Nodes(cfg *config.Config) (*GetPodsOutput, error)
+	// Command returns the command running a kubectl exec of the node, args
+	// being "exec", the node, its flags, "--" and the command.
+	Command(ctx context.Context, pod string, args []string) *exec.Cmd
+	// Restart restarts the daemon of a node, and reports false when that
+	// is left to the restart_cmd run on the node.
+	Restart(cfg *config.Config, pod Pod) (bool, error)
+	// Validate checks that the test only uses what the backend can do.
+	Validate(test *config.Test) error
+}
+
+// backend runs the nodes of the test, set with --backend.
+var backend Backend = kubernetesBackend{}
+
+func newBackend(name string) (Backend, error) {
+	switch name {
+	case "kubernetes":
+		return kubernetesBackend{}, nil
+	case "iptb":
+		return iptbBackend{}, nil
+	case "docker":
+		return dockerBackend{}, nil
+	default:
+		return nil, fmt.Errorf("--backend must be kubernetes, iptb or docker")
+	}
+}
+
+// localBackend tells whether the nodes run locally rather than in a
+// cluster.
+func localBackend() bool {
+	_, ok := backend.(kubernetesBackend)
+	return !ok
+}
+
+// kubernetesBackend runs the steps in the pods of a cluster through kubectl.
+type kubernetesBackend struct{}
+
+func (kubernetesBackend) Nodes(cfg *config.Config) (*GetPodsOutput, error) {
+	return ensurePods(cfg)
+}
+
+func (kubernetesBackend) Command(ctx context.Context, pod string, args []string) *exec.Cmd {
+	return targetOf(pod).command(ctx, args...)
+}
+
+// Restart restarts the container of a pod whose daemon is its main process,
+// as in go-ipfs-deployment.yml, since a daemon started next to it would go
+// down with the container once Kubernetes restarts it. Other pods are left to
+// the restart_cmd, as are all of them when the test sets one.
+func (kubernetesBackend) Restart(cfg *config.Config, pod Pod) (bool, error) {
+	if cfg.RestartCmd != "" || !daemonIsMain(pod.Metadata.Name) {
+		return false, nil
+	}
+	return true, restartContainer(pod.Metadata.Name)
+}
+
+func (kubernetesBackend) Validate(test *config.Test) error {
+	return nil
+}
+
+// containerRestartTimeout is how long we wait for Kubernetes to restart a
+// container, which it backs off from when it restarts often.
+const containerRestartTimeout = 5 * time.Minute
+
+// daemonIsMain tells whether the ipfs daemon of a pod is the main process of
+// its container.
+func daemonIsMain(name string) bool {
+	out, _ := RunInPod(name, "cat /proc/1/comm", nil, 10)
+	return len(out) > 0 && strings.TrimSpace(out[0]) == "ipfs"
+}
+
+// restartContainer shuts down the daemon of a pod, the main process of its
+// container, and waits until Kubernetes restarted the container and the
+// daemon answers again.
+func restartContainer(name string) error {
+	before, err := restartCount(name)
+	if err != nil {
+		return err
+	}
+	RunInPod(name, "ipfs shutdown", nil, 30)
+	deadline := time.Now().Add(containerRestartTimeout)
+	for {
+		count, err := restartCount(name)
+		if err == nil && count > before {
+			return waitForDaemonUntil(name, deadline)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the container of %s did not restart in time", name)
+		}
+		if !sleep(time.Second) {
+			return runContext.Err()
+		}
+	}
+}
+
+// restartCount returns how many times the containers of a pod restarted.
+func restartCount(name string) (int, error) {
+	cmd := targetOf(name).command(runContext, "get", "pod", name, "-o", "jsonpath={.status.containerStatuses[*].restartCount}")
+	var out, errout bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errout
+	err := cmd.Run()
+	if err != nil {
+		return 0, fmt.Errorf("could not get the restarts of %s: %s", name, strings.TrimSpace(errout.String()))
+	}
+	total := 0
+	for _, field := range strings.Fields(out.String()) {
+		count, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, fmt.Errorf("could not read the restarts of %s: %q", name, out.String())
+		}
+		total += count
+	}
+	return total, nil
+}
+
+// execArgs returns the command of the args of a kubectl exec, after "--".
+func execArgs(args []string) []string {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[i+1:]
+		}
+	}
+	return nil
+}
+
+// validateLocal checks that the test doesn't use what only a cluster has,
+// for the backends running the nodes locally.
+func validateLocal(test *config.Test, name string) error {
+	cfg := &test.Config
+	if cfg.Nodes < 1 {
+		return fmt.Errorf("the %s backend needs a number of nodes", name)
+	}
+	switch {
+	case len(cfg.Groups) != 0:
+		return fmt.Errorf("the %s backend has no groups", name)
+	case cfg.ClusterSelector != "" || len(cfg.Helm) != 0 || cfg.Manifest != "":
+		return fmt.Errorf("the %s backend can't provision ipfs-cluster, helm releases or manifests", name)
+	case cfg.MonitorPods || cfg.SampleResources != "" || cfg.WaitForReplacement != "" || cfg.Observe != nil || cfg.WaitForScrape:
+		return fmt.Errorf("the %s backend can't monitor, replace or observe pods", name)
+	case cfg.Churn != nil:
+		return fmt.Errorf("the %s backend can't churn nodes", name)
+	}
+	for _, step := range test.Steps {
+		switch {
+		case step.Shape != nil || step.ShapeReset || step.Partition != nil || step.Heal != "":
+			return fmt.Errorf("step %s shapes or partitions the network, which the %s backend can't", step.Name, name)
+		case step.KillNode != "" || step.WaitForReschedule:
+			return fmt.Errorf("step %s kills nodes, which the %s backend can't", step.Name, name)
+		case step.Stress != nil && len(step.Stress.Limits) != 0:
+			return fmt.Errorf("step %s resizes pods, which the %s backend can't", step.Name, name)
+		case step.Gateway != nil && step.Gateway.URL == "":
+			return fmt.Errorf("step %s fetches from the nodes' gateway, which the %s backend only reaches with a url", step.Name, name)
+		case config.IsClusterStep(&step) || step.PromQL != "" || step.Lock != "":
+			return fmt.Errorf("step %s needs Kubernetes, which the %s backend doesn't use", step.Name, name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// outputLimit is the number of lines of a node's output kept in memory from
+// its start and from its end, all of them when 0. Set by the test's
+// output_limit.
+var outputLimit int
+
+// outputDir receives the full output of every node of every step, when set
+// with --output-dir, in a directory per iteration.
+var outputDir string
+
+// outputCapture collects the output of a command on a node line by line. It
+// streams everything to the node's output file and keeps only the first and
+// last outputLimit lines, so a step printing a large file can't exhaust the
+// runner's memory. The lines in between are dropped from the one at index
+// outputLimit on.
+type outputCapture struct {
+	file    *os.File
+	path    string
+	head    []string
+	tail    []string
+	partial bytes.Buffer
+	dropped int
+}
+
+// newOutputCapture prepares capturing the output of a node in a step of an
+// iteration.
+func newOutputCapture(iteration *report.IterationResult, step int, node int) *outputCapture {
+	capture := new(outputCapture)
+	if outputDir == "" {
+		return capture
+	}
+	dir := filepath.Join(outputDir, strings.Replace(iteration.String(), " ", "-", -1))
+	err := os.MkdirAll(dir, 0775)
+	if err == nil {
+		capture.path = filepath.Join(dir, fmt.Sprintf("step-%d-node-%d.out", step, node))
+		capture.file, err = os.Create(capture.path)
+	}
+	if err != nil {
+		color.Red("Failed to create output file: %s", err)
+		capture.path = ""
+	}
+	return capture
+}
+
+func (c *outputCapture) Write(p []byte) (int, error) {
+	n := len(p)
+	if c.file != nil {
+		c.file.Write(p)
+	}
+	for len(p) != 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			c.partial.Write(p)
+			break
+		}
+		c.partial.Write(p[:i])
+		c.add(c.partial.String())
+		c.partial.Reset()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+func (c *outputCapture) add(line string) {
+	if outputLimit == 0 || len(c.head) < outputLimit {
+		c.head = append(c.head, line)
+		return
+	}
+	c.tail = append(c.tail, line)
+	if len(c.tail) > outputLimit {
+		c.tail = c.tail[1:]
+		c.dropped++
+	}
+}
+
+// lines returns the kept lines, split like strings.Split would: output
+// ending in a newline ends in an empty line.
+func (c *outputCapture) lines() []string {
+	lines := make([]string, 0, len(c.head)+len(c.tail)+1)
+	lines = append(lines, c.head...)
+	lines = append(lines, c.tail...)
+	return append(lines, c.partial.String())
+}
+
+// droppedFrom returns the index of the first dropped line, 0 when none was.
+func (c *outputCapture) droppedFrom() int {
+	if c.dropped == 0 {
+		return 0
+	}
+	return len(c.head)
+}
+
+func (c *outputCapture) Close() error {
+	if c.file == nil {
+		return nil
+	}
+	return c.file.Close()
+}
+
+// maskedLines prints the stderr of a command line by line as it comes, with
+// the secrets masked, which takes whole lines.
+type maskedLines struct {
+	partial bytes.Buffer
+}
+
+func (m *maskedLines) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) != 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i < 0 {
+			m.partial.Write(p)
+			break
+		}
+		m.partial.Write(p[:i])
+		fmt.Println(mask(m.partial.String()))
+		m.partial.Reset()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// Flush prints the last line, when it didn't end with a newline.
+func (m *maskedLines) Flush() {
+	if m.partial.Len() != 0 {
+		fmt.Println(mask(m.partial.String()))
+		m.partial.Reset()
+	}
+}
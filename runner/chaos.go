@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// defaultRescheduleTimeout is how long, in seconds, wait_for_reschedule waits
+// when the step has no timeout.
+const defaultRescheduleTimeout = 300
+
+// KilledPod remembers where a pod taken down by kill_node sat in the fleet,
+// so its replacement can take the same node number.
+type KilledPod struct {
+	Group string
+	Node  int
+	Pod   Pod
+	Mode  string
+}
+
+// handleKillStep takes down the step's nodes, either by deleting their pods
+// (the deployment schedules replacements) or by killing the ipfs daemon
+// inside them (the container restarts if the daemon is its main process).
+func handleKillStep(pods GetPodsOutput, fleet *Fleet, step *config.Step, result *report.StepResult, env []string) []string {
+	color.Blue("### Killing %s on nodes %d to %d", step.KillNode, step.OnNode, step.EndNode)
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		pod := pods.Items[j-1]
+		var err error
+		if step.KillNode == "pod" {
+			err = targetOf(pod.Metadata.Name).kubectl("delete", "pod", pod.Metadata.Name, "--grace-period=0", "--force", "--wait=false")
+		} else {
+			RunInPod(pod.Metadata.Name, killDaemonCmd, nil, 10)
+		}
+		result.Nodes = append(result.Nodes, &report.NodeResult{Node: j, Pod: pod.Metadata.Name})
+		if err != nil {
+			color.Red("Could not kill node %d: %s", j, err)
+			continue
+		}
+		fleet.Killed = append(fleet.Killed, KilledPod{Group: step.OnGroup, Node: j, Pod: pod, Mode: step.KillNode})
+	}
+	return env
+}
+
+// handleRescheduleStep waits until every node killed so far is back: deleted
+// pods are replaced in the fleet by the pods scheduled in their place, and
+// killed daemons have to answer again. A node that doesn't come back in time
+// counts as a timeout.
+func handleRescheduleStep(fleet *Fleet, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	timeout := step.Timeout
+	if timeout == 0 {
+		timeout = defaultRescheduleTimeout
+	}
+	color.Blue("### Waiting up to %d seconds for %d killed nodes to come back", timeout, len(fleet.Killed))
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for _, killed := range fleet.Killed {
+		var err error
+		if killed.Mode == "pod" {
+			err = fleet.replace(killed, deadline)
+		} else {
+			err = waitForDaemonUntil(killed.Pod.Metadata.Name, deadline)
+			if err == nil {
+				err = fleet.reconnect([]Pod{killed.Pod})
+			}
+		}
+		if err != nil {
+			color.Red("Node %d did not come back: %s", killed.Node, err)
+			summary.Timeouts++
+			result.Timeouts++
+		}
+	}
+	fleet.Killed = nil
+	return env
+}
+
+// replace waits for a running pod that isn't part of the fleet yet and puts
+// it in the killed pod's place.
+func (fleet *Fleet) replace(killed KilledPod, deadline time.Time) error {
+	cfg := fleet.Config
+	pods := fleet.Pods
+	if killed.Group != "" {
+		for _, group := range fleet.Config.Groups {
+			if group.Name == killed.Group {
+				cfg = group.Config()
+			}
+		}
+		pods = fleet.Groups[killed.Group]
+	}
+	// A StatefulSet replaces a pod with one of the same name, told apart by
+	// its UID.
+	known := make(map[string]bool)
+	for _, pod := range pods.Items {
+		if pod.Metadata.Name != killed.Pod.Metadata.Name {
+			known[pod.Metadata.Name] = true
+		}
+	}
+	for time.Now().Before(deadline) {
+		current, err := getPods(cfg)
+		if err != nil {
+			return err
+		}
+		for _, pod := range current.Items {
+			if !known[pod.Metadata.Name] && pod.Metadata.UID != killed.Pod.Metadata.UID && podReady(pod) {
+				err = waitForDaemonUntil(pod.Metadata.Name, deadline)
+				if err != nil {
+					return err
+				}
+				color.Green("Node %d is now %s", killed.Node, pod.Metadata.Name)
+				fleetMutex.Lock()
+				pods.Items[killed.Node-1] = pod
+				fleetMutex.Unlock()
+				fleet.Mapping.set(killed.Group, killed.Node, pod.Metadata.Name)
+				return fleet.adopt(pod)
+			}
+		}
+		time.Sleep(3 * time.Second)
+	}
+	return fmt.Errorf("no replacement for %s was scheduled", killed.Pod.Metadata.Name)
+}
@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// runState is the progress of a run, written to --state-file after every
+// step so that an interrupted run can go on with --resume instead of
+// starting over.
+type runState struct {
+	// Test and Steps tell whether the state belongs to the test resumed.
+	Test  string
+	Steps int
+	// Seed replays the randomness of the interrupted run.
+	Seed int64
+	// Next is the first iteration not finished yet, counting from 0 and
+	// including the warm-ups.
+	Next    int
+	RunEnv  []string
+	Summary report.Summary
+	// Partial is the iteration Next when some of its steps ran already,
+	// with the variables they saved.
+	Partial *report.Summary `json:",omitempty"`
+	Env     []string        `json:",omitempty"`
+}
+
+// loadState reads the progress saved by an interrupted run.
+func loadState(path string) (*runState, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("nothing to resume: %s", err)
+	}
+	state := new(runState)
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %s", path, err)
+	}
+	return state, nil
+}
+
+// save writes the state, replacing the previous one only once it's
+// complete. The variables of the runner's environment and the secrets are
+// left out; resuming reads them again.
+func (state *runState) save(path string, host []string) error {
+	state.RunEnv = withoutVariables(state.RunEnv, host)
+	state.Env = withoutVariables(state.Env, host)
+	state.Summary = *scrubSummary(&state.Summary, mask)
+	if state.Partial != nil {
+		state.Partial = scrubSummary(state.Partial, mask)
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path+".tmp", data, 0664)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// withoutVariables drops the variables set in other from env.
+func withoutVariables(env []string, other []string) []string {
+	names := make(map[string]bool)
+	for _, e := range other {
+		if found := config.EnvVarRegexp.FindStringSubmatch(e); len(found) == 3 {
+			names[found[1]] = true
+		}
+	}
+	var kept []string
+	for _, e := range env {
+		if found := config.EnvVarRegexp.FindStringSubmatch(e); len(found) != 3 || !names[found[1]] {
+			kept = append(kept, e)
+		}
+	}
+	return kept
+}
+
+// saveState writes the progress of a run to its --state-file.
+func saveState(opts *Options, test *config.Test, state *runState, host []string) {
+	state.Test, state.Steps, state.Seed = test.Name, len(test.Steps), opts.Seed
+	err := state.save(opts.StateFile, host)
+	if err != nil {
+		color.Red("Failed to save the progress of the run: %s", err)
+	}
+}
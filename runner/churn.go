@@ -0,0 +1,116 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+)
+
+// podReady reports whether steps can run on a pod: it runs, isn't being
+// deleted and passes its readiness checks, which a crash-looping pod doesn't.
+func podReady(pod Pod) bool {
+	if pod.Status.Phase != "Running" || pod.Metadata.DeletionTimestamp != nil {
+		return false
+	}
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == "Ready" {
+			return condition.Status == "True"
+		}
+	}
+	return true
+}
+
+func readyPods(pods []Pod) []Pod {
+	var ready []Pod
+	for _, pod := range pods {
+		if podReady(pod) {
+			ready = append(ready, pod)
+		}
+	}
+	return ready
+}
+
+// replaceChurned puts a ready pod in the place of every node of the fleet
+// whose pod went away or stopped being ready, waiting until the deadline for
+// the replacements to be scheduled. Nodes keep their numbers, so the steps
+// after churn run on the pods that took over.
+func (fleet *Fleet) replaceChurned(deadline time.Time) error {
+	if fleet.Config.Selector != "" {
+		err := fleet.replaceChurnedIn("", fleet.Config, fleet.Pods, fleet.Config.Nodes, deadline)
+		if err != nil {
+			return err
+		}
+	}
+	for _, group := range fleet.Config.Groups {
+		pods := fleet.Groups[group.Name]
+		err := fleet.replaceChurnedIn(group.Name, group.Config(), pods, len(pods.Items), deadline)
+		if err != nil {
+			return fmt.Errorf("group %s: %s", group.Name, err)
+		}
+	}
+	return nil
+}
+
+func (fleet *Fleet) replaceChurnedIn(group string, cfg *config.Config, pods *GetPodsOutput, nodes int, deadline time.Time) error {
+	for {
+		current, err := getPods(cfg)
+		if err != nil {
+			return err
+		}
+		ready := make(map[string]Pod)
+		for _, pod := range readyPods(current.Items) {
+			ready[pod.Metadata.Name] = pod
+		}
+		// A StatefulSet replaces a pod with one of the same name, told apart
+		// by its UID.
+		known := make(map[string]bool)
+		var churned []int
+		for node := 1; node <= nodes; node++ {
+			pod := pods.Items[node-1]
+			if now, ok := ready[pod.Metadata.Name]; ok && now.Metadata.UID == pod.Metadata.UID {
+				known[pod.Metadata.Name] = true
+			} else {
+				churned = append(churned, node)
+			}
+		}
+		if len(churned) == 0 {
+			return nil
+		}
+		for _, pod := range current.Items {
+			if len(churned) == 0 {
+				break
+			}
+			if !podReady(pod) || known[pod.Metadata.Name] {
+				continue
+			}
+			node := churned[0]
+			err = waitForDaemonUntil(pod.Metadata.Name, deadline)
+			if err != nil {
+				continue
+			}
+			color.Green("Node %d is now %s, replacing %s", node, pod.Metadata.Name, pods.Items[node-1].Metadata.Name)
+			fleetMutex.Lock()
+			pods.Items[node-1] = pod
+			fleetMutex.Unlock()
+			fleet.Mapping.set(group, node, pod.Metadata.Name)
+			known[pod.Metadata.Name] = true
+			churned = churned[1:]
+			err = fleet.adopt(pod)
+			if err != nil {
+				return err
+			}
+		}
+		if len(churned) == 0 {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("no ready pod to replace nodes %v", churned)
+		}
+		color.Yellow("Waiting for pods to replace nodes %v", churned)
+		if !sleep(3 * time.Second) {
+			return runContext.Err()
+		}
+	}
+}
@@ -0,0 +1,310 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// validateChurn checks the churn of a test.
+func validateChurn(test *config.Test, churn *config.Churn) error {
+	if rate, err := config.ParseWait(churn.Rate); err != nil || rate <= 0 {
+		return fmt.Errorf("churn needs a rate, e.g. 30s")
+	}
+	if _, err := config.ParseWait(churn.Duration); churn.Duration != "" && err != nil {
+		return fmt.Errorf("invalid churn duration: %s", err)
+	}
+	if _, err := config.ParseWait(churn.Downtime); churn.Downtime != "" && err != nil {
+		return fmt.Errorf("invalid churn downtime: %s", err)
+	}
+	cfg := &test.Config
+	if churn.OnGroup != "" {
+		cfg = nil
+		for _, group := range test.Config.Groups {
+			if group.Name == churn.OnGroup {
+				cfg = group.Config()
+			}
+		}
+		if cfg == nil {
+			return fmt.Errorf("churn is on unknown group %s", churn.OnGroup)
+		}
+	}
+	if churn.OnNode < 0 || churn.EndNode != 0 && churn.EndNode < churn.OnNode {
+		return fmt.Errorf("churn has an invalid node range %d to %d", churn.OnNode, churn.EndNode)
+	}
+	switch churn.ChurnMode() {
+	case "daemon":
+	case "pod", "scale":
+		// Steps would go on running on the pods that went away.
+		if test.Config.WaitForReplacement == "" {
+			return fmt.Errorf("churn with mode %s needs wait_for_replacement", churn.Mode)
+		}
+		if churn.Mode == "scale" && cfg.WorkloadKind == "daemonset" {
+			return fmt.Errorf("churn can't scale a daemonset")
+		}
+		// The workload picks the pod it scales down.
+		if churn.Mode == "scale" && (churn.OnNode != 0 || churn.EndNode != 0) {
+			return fmt.Errorf("churn with mode scale can't pick nodes with on_node and end_node")
+		}
+	default:
+		return fmt.Errorf("churn mode must be daemon, pod or scale")
+	}
+	return nil
+}
+
+// killDaemonCmd kills the ipfs daemon of a pod. A daemon that is the main
+// process of its container only gets the signals it handles, so it is
+// terminated instead, and comes back once Kubernetes restarted the container.
+const killDaemonCmd = "if [ \"$(cat /proc/1/comm)\" = ipfs ]; then kill 1 && echo main; " +
+	"else (pkill -9 -x ipfs || kill -9 $(pgrep -x ipfs)) && echo killed; fi"
+
+// fleetMutex guards the pods put in the place of others in a fleet during an
+// iteration, which churn picks from in the background.
+var fleetMutex sync.Mutex
+
+// churner takes nodes down and brings them back in the background while an
+// iteration runs, as the test's churn says.
+type churner struct {
+	fleet     *Fleet
+	churn     *config.Churn
+	cfg       *config.Config
+	iteration int
+	writer    *eventWriter
+	random    *rand.Rand
+	stop      chan struct{}
+	done      sync.WaitGroup
+
+	// scaling serializes the scaling of the workload, which reads its
+	// replicas before setting them.
+	scaling sync.Mutex
+
+	mutex sync.Mutex
+	// down holds the pods taken down, by name and UID, as a StatefulSet
+	// replaces a pod with one of the same name.
+	down   map[string]bool
+	events []report.ChurnEvent
+}
+
+// startChurn starts churning the fleet's nodes until the churner is
+// finished, picking them at random from seed.
+func startChurn(fleet *Fleet, churn *config.Churn, seed int64, iteration int, writer *eventWriter) *churner {
+	c := &churner{fleet: fleet, churn: churn, cfg: fleet.Config, iteration: iteration, writer: writer,
+		random: rand.New(rand.NewSource(seed)), stop: make(chan struct{}), down: make(map[string]bool)}
+	for _, group := range fleet.Config.Groups {
+		if group.Name == churn.OnGroup {
+			c.cfg = group.Config()
+		}
+	}
+	// validateTest made sure they parse.
+	rate, _ := config.ParseWait(churn.Rate)
+	duration, _ := config.ParseWait(churn.Duration)
+	color.Cyan("## Churning nodes with %s every %s", churn.ChurnMode(), rate)
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		var end <-chan time.Time
+		if duration > 0 {
+			timer := time.NewTimer(duration)
+			defer timer.Stop()
+			end = timer.C
+		}
+		ticker := time.NewTicker(rate)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-end:
+				return
+			case <-c.stop:
+				return
+			case <-runContext.Done():
+				return
+			}
+			c.takeDown()
+		}
+	}()
+	return c
+}
+
+// takeDown takes a node down, one that isn't down already, and brings it
+// back after the downtime, or right away when churn is over by then.
+func (c *churner) takeDown() {
+	mode := c.churn.ChurnMode()
+	if mode == "scale" {
+		if c.scale(-1) {
+			c.afterDowntime(func() { c.scale(1) })
+		}
+		return
+	}
+	fleetMutex.Lock()
+	pods, err := c.fleet.resolve(c.churn.OnGroup, c.churn.OnNode, c.churn.EndNode)
+	pods = append([]Pod(nil), pods...)
+	fleetMutex.Unlock()
+	if err != nil {
+		c.record(report.ChurnEvent{Action: "killed", Error: err.Error()})
+		return
+	}
+	first := c.churn.OnNode
+	if first == 0 {
+		first = 1
+	}
+	c.mutex.Lock()
+	var up []int
+	for i, pod := range pods {
+		if !c.down[podKey(pod)] {
+			up = append(up, first+i)
+		}
+	}
+	if len(up) == 0 {
+		c.mutex.Unlock()
+		return
+	}
+	node := up[c.random.Intn(len(up))]
+	pod := pods[node-first]
+	c.down[podKey(pod)] = true
+	c.mutex.Unlock()
+	event := report.ChurnEvent{Node: node, Group: c.churn.OnGroup, Pod: pod.Metadata.Name}
+
+	if mode == "pod" {
+		event.Action = "deleted"
+		err = targetOf(pod.Metadata.Name).kubectl("delete", "pod", pod.Metadata.Name, "--grace-period=0", "--force", "--wait=false")
+		if err != nil {
+			event.Error = err.Error()
+		}
+		c.record(event)
+		return
+	}
+	event.Action = "killed"
+	out, _ := RunInPod(pod.Metadata.Name, killDaemonCmd, nil, 10)
+	killed := ""
+	if len(out) != 0 {
+		killed = strings.TrimSpace(out[len(out)-1])
+	}
+	if killed != "main" && killed != "killed" {
+		event.Error = fmt.Sprintf("could not kill the daemon: %s", strings.Join(out, "\n"))
+		c.record(event)
+		c.mutex.Lock()
+		delete(c.down, podKey(pod))
+		c.mutex.Unlock()
+		return
+	}
+	c.record(event)
+	c.afterDowntime(func() {
+		event := report.ChurnEvent{Node: node, Group: c.churn.OnGroup, Pod: pod.Metadata.Name, Action: "restarted"}
+		var err error
+		if killed == "main" {
+			// Kubernetes restarts the container on its own.
+			err = waitForDaemonUntil(pod.Metadata.Name, time.Now().Add(containerRestartTimeout))
+		} else {
+			err = restartDaemons(c.cfg, []Pod{pod})
+		}
+		if err == nil {
+			err = c.fleet.reconnect([]Pod{pod})
+		}
+		if err != nil {
+			event.Error = err.Error()
+		}
+		c.record(event)
+		c.mutex.Lock()
+		delete(c.down, podKey(pod))
+		c.mutex.Unlock()
+	})
+}
+
+// podKey tells a pod apart from the one replacing it.
+func podKey(pod Pod) string {
+	return pod.Metadata.Name + "/" + pod.Metadata.UID
+}
+
+// afterDowntime runs bringBack in the background once the downtime is over,
+// or as soon as churn stops.
+func (c *churner) afterDowntime(bringBack func()) {
+	downtime, _ := config.ParseWait(c.churn.Downtime)
+	c.done.Add(1)
+	go func() {
+		defer c.done.Done()
+		timer := time.NewTimer(downtime)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-c.stop:
+		case <-runContext.Done():
+			return
+		}
+		bringBack()
+	}()
+}
+
+// scale scales the workload of the churned nodes by a number of replicas,
+// and reports whether it did.
+func (c *churner) scale(by int) bool {
+	event := report.ChurnEvent{Group: c.churn.OnGroup, Action: "scaled_up"}
+	if by < 0 {
+		event.Action = "scaled_down"
+	}
+	c.scaling.Lock()
+	defer c.scaling.Unlock()
+	target := configTarget(c.cfg)
+	workload := c.cfg.Workload()
+	cmd := target.command(runContext, "get", workload, "-o", "jsonpath={.spec.replicas}")
+	var out, errout bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errout
+	err := cmd.Run()
+	if err != nil {
+		event.Error = fmt.Sprintf("could not get the replicas of %s: %s", workload, strings.TrimSpace(errout.String()))
+		c.record(event)
+		return false
+	}
+	replicas, err := strconv.Atoi(strings.TrimSpace(out.String()))
+	if err != nil {
+		event.Error = fmt.Sprintf("could not read the replicas of %s: %q", workload, out.String())
+		c.record(event)
+		return false
+	}
+	err = target.kubectl("scale", workload, "--replicas="+strconv.Itoa(replicas+by))
+	if err != nil {
+		event.Error = err.Error()
+	}
+	c.record(event)
+	return err == nil
+}
+
+// record adds an event to the timeline of the iteration.
+func (c *churner) record(event report.ChurnEvent) {
+	event.Time = time.Now()
+	what := c.cfg.Workload()
+	if event.Node != 0 {
+		what = fmt.Sprintf("node %d (%s)", event.Node, event.Pod)
+	}
+	if event.Error != "" {
+		color.Red("### Churn: %s %s failed: %s", event.Action, what, event.Error)
+	} else {
+		color.Yellow("### Churn: %s %s", event.Action, what)
+	}
+	c.writer.emit("churn", map[string]interface{}{
+		"iteration": c.iteration,
+		"node":      event.Node,
+		"pod":       event.Pod,
+		"action":    event.Action,
+		"error":     event.Error,
+	})
+	c.mutex.Lock()
+	c.events = append(c.events, event)
+	c.mutex.Unlock()
+}
+
+// finish stops churning, brings back the nodes still down and returns the
+// timeline of the iteration.
+func (c *churner) finish() []report.ChurnEvent {
+	close(c.stop)
+	c.done.Wait()
+	return c.events
+}
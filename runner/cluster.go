@@ -0,0 +1,100 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// ClusterStatus is the part of `ipfs-cluster-ctl --enc json status <cid>`
+// we look at.
+type ClusterStatus struct {
+	Cid     interface{} `json:"cid"`
+	PeerMap map[string]struct {
+		Status string `json:"status"`
+	} `json:"peer_map"`
+}
+
+// handleClusterStep runs the ipfs-cluster helpers of a step on the cluster
+// pods: pinning with `cluster_pin`, then checking with `assert_pinned_on` on
+// how many cluster peers the CID is pinned. The check is retried until the
+// step's timeout, since cluster pins complete asynchronously.
+func handleClusterStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	color.Blue("### Running cluster step %s on nodes %d to %d", step.Name, step.OnNode, step.EndNode)
+	cid := step.ClusterStatus
+	if cid == "" {
+		cid = step.ClusterPin
+	}
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		if j < 1 || j > len(pods.Items) {
+			color.Red("Cluster node %d does not exist, only %d cluster pods found", j, len(pods.Items))
+			summary.Failures++
+			result.Failures++
+			continue
+		}
+		name := pods.Items[j-1].Metadata.Name
+		nodeResult := &report.NodeResult{Node: j, Pod: name}
+		result.Nodes = append(result.Nodes, nodeResult)
+
+		if step.ClusterPin != "" {
+			pin := "ipfs-cluster-ctl pin add "
+			if step.Replication != 0 {
+				pin += "-r " + strconv.Itoa(step.Replication) + " "
+			}
+			pin += step.ClusterPin
+			color.Magenta("$ %s", pin)
+			out, timedOut := RunInPod(name, pin, env, step.Timeout)
+			nodeResult.Output = append(nodeResult.Output, out...)
+			if timedOut {
+				nodeResult.TimedOut = true
+				summary.Timeouts++
+				result.Timeouts++
+				continue
+			}
+		}
+
+		if step.AssertPinnedOn != 0 {
+			pinned, out := clusterPinnedOn(name, cid, env, step.Timeout, step.AssertPinnedOn)
+			nodeResult.Output = append(nodeResult.Output, out...)
+			assertion := report.AssertionResult{
+				Expected: fmt.Sprintf("pinned on %d", step.AssertPinnedOn),
+				Actual:   fmt.Sprintf("pinned on %d", pinned),
+				Passed:   pinned == step.AssertPinnedOn,
+			}
+			nodeResult.Assertions = append(nodeResult.Assertions, assertion)
+			recordAssertion(assertion, summary, result)
+		}
+	}
+	return env
+}
+
+// clusterPinnedOn returns on how many cluster peers cid is pinned, as seen
+// from the named pod. It polls until want is reached or timeout seconds have
+// passed, and returns the raw status output of the last poll.
+func clusterPinnedOn(name string, cid string, env []string, timeout int, want int) (int, []string) {
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	for {
+		out, _ := RunInPod(name, "ipfs-cluster-ctl --enc json status "+cid, env, 10)
+		pinned := 0
+		var status ClusterStatus
+		err := json.Unmarshal([]byte(strings.Join(out, "\n")), &status)
+		if err != nil {
+			color.Red("Could not parse cluster status: %s", err)
+		}
+		for _, peer := range status.PeerMap {
+			if peer.Status == "pinned" {
+				pinned++
+			}
+		}
+		if pinned == want || !time.Now().Before(deadline) {
+			return pinned, out
+		}
+		time.Sleep(time.Second)
+	}
+}
@@ -0,0 +1,121 @@
+package runner
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// DefaultsFile is read from the home directory when --config isn't given.
+const DefaultsFile = ".kubernetes-ipfs.yaml"
+
+// Defaults holds cluster specific settings shared by every test, so test
+// files don't have to repeat them. Whatever a test or a flag sets wins.
+type Defaults struct {
+	Namespace  string `yaml:"namespace"`
+	Selector   string `yaml:"selector"`
+	Deployment string `yaml:"deployment"`
+	Prometheus string `yaml:"prometheus"`
+	// Kind of the workload behind the selector, deployment, statefulset or daemonset
+	WorkloadKind string `yaml:"workload_kind"`
+	// Timeout of the steps of tests without a default_timeout
+	DefaultTimeout int `yaml:"default_timeout"`
+	// Directory relative report, event and output files are written to
+	ArtifactsDir string `yaml:"artifacts_dir"`
+	Report       string `yaml:"report"`
+	ReportFile   string `yaml:"report_file"`
+	Pushgateway  string `yaml:"pushgateway"`
+	Grafana      string `yaml:"grafana"`
+}
+
+// Namespace is the Kubernetes namespace every kubectl command runs in, the
+// current context's when empty.
+var Namespace string
+
+// LoadDefaults reads the defaults at path, or at ~/.kubernetes-ipfs.yaml
+// when path is empty, which may then be missing.
+func LoadDefaults(path string) (*Defaults, error) {
+	defaults := new(Defaults)
+	explicit := path != ""
+	if !explicit {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return defaults, nil
+		}
+		path = filepath.Join(home, DefaultsFile)
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return defaults, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.UnmarshalStrict(data, defaults)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return defaults, nil
+}
+
+// SetValues passes the default selector to templated tests, unless given
+// with --set.
+func (d *Defaults) SetValues(values config.SetValues) {
+	if _, ok := values["selector"]; !ok && d.Selector != "" {
+		values["selector"] = d.Selector
+	}
+}
+
+// ApplyTo fills in the test config settings the test leaves empty.
+func (d *Defaults) ApplyTo(cfg *config.Config) {
+	if cfg.Namespace == "" {
+		cfg.Namespace = d.Namespace
+	}
+	if cfg.Selector == "" && (cfg.Nodes != 0 || len(cfg.Groups) == 0) {
+		cfg.Selector = d.Selector
+	}
+	if cfg.Deployment == "" {
+		cfg.Deployment = d.Deployment
+	}
+	if cfg.WorkloadKind == "" {
+		cfg.WorkloadKind = d.WorkloadKind
+	}
+	if cfg.Prometheus == "" {
+		cfg.Prometheus = d.Prometheus
+	}
+	if cfg.DefaultTimeout == 0 {
+		cfg.DefaultTimeout = d.DefaultTimeout
+	}
+}
+
+// applyToRun fills in the run flags that weren't given, and moves relative
+// output files into the artifacts directory.
+func (d *Defaults) applyToRun(opts *Options) error {
+	if opts.ReportFormat == "" {
+		opts.ReportFormat, opts.ReportFile = d.Report, d.ReportFile
+	}
+	if opts.Pushgateway == "" {
+		opts.Pushgateway = d.Pushgateway
+	}
+	if opts.Grafana == "" {
+		opts.Grafana = d.Grafana
+	}
+	if d.ArtifactsDir == "" {
+		return nil
+	}
+	err := os.MkdirAll(d.ArtifactsDir, 0775)
+	if err != nil {
+		return err
+	}
+	for _, path := range []*string{&opts.ReportFile, &opts.HTMLReport, &opts.EventsOut, &opts.OutputDir} {
+		if *path != "" && *path != "-" && !filepath.IsAbs(*path) && !report.IsURL(*path) {
+			*path = filepath.Join(d.ArtifactsDir, *path)
+		}
+	}
+	return nil
+}
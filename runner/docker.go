@@ -0,0 +1,104 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+)
+
+// Containers of the docker backend, kubernetes-ipfs-0 being node 1, and the
+// network they share.
+const (
+	dockerPodPrefix = "kubernetes-ipfs-"
+	dockerNetwork   = "kubernetes-ipfs"
+	dockerImage     = "ipfs/go-ipfs:latest"
+)
+
+// dockerBackend runs the steps in local go-ipfs containers, with
+// --backend docker.
+type dockerBackend struct{}
+
+// docker runs a docker command and returns its output.
+func docker(args ...string) (string, error) {
+	cmd := exec.CommandContext(runContext, "docker", args...)
+	out := new(bytes.Buffer)
+	errout := new(bytes.Buffer)
+	cmd.Stdout = out
+	cmd.Stderr = errout
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("docker %s error: %s %s", args[0], err, errout.String())
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// Nodes starts a container for every node that has none running, from the
+// config's image and with its command and args, and waits for their
+// daemons. Containers are kept between runs.
+func (dockerBackend) Nodes(cfg *config.Config) (*GetPodsOutput, error) {
+	image := cfg.Image
+	if image == "" {
+		image = dockerImage
+	}
+	if _, err := docker("network", "inspect", dockerNetwork); err != nil {
+		_, err = docker("network", "create", dockerNetwork)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pods := new(GetPodsOutput)
+	for node := 0; node < cfg.Nodes; node++ {
+		name := dockerPodPrefix + strconv.Itoa(node)
+		running, err := docker("inspect", "--format", "{{.State.Running}}", name)
+		switch {
+		case err != nil:
+			color.Cyan("## Starting container %s from %s", name, image)
+			args := []string{"run", "--detach", "--name", name, "--network", dockerNetwork, "--label", "kubernetes-ipfs=node"}
+			if len(cfg.Command) != 0 {
+				args = append(args, "--entrypoint", cfg.Command[0], image)
+				args = append(args, cfg.Command[1:]...)
+			} else {
+				args = append(args, image)
+			}
+			_, err = docker(append(args, cfg.Args...)...)
+		case running != "true":
+			color.Cyan("## Starting container %s", name)
+			_, err = docker("start", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+		var pod Pod
+		pod.Metadata.Name = name
+		pod.Status.Phase = "Running"
+		pods.Items = append(pods.Items, pod)
+	}
+	for _, pod := range pods.Items {
+		err := waitForDaemon(pod.Metadata.Name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pods, nil
+}
+
+// Command runs the command in the container with docker exec.
+func (dockerBackend) Command(ctx context.Context, pod string, args []string) *exec.Cmd {
+	return exec.CommandContext(ctx, "docker", append([]string{"exec", pod}, execArgs(args)...)...)
+}
+
+// Restart restarts the container, whose main process is the daemon.
+func (dockerBackend) Restart(cfg *config.Config, pod Pod) (bool, error) {
+	_, err := docker("restart", pod.Metadata.Name)
+	return true, err
+}
+
+func (dockerBackend) Validate(test *config.Test) error {
+	return validateLocal(test, "docker")
+}
@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// bitswapStat is the part of ipfs bitswap stat a duplicate_blocks step reads.
+type bitswapStat struct {
+	BlocksReceived  uint64
+	DataReceived    uint64
+	DupBlksReceived uint64
+	DupDataReceived uint64
+}
+
+// bitswapStatCmd prints the bitswap stats of a node.
+const bitswapStatCmd = "ipfs bitswap stat --enc=json"
+
+// duplicateBaselines holds the bitswap stats of the pods at the start of the
+// iteration, by pod, which duplicate_blocks steps count from.
+var (
+	duplicateBaselines      = make(map[string]bitswapStat)
+	duplicateBaselinesMutex sync.Mutex
+)
+
+// hasDuplicateBlocks tells whether a test has duplicate_blocks steps.
+func hasDuplicateBlocks(test *config.Test) bool {
+	for _, step := range test.Steps {
+		if step.DuplicateBlocks != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// baselineDuplicates reads the bitswap stats of the fleet's pods, as the
+// daemons count them since they started rather than since the iteration.
+// A pod whose stats can't be read counts since its daemon started.
+func baselineDuplicates(fleet *Fleet) {
+	var wg sync.WaitGroup
+	for _, pod := range fleet.testPods() {
+		wg.Add(1)
+		go func(pod Pod) {
+			defer wg.Done()
+			out, timedOut := RunInPod(pod.Metadata.Name, bitswapStatCmd, nil, 30)
+			var stat bitswapStat
+			err := json.Unmarshal([]byte(strings.Join(out, "\n")), &stat)
+			duplicateBaselinesMutex.Lock()
+			defer duplicateBaselinesMutex.Unlock()
+			if timedOut || err != nil {
+				color.Red("Failed to read the bitswap stats of %s: %s", pod.Metadata.Name, mask(strings.Join(out, "\n")))
+				delete(duplicateBaselines, podKey(pod))
+				return
+			}
+			duplicateBaselines[podKey(pod)] = stat
+		}(pod)
+	}
+	wg.Wait()
+}
+
+// sinceBaseline returns the stats a pod gathered since the start of the
+// iteration. A daemon that restarted since counts from 0 again, so its stats
+// are taken as they are.
+func sinceBaseline(pod Pod, stat bitswapStat) bitswapStat {
+	duplicateBaselinesMutex.Lock()
+	base, ok := duplicateBaselines[podKey(pod)]
+	duplicateBaselinesMutex.Unlock()
+	if !ok || stat.BlocksReceived < base.BlocksReceived || stat.DupBlksReceived < base.DupBlksReceived ||
+		stat.DataReceived < base.DataReceived || stat.DupDataReceived < base.DupDataReceived {
+		return stat
+	}
+	return bitswapStat{
+		BlocksReceived:  stat.BlocksReceived - base.BlocksReceived,
+		DataReceived:    stat.DataReceived - base.DataReceived,
+		DupBlksReceived: stat.DupBlksReceived - base.DupBlksReceived,
+		DupDataReceived: stat.DupDataReceived - base.DupDataReceived,
+	}
+}
+
+// handleDuplicateBlocksStep reads the bitswap stats of every node of the
+// step, and computes the ratio of the blocks it received since the start of
+// the iteration that were duplicates. A node's output is its stats and ratio
+// as a line of JSON, for jq assertions, its ratio becomes a metric, and is
+// checked against the step's max_ratio when it has one.
+func handleDuplicateBlocksStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	color.Blue("### Computing the duplicate blocks ratio of nodes %d to %d", step.OnNode, step.EndNode)
+	color.Magenta("$ %s", bitswapStatCmd)
+
+	outputs := make(chan *report.NodeResult, step.EndNode-step.OnNode+1)
+	parallel := newLimiter(step.MaxParallel)
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		go func(node int, pod Pod) {
+			parallel.acquire()
+			defer parallel.release()
+			name := pod.Metadata.Name
+			command := podCommand(name, bitswapStatCmd, env, step.Timeout)
+			out, timedOut := runPodCommand(command)
+			nodeResult := &report.NodeResult{Node: node, Pod: name, TimedOut: timedOut, Retries: command.retries, Error: command.execErr}
+			if !timedOut && command.execErr == "" {
+				var stat bitswapStat
+				err := json.Unmarshal([]byte(strings.Join(out, "\n")), &stat)
+				if err != nil {
+					nodeResult.Error = "could not read ipfs bitswap stat: " + mask(strings.Join(out, "\n"))
+				} else {
+					nodeResult.Output, nodeResult.Fields = duplicateBlocksOutput(sinceBaseline(pod, stat))
+				}
+			}
+			outputs <- nodeResult
+		}(j, pods.Items[j-1])
+	}
+	iteration := summary.Iterations[len(summary.Iterations)-1]
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		nodeResult := <-outputs
+		if ratio, ok := nodeResult.Fields["ratio"]; ok {
+			summary.Metrics = append(summary.Metrics, report.Metric{Time: time.Now(), Node: nodeResult.Node, Pod: nodeResult.Pod,
+				Name: "duplicate_blocks_ratio", Value: duplicateRatio(nodeResult.Fields)})
+			color.Cyan("### Node %d received %s duplicate blocks out of %s, a ratio of %s", nodeResult.Node,
+				nodeResult.Fields["dup_blocks_received"], nodeResult.Fields["blocks_received"], ratio)
+		}
+		env = handleNodeResult(step, nodeResult, summary, result, iteration, env)
+	}
+	return env
+}
+
+// duplicateBlocksOutput is the output and the fields of a node of a
+// duplicate_blocks step.
+func duplicateBlocksOutput(stat bitswapStat) ([]string, map[string]string) {
+	ratio := 0.0
+	if stat.BlocksReceived != 0 {
+		ratio = float64(stat.DupBlksReceived) / float64(stat.BlocksReceived)
+	}
+	line, _ := json.Marshal(map[string]interface{}{
+		"blocks_received":     stat.BlocksReceived,
+		"data_received":       stat.DataReceived,
+		"dup_blocks_received": stat.DupBlksReceived,
+		"dup_data_received":   stat.DupDataReceived,
+		"ratio":               ratio,
+	})
+	fields := map[string]string{
+		"blocks_received":     strconv.FormatUint(stat.BlocksReceived, 10),
+		"data_received":       strconv.FormatUint(stat.DataReceived, 10),
+		"dup_blocks_received": strconv.FormatUint(stat.DupBlksReceived, 10),
+		"dup_data_received":   strconv.FormatUint(stat.DupDataReceived, 10),
+		"ratio":               strconv.FormatFloat(ratio, 'f', 4, 64),
+	}
+	return []string{string(line)}, fields
+}
+
+// duplicateRatio is the ratio of duplicate blocks of a node, from the counts
+// in its fields rather than the rounded ratio.
+func duplicateRatio(fields map[string]string) float64 {
+	blocks, _ := strconv.ParseUint(fields["blocks_received"], 10, 64)
+	duplicates, _ := strconv.ParseUint(fields["dup_blocks_received"], 10, 64)
+	if blocks == 0 {
+		return 0
+	}
+	return float64(duplicates) / float64(blocks)
+}
+
+// duplicateBlocksAssertion checks the ratio of duplicate blocks a node
+// received against the most the step allows.
+func duplicateBlocksAssertion(maxRatio float64, fields map[string]string) report.AssertionResult {
+	return report.AssertionResult{
+		Expected: fmt.Sprintf("duplicate blocks ratio <= %g", maxRatio),
+		Actual:   fmt.Sprintf("duplicate blocks ratio %s (%s of %s blocks)", fields["ratio"], fields["dup_blocks_received"], fields["blocks_received"]),
+		Passed:   duplicateRatio(fields) <= maxRatio,
+	}
+}
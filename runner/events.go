@@ -0,0 +1,82 @@
+package runner
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// eventWriter streams the progress of a run as newline-delimited JSON, one
+// object per event with its name in "event" and the time it happened in
+// "time". A nil writer drops events.
+type eventWriter struct {
+	mutex   sync.Mutex
+	file    *os.File
+	encoder *json.Encoder
+}
+
+func newEventWriter(path string) (*eventWriter, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &eventWriter{file: file, encoder: json.NewEncoder(file)}, nil
+}
+
+func (w *eventWriter) emit(event string, fields map[string]interface{}) {
+	if w == nil {
+		return
+	}
+	fields["event"] = event
+	fields["time"] = time.Now()
+	// Iterations running in parallel emit at the same time.
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.encoder.Encode(fields)
+}
+
+// stepFinished emits the output and assertion results of every node of a
+// step, then the outcome of the step itself.
+func (w *eventWriter) stepFinished(iteration int, result *report.StepResult) {
+	for _, node := range result.Nodes {
+		w.emit("node_output", map[string]interface{}{
+			"iteration": iteration,
+			"step":      result.Index,
+			"node":      node.Node,
+			"pod":       node.Pod,
+			"output":    maskLines(node.Output),
+			"stderr":    maskLines(node.Stderr),
+			"timed_out": node.TimedOut,
+		})
+		for _, assertion := range node.Assertions {
+			w.emit("assertion_result", map[string]interface{}{
+				"iteration": iteration,
+				"step":      result.Index,
+				"node":      node.Node,
+				"expected":  mask(assertion.Expected),
+				"actual":    mask(assertion.Actual),
+				"passed":    assertion.Passed,
+			})
+		}
+	}
+	w.emit("step_finished", map[string]interface{}{
+		"iteration": iteration,
+		"step":      result.Index,
+		"name":      result.Name,
+		"successes": result.Successes,
+		"failures":  result.Failures,
+		"timeouts":  result.Timeouts,
+		"errors":    result.Errors,
+		"duration":  result.End.Sub(result.Start).Seconds(),
+	})
+}
+
+func (w *eventWriter) Close() error {
+	if w == nil {
+		return nil
+	}
+	return w.file.Close()
+}
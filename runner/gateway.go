@@ -0,0 +1,162 @@
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// gatewayPort is the port of the go-ipfs HTTP gateway inside the pods.
+const gatewayPort = 8080
+
+// forwardingRegexp matches the line kubectl port-forward prints once it
+// listens, with the local port.
+var forwardingRegexp = regexp.MustCompile(`Forwarding from 127\.0\.0\.1:(\d+)`)
+
+// runGatewayAsync fetches the content of a gateway step from a node and
+// hands the result to the channel, like runInPodAsync does for commands.
+func runGatewayAsync(node int, pod Pod, step *config.Step, env []string, results chan *report.NodeResult) {
+	go func() {
+		result := &report.NodeResult{Node: node, Pod: pod.Metadata.Name}
+		defer func() {
+			results <- result
+		}()
+		gateway := step.Gateway
+		base := config.ExpandEnv(gateway.URL, env)
+		if base == "" && hasAPI(pod) {
+			base = fmt.Sprintf("http://%s:%d", pod.Status.PodIP, gatewayPort)
+		} else if base == "" {
+			// Out of the cluster, the gateway is only reachable through
+			// kubectl.
+			address, stop, err := portForward(pod.Metadata.Name, gatewayPort)
+			if err != nil {
+				result.Error = err.Error()
+				return
+			}
+			defer stop()
+			base = "http://" + address
+		}
+		path := gateway.Path
+		if path == "" {
+			path = "/ipfs/" + gateway.CID
+		}
+		path = config.ExpandEnv(config.ForNode(path, node), env)
+
+		req, err := http.NewRequestWithContext(runContext, http.MethodGet, strings.TrimSuffix(base, "/")+path, nil)
+		if err != nil {
+			result.Error = err.Error()
+			return
+		}
+		client := http.Client{Timeout: time.Duration(step.Timeout) * time.Second}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				result.TimedOut = true
+			} else {
+				result.Error = err.Error()
+			}
+			return
+		}
+		defer resp.Body.Close()
+		ttfb := time.Since(start).Seconds()
+		hash := sha256.New()
+		size, err := io.Copy(hash, resp.Body)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				result.TimedOut = true
+			} else {
+				result.Error = err.Error()
+			}
+			return
+		}
+		seconds := time.Since(start).Seconds()
+		sum := hex.EncodeToString(hash.Sum(nil))
+		line, _ := json.Marshal(map[string]interface{}{"status": resp.StatusCode, "bytes": size, "sha256": sum, "ttfb": ttfb, "seconds": seconds})
+		result.Output = []string{string(line)}
+		result.Fields = map[string]string{
+			"status":  strconv.Itoa(resp.StatusCode),
+			"size":    strconv.FormatInt(size, 10),
+			"sha256":  sum,
+			"ttfb":    strconv.FormatFloat(ttfb, 'f', 3, 64),
+			"seconds": strconv.FormatFloat(seconds, 'f', 3, 64),
+		}
+	}()
+}
+
+// gatewayAssertions checks the response a node got from a gateway against
+// what the step expects of it.
+func gatewayAssertions(gateway *config.Gateway, node int, fields map[string]string, env []string) []report.AssertionResult {
+	status := gateway.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	assertions := []report.AssertionResult{{
+		Expected: fmt.Sprintf("status %d", status),
+		Actual:   "status " + fields["status"],
+		Passed:   fields["status"] == strconv.Itoa(status),
+	}}
+	if gateway.SHA256 != "" {
+		expected := config.ExpandEnv(config.ForNode(gateway.SHA256, node), env)
+		assertions = append(assertions, report.AssertionResult{
+			Expected: "sha256 " + expected,
+			Actual:   "sha256 " + fields["sha256"],
+			Passed:   strings.EqualFold(fields["sha256"], expected),
+		})
+	}
+	if gateway.MaxLatency != "" {
+		// validateTest made sure it parses.
+		max, _ := config.ParseWait(gateway.MaxLatency)
+		seconds, _ := strconv.ParseFloat(fields["seconds"], 64)
+		assertions = append(assertions, report.AssertionResult{
+			Expected: "fetched within " + max.String(),
+			Actual:   "fetched in " + fields["seconds"] + "s",
+			Passed:   seconds <= max.Seconds(),
+		})
+	}
+	return assertions
+}
+
+// portForward forwards a local port to a port of a pod, until stop is called,
+// and returns the local address.
+func portForward(pod string, port int) (string, func(), error) {
+	cmd := targetOf(pod).command(runContext, "port-forward", pod, ":"+strconv.Itoa(port))
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", nil, err
+	}
+	var errout bytes.Buffer
+	cmd.Stderr = &errout
+	err = cmd.Start()
+	if err != nil {
+		return "", nil, err
+	}
+	stop := func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	}
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		if found := forwardingRegexp.FindStringSubmatch(scanner.Text()); found != nil {
+			// Keep reading what kubectl prints for every connection.
+			go io.Copy(ioutil.Discard, stdout)
+			return "127.0.0.1:" + found[1], stop, nil
+		}
+	}
+	stop()
+	return "", nil, fmt.Errorf("kubectl port-forward to %s failed: %s", pod, strings.TrimSpace(errout.String()))
+}
@@ -0,0 +1,55 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// grafanaAnnotation is the body of a POST to Grafana's /api/annotations.
+// Times are in milliseconds; with TimeEnd it marks a region.
+type grafanaAnnotation struct {
+	Time    int64    `json:"time"`
+	TimeEnd int64    `json:"timeEnd,omitempty"`
+	Tags    []string `json:"tags"`
+	Text    string   `json:"text"`
+}
+
+// annotate marks the time from start to end on the Grafana dashboards, so
+// resource graphs can be read against the phases of a test. An API key in
+// GRAFANA_API_KEY is used if set; the default setup allows anonymous access.
+func annotate(grafana string, start time.Time, end time.Time, text string, tags ...string) error {
+	body, err := json.Marshal(grafanaAnnotation{
+		Time:    start.UnixNano() / int64(time.Millisecond),
+		TimeEnd: end.UnixNano() / int64(time.Millisecond),
+		Tags:    append([]string{"kubernetes-ipfs"}, tags...),
+		Text:    text,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, strings.TrimSuffix(grafana, "/")+"/api/annotations", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if key := os.Getenv("GRAFANA_API_KEY"); key != "" {
+		req.Header.Set("Authorization", "Bearer "+key)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		out, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("grafana replied %s: %s", resp.Status, out)
+	}
+	return nil
+}
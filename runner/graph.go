@@ -0,0 +1,253 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// hasDependencies tells whether the steps form a graph with depends_on
+// rather than running one after the other.
+func hasDependencies(steps []config.Step) bool {
+	for _, step := range steps {
+		if len(step.DependsOn) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// stepDependencies returns the indexes of the steps every step waits for: the
+// steps above it named in its depends_on, and those saving the variables it
+// uses.
+func stepDependencies(steps []config.Step) [][]int {
+	dependencies := make([][]int, len(steps))
+	for index, step := range steps {
+		uses := usedVariables(step)
+		for earlier := 0; earlier < index; earlier++ {
+			depends := false
+			for _, name := range step.DependsOn {
+				depends = depends || steps[earlier].Name == name
+			}
+			for _, saved := range savedVariables(steps[earlier]) {
+				for _, used := range uses {
+					// Variables are also saved per node, as NAME_<node>.
+					depends = depends || used == saved || strings.HasPrefix(used, saved+"_")
+				}
+			}
+			if depends {
+				dependencies[index] = append(dependencies[index], earlier)
+			}
+		}
+	}
+	return dependencies
+}
+
+// savedVariables returns the variables a step saves.
+func savedVariables(step config.Step) []string {
+	var saved []string
+	for _, output := range step.Outputs {
+		saved = append(saved, output.SaveTo)
+	}
+	for _, name := range step.Save {
+		saved = append(saved, name)
+	}
+	if step.SaveAllTo != "" {
+		saved = append(saved, step.SaveAllTo)
+	}
+	return saved
+}
+
+// whenVariableRegexp matches the variables a when reads, as .vars.NAME.
+var whenVariableRegexp = regexp.MustCompile(`\.vars\.(\w+)`)
+
+// usedVariables returns the variables a step uses: its inputs, the one it
+// feeds on stdin, those its command, args, gateway and replicas expand, those
+// its assertions compare to and those its when reads.
+func usedVariables(step config.Step) []string {
+	used := append([]string(nil), step.Inputs...)
+	if step.StdinFrom != "" {
+		used = append(used, step.StdinFrom)
+	}
+	expand := func(s string) {
+		os.Expand(s, func(name string) string {
+			used = append(used, name)
+			return ""
+		})
+	}
+	expand(step.CMD)
+	for _, arg := range step.Args {
+		expand(arg)
+	}
+	if gateway := step.Gateway; gateway != nil {
+		expand(gateway.CID)
+		expand(gateway.Path)
+		expand(gateway.SHA256)
+	}
+	if step.Replicas != nil {
+		expand(step.Replicas.CID)
+	}
+	// Assertions name the variable they compare to, and fall back to the
+	// name itself as a literal when it isn't saved.
+	for _, assertion := range step.Assertions {
+		for _, value := range []string{assertion.ShouldBeEqualTo, assertion.ShouldNotBeEqualTo, assertion.ShouldContain, assertion.ShouldNotContain} {
+			if value != "" {
+				used = append(used, value)
+			}
+		}
+		if on := assertion.ShouldBeEqualToVarOnNode; on != nil {
+			used = append(used, on.Var)
+		}
+	}
+	for _, found := range whenVariableRegexp.FindAllStringSubmatch(step.When, -1) {
+		used = append(used, found[1])
+	}
+	return used
+}
+
+// exclusiveStep tells whether a step changes the nodes of the fleet or their
+// network, which steps running next to it would see half done, so it runs
+// alone.
+func exclusiveStep(step *config.Step) bool {
+	return step.KillNode != "" || step.WaitForReschedule || step.ResetRepo != "" || step.Shape != nil || step.ShapeReset ||
+		step.Partition != nil || step.Heal != "" || step.Stress != nil
+}
+
+// validateGraph checks that depends_on only names steps above, which keeps
+// the graph free of cycles, and that the steps whose output a step reads are
+// sure to have finished before it starts.
+func validateGraph(test *config.Test) error {
+	if !hasDependencies(test.Steps) {
+		return nil
+	}
+	if test.Config.WaitForReplacement != "" {
+		return fmt.Errorf("wait_for_replacement can't be used with depends_on, pods would be replaced under running steps")
+	}
+	dependencies := stepDependencies(test.Steps)
+	above := make(map[string]bool)
+	// Names of the steps every step waits for, directly or not
+	ancestors := make([]map[string]bool, len(test.Steps))
+	for index, step := range test.Steps {
+		ancestors[index] = make(map[string]bool)
+		for _, name := range step.DependsOn {
+			if !above[name] {
+				return fmt.Errorf("step %s depends on step %s, which isn't above it", step.Name, name)
+			}
+		}
+		for _, earlier := range dependencies[index] {
+			ancestors[index][test.Steps[earlier].Name] = true
+			for name := range ancestors[earlier] {
+				ancestors[index][name] = true
+			}
+		}
+		reads := []string{step.StdinFromStep}
+		for _, output := range step.Outputs {
+			reads = append(reads, output.FromStep)
+		}
+		for _, assertion := range step.Assertions {
+			if from := assertion.ShouldBeEqualToStep; from != nil {
+				reads = append(reads, from.FromStep)
+			}
+		}
+		for _, name := range reads {
+			if name != "" && !ancestors[index][name] {
+				return fmt.Errorf("step %s reads the output of step %s, so it must depend on it", step.Name, name)
+			}
+		}
+		above[step.Name] = true
+	}
+	return nil
+}
+
+// finishedStep is a step of the graph that finished, with the summary it
+// counted into and the variables it started and ended with.
+type finishedStep struct {
+	index  int
+	own    *report.Summary
+	before []string
+	after  []string
+}
+
+// runGraph runs the steps of an iteration as soon as the steps they depend
+// on finished, whatever their outcome, so that independent steps run at
+// once. Exclusive steps, and every step with --step, run alone. Every step
+// starts with the variables saved by the steps finished before it, and the
+// ones it saves are added to them as it finishes.
+func runGraph(test *config.Test, opts *Options, fleet *Fleet, nodes int, summary *report.Summary, env []string, events *eventWriter, abort *runAbort) []string {
+	iteration := summary.Iterations[0]
+	dependencies := stepDependencies(test.Steps)
+	started := make([]bool, len(test.Steps))
+	finished := make([]bool, len(test.Steps))
+	done := make(chan finishedStep)
+	running := 0
+	// alone is set while a step runs alone.
+	alone := false
+	for {
+		for index, step := range test.Steps {
+			if started[index] || !allFinished(dependencies[index], finished) || runContext.Err() != nil || abort.get() != "" {
+				continue
+			}
+			exclusive := opts.stepper != nil || exclusiveStep(&step)
+			if alone || exclusive && running != 0 {
+				continue
+			}
+			alone = exclusive
+			started[index] = true
+			running++
+			// The step counts into a summary of its own, whose iteration
+			// holds the steps finished so far, so steps running at once
+			// don't count into each other.
+			view := *iteration
+			view.Steps = append([]*report.StepResult(nil), iteration.Steps...)
+			own := &report.Summary{Name: summary.Name, Iterations: []*report.IterationResult{&view}}
+			before := append([]string(nil), env...)
+			go func(index int, step config.Step) {
+				after := runIterationStep(test, opts, fleet, nodes, own, index, step, append([]string(nil), before...), events, abort)
+				done <- finishedStep{index: index, own: own, before: before, after: after}
+			}(index, step)
+		}
+		if running == 0 {
+			break
+		}
+		step := <-done
+		running--
+		alone = false
+		finished[step.index] = true
+		steps := step.own.Iterations[0].Steps
+		iteration.Steps = append(iteration.Steps, steps[len(steps)-1])
+		addCounts(summary, step.own)
+		env = config.MergeVariables(env, changedVariables(step.before, step.after))
+	}
+	sort.SliceStable(iteration.Steps, func(a, b int) bool { return iteration.Steps[a].Index < iteration.Steps[b].Index })
+	return env
+}
+
+func allFinished(indexes []int, finished []bool) bool {
+	for _, index := range indexes {
+		if !finished[index] {
+			return false
+		}
+	}
+	return true
+}
+
+// changedVariables returns the variables of after that were not in before
+// with the same value.
+func changedVariables(before []string, after []string) []string {
+	known := make(map[string]bool)
+	for _, e := range before {
+		known[e] = true
+	}
+	var changed []string
+	for _, e := range after {
+		if !known[e] {
+			changed = append(changed, e)
+		}
+	}
+	return changed
+}
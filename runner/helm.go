@@ -0,0 +1,84 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// installedReleases are the releases to uninstall when the run is over.
+var installedReleases []string
+
+// installReleases installs or upgrades the releases and waits for their
+// resources to be ready.
+func installReleases(releases []config.HelmRelease) error {
+	for _, release := range releases {
+		color.Cyan("## Installing %s as %s", release.Chart, release.Release)
+		args := []string{"upgrade", "--install", release.Release, release.Chart, "--wait"}
+		if release.Version != "" {
+			args = append(args, "--version", release.Version)
+		}
+		for _, file := range release.ValuesFiles {
+			args = append(args, "-f", file)
+		}
+		if len(release.Values) != 0 {
+			values, err := yaml.Marshal(release.Values)
+			if err != nil {
+				return err
+			}
+			file, err := ioutil.TempFile("", "kubernetes-ipfs-values-*.yaml")
+			if err != nil {
+				return err
+			}
+			defer os.Remove(file.Name())
+			_, err = file.Write(values)
+			file.Close()
+			if err != nil {
+				return err
+			}
+			args = append(args, "-f", file.Name())
+		}
+		err := helm(args...)
+		if err != nil {
+			return fmt.Errorf("installing %s failed: %s", release.Release, err)
+		}
+		if release.Uninstall {
+			installedReleases = append(installedReleases, release.Release)
+		}
+	}
+	return nil
+}
+
+// uninstallReleases uninstalls the releases installed with uninstall.
+func uninstallReleases() {
+	for _, release := range installedReleases {
+		color.Cyan("## Uninstalling %s", release)
+		err := helm("uninstall", release)
+		if err != nil {
+			color.Red("Failed to uninstall %s: %s", release, err)
+		}
+	}
+	installedReleases = nil
+}
+
+// helm runs a helm command in the test namespace, returning its stderr as
+// the error.
+func helm(args ...string) error {
+	if Namespace != "" {
+		args = append([]string{"--namespace=" + Namespace}, args...)
+	}
+	cmd := exec.CommandContext(runContext, "helm", args...)
+	errbuf := new(bytes.Buffer)
+	cmd.Stderr = errbuf
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s %s", err, errbuf.String())
+	}
+	return nil
+}
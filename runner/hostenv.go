@@ -0,0 +1,24 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+)
+
+// hostEnv returns a step environment holding the host variables.
+func hostEnv(variables []config.HostVariable) ([]string, error) {
+	var env []string
+	for _, variable := range variables {
+		value, ok := os.LookupEnv(variable.Name)
+		if !ok {
+			return nil, fmt.Errorf("env_from_host: %s is not set", variable.Name)
+		}
+		if variable.Secret {
+			addSecret(value)
+		}
+		env = config.SetVariable(env, variable.Name, value)
+	}
+	return env, nil
+}
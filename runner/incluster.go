@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serviceAccountDir holds the credentials Kubernetes mounts into pods.
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// JobResultsMarker separates the output of a test run as a Job from its JSON
+// report in the Job's logs.
+const JobResultsMarker = "=== kubernetes-ipfs results ==="
+
+// InCluster is set with --in-cluster, when the runner runs in a pod.
+var InCluster bool
+
+// UseServiceAccount points kubectl and helm at a kubeconfig made from the
+// pod's service account, for runs without a kubeconfig of their own. The
+// token is read from its file by kubectl, so rotated tokens keep working.
+func UseServiceAccount() error {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return fmt.Errorf("--in-cluster needs to run in a pod, KUBERNETES_SERVICE_HOST isn't set")
+	}
+	ns, err := ioutil.ReadFile(filepath.Join(serviceAccountDir, "namespace"))
+	if err != nil {
+		return fmt.Errorf("reading the service account failed: %s", err)
+	}
+	config := map[string]interface{}{
+		"apiVersion":      "v1",
+		"kind":            "Config",
+		"current-context": "in-cluster",
+		"clusters": []interface{}{map[string]interface{}{
+			"name": "in-cluster",
+			"cluster": map[string]string{
+				"server":                "https://" + net.JoinHostPort(host, port),
+				"certificate-authority": filepath.Join(serviceAccountDir, "ca.crt"),
+			},
+		}},
+		"users": []interface{}{map[string]interface{}{
+			"name": "in-cluster",
+			"user": map[string]string{"tokenFile": filepath.Join(serviceAccountDir, "token")},
+		}},
+		"contexts": []interface{}{map[string]interface{}{
+			"name": "in-cluster",
+			"context": map[string]string{
+				"cluster":   "in-cluster",
+				"user":      "in-cluster",
+				"namespace": strings.TrimSpace(string(ns)),
+			},
+		}},
+	}
+	// kubeconfig files are YAML, of which JSON is a subset.
+	data, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile("", "kubernetes-ipfs-kubeconfig")
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(data)
+	if err != nil {
+		return err
+	}
+	return os.Setenv("KUBECONFIG", f.Name())
+}
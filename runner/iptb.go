@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+)
+
+// iptbPodPrefix names the nodes of the iptb testbed as pods, iptb-0 being
+// node 1.
+const iptbPodPrefix = "iptb-"
+
+// iptbBackend runs the steps on the nodes of a local iptb testbed, with
+// --backend iptb.
+type iptbBackend struct{}
+
+// iptbTestbed returns the directory of the default testbed, below
+// $IPTB_ROOT or ~/testbed. Every node's IPFS_PATH is a directory in it.
+func iptbTestbed() string {
+	root := os.Getenv("IPTB_ROOT")
+	if root == "" {
+		home, _ := os.UserHomeDir()
+		root = filepath.Join(home, "testbed")
+	}
+	return filepath.Join(root, "testbeds", "default")
+}
+
+// iptb runs an iptb command with extra environment variables.
+func iptb(env []string, args ...string) error {
+	cmd := exec.CommandContext(runContext, "iptb", args...)
+	cmd.Env = append(os.Environ(), env...)
+	out := new(bytes.Buffer)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("iptb %s error: %s %s", strings.Join(args, " "), err, out.String())
+	}
+	return nil
+}
+
+// Nodes makes sure the testbed has enough nodes, starts the ones not running
+// and connects them to each other: init, start and connect as iptb does
+// them.
+func (iptbBackend) Nodes(cfg *config.Config) (*GetPodsOutput, error) {
+	count := cfg.Nodes
+	dir := iptbTestbed()
+	existing := 0
+	for {
+		if _, err := os.Stat(filepath.Join(dir, strconv.Itoa(existing))); err != nil {
+			break
+		}
+		existing++
+	}
+	if existing < count {
+		color.Cyan("## Creating an iptb testbed of %d nodes in %s", count, dir)
+		err := iptb(nil, "auto", "--type", "localipfs", "--count", strconv.Itoa(count), "--force")
+		if err != nil {
+			return nil, err
+		}
+	}
+	// A running daemon leaves its API address in its repo.
+	var stopped []string
+	for node := 0; node < count; node++ {
+		if _, err := os.Stat(filepath.Join(dir, strconv.Itoa(node), "api")); err != nil {
+			stopped = append(stopped, strconv.Itoa(node))
+		}
+	}
+	if len(stopped) != 0 {
+		color.Cyan("## Starting iptb nodes %s", strings.Join(stopped, ", "))
+		err := iptb(nil, "start", "--wait", "["+strings.Join(stopped, ",")+"]")
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := iptb(nil, "connect")
+	if err != nil {
+		return nil, err
+	}
+	pods := new(GetPodsOutput)
+	for node := 0; node < count; node++ {
+		var pod Pod
+		pod.Metadata.Name = iptbPodPrefix + strconv.Itoa(node)
+		pod.Status.Phase = "Running"
+		pods.Items = append(pods.Items, pod)
+	}
+	return pods, nil
+}
+
+// Command runs the command on the machine, with the IPFS_PATH of the node.
+func (iptbBackend) Command(ctx context.Context, pod string, args []string) *exec.Cmd {
+	command := execArgs(args)
+	node := strings.TrimPrefix(pod, iptbPodPrefix)
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Env = append(os.Environ(), "IPFS_PATH="+filepath.Join(iptbTestbed(), node))
+	ownProcessGroup(cmd)
+	return cmd
+}
+
+func (iptbBackend) Restart(cfg *config.Config, pod Pod) (bool, error) {
+	var env []string
+	if cfg.PrivateNetwork {
+		env = append(env, "LIBP2P_FORCE_PNET=1")
+	}
+	return true, iptb(env, "restart", strings.TrimPrefix(pod.Metadata.Name, iptbPodPrefix))
+}
+
+// Validate rejects the provisioning of a container as well, as iptb runs
+// the ipfs on the PATH.
+func (iptbBackend) Validate(test *config.Test) error {
+	cfg := &test.Config
+	if cfg.Image != "" || len(cfg.Command) != 0 || len(cfg.Args) != 0 {
+		return fmt.Errorf("the iptb backend runs the ipfs on the PATH, not an image")
+	}
+	return validateLocal(test, "iptb")
+}
@@ -0,0 +1,14 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// ownProcessGroup starts the command in a process group of its own, which
+// stopping it on timeout kills along with its children.
+func ownProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
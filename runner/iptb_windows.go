@@ -0,0 +1,7 @@
+package runner
+
+import "os/exec"
+
+// ownProcessGroup leaves the command in the runner's process group, as
+// Windows has none to give it.
+func ownProcessGroup(cmd *exec.Cmd) {}
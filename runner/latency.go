@@ -0,0 +1,122 @@
+package runner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// latencyLookupInterval is the time between two lookups of a node, as sleep
+// takes it in the pods.
+const latencyLookupInterval = "0.2"
+
+// providedContent is what the provider of a latency step made available.
+type providedContent struct {
+	cid    string
+	peerID string
+}
+
+// handleLatencyStep has the provider of a latency step add its content, then
+// every other node of the step look it up at once. The lookups loop inside
+// the pods, so that kubectl only adds to a measure the time it takes to hand
+// back the result. A node's latency runs from the moment the provider was
+// done to the moment its lookup succeeded, and becomes its output and its
+// latency field, in seconds, and a metric.
+func handleLatencyStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	latency := step.Latency
+	lookup := latency.Lookup()
+	color.Blue("### Measuring %s latency from node %d to nodes %d to %d", lookup, latency.Provider, step.OnNode, step.EndNode)
+	if latency.Provider < 1 || latency.Provider > len(pods.Items) {
+		color.Red("Provider %d does not exist, only %d nodes found", latency.Provider, len(pods.Items))
+		summary.Failures++
+		result.Failures++
+		return env
+	}
+	provider := pods.Items[latency.Provider-1].Metadata.Name
+	content := config.ExpandEnv(latency.Content, env)
+	if content == "" {
+		content = fmt.Sprintf("kubernetes-ipfs %s %s %d", step.Name, config.LookupVariable("SEED", env), time.Now().UnixNano())
+	}
+	cmd := "cid=$(printf '%s' " + ShellQuote(content) + " | ipfs add -Q) && "
+	if lookup == "resolve" {
+		cmd += "ipfs name publish /ipfs/$cid > /dev/null && "
+	}
+	cmd += "echo $cid && ipfs id -f '<id>\\n'"
+	color.Magenta("$ %s", cmd)
+	out, timedOut := RunInPod(provider, cmd, env, step.Timeout)
+	if timedOut || len(out) != 2 {
+		color.Red("Node %d couldn't provide the content of step %s: %s", latency.Provider, step.Name, mask(strings.Join(out, "\n")))
+		summary.Failures++
+		result.Failures++
+		return env
+	}
+	provided := providedContent{cid: strings.TrimSpace(out[0]), peerID: strings.TrimSpace(out[1])}
+	color.Magenta("### Node %d provided %s", latency.Provider, provided.cid)
+	start := time.Now()
+
+	var nodes []int
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		if j != latency.Provider {
+			nodes = append(nodes, j)
+		}
+	}
+	outputs := make(chan *report.NodeResult, len(nodes))
+	for _, j := range nodes {
+		runLookupAsync(j, pods.Items[j-1], step, provided, start, env, outputs)
+	}
+	iteration := summary.Iterations[len(summary.Iterations)-1]
+	var latencies []float64
+	for range nodes {
+		nodeResult := <-outputs
+		if seconds, ok := nodeResult.Fields["latency"]; ok && !nodeResult.TimedOut && nodeResult.Error == "" {
+			value, _ := strconv.ParseFloat(seconds, 64)
+			latencies = append(latencies, value)
+			summary.Metrics = append(summary.Metrics, report.Metric{Time: time.Now(), Node: nodeResult.Node, Pod: nodeResult.Pod,
+				Name: lookup + "_latency_seconds", Value: value})
+		}
+		env = handleNodeResult(step, nodeResult, summary, result, iteration, env)
+	}
+	if len(latencies) != 0 {
+		min, max, sum := latencies[0], latencies[0], 0.0
+		for _, value := range latencies {
+			if value < min {
+				min = value
+			}
+			if value > max {
+				max = value
+			}
+			sum += value
+		}
+		color.Cyan("### %d of %d nodes found %s, in %.3fs to %.3fs, %.3fs on average", len(latencies), len(nodes), provided.cid, min, max, sum/float64(len(latencies)))
+	}
+	return env
+}
+
+// runLookupAsync looks up the content of a latency step on a node until it
+// succeeds, and hands the result to the channel, like runInPodAsync does for
+// commands.
+func runLookupAsync(node int, pod Pod, step *config.Step, content providedContent, start time.Time, env []string, results chan *report.NodeResult) {
+	go func() {
+		var found string
+		if step.Latency.Lookup() == "resolve" {
+			found = "[ \"$(ipfs name resolve --nocache /ipns/" + content.peerID + " 2> /dev/null)\" = /ipfs/" + content.cid + " ]"
+		} else {
+			found = "ipfs dht findprovs " + content.cid + " 2> /dev/null | grep -qx " + content.peerID
+		}
+		cmd := "n=1; until " + found + "; do n=$((n+1)); sleep " + latencyLookupInterval + "; done; echo $n"
+		command := podCommand(pod.Metadata.Name, cmd, env, step.Timeout)
+		out, timedOut := runPodCommand(command)
+		result := &report.NodeResult{Node: node, Pod: pod.Metadata.Name, TimedOut: timedOut, Retries: command.retries, Error: command.execErr}
+		if !timedOut && command.execErr == "" && len(out) != 0 {
+			seconds := strconv.FormatFloat(time.Since(start).Seconds(), 'f', 3, 64)
+			result.Output = []string{seconds}
+			result.Fields = map[string]string{"latency": seconds, "lookups": strings.TrimSpace(out[len(out)-1]), "cid": content.cid}
+		}
+		results <- result
+	}()
+}
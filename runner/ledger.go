@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// handleLedgerStep collects the bitswap ledger of every node of the step with
+// every other one, with one kubectl exec per node, and prints the bytes they
+// sent each other. A node's output is its ledgers as a line of JSON, keyed by
+// peer node, for jq assertions, e.g. `.["2"].received > 0`.
+func handleLedgerStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	color.Blue("### Collecting the bitswap ledgers between nodes %d to %d", step.OnNode, step.EndNode)
+	ids := make(map[int]string)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		wg.Add(1)
+		go func(node int) {
+			defer wg.Done()
+			id, err := peerID(pods.Items[node-1].Metadata.Name)
+			if err != nil {
+				color.Red("Node %d: %s", node, err)
+				return
+			}
+			mutex.Lock()
+			ids[node] = id
+			mutex.Unlock()
+		}(j)
+	}
+	wg.Wait()
+
+	outputs := make(chan *report.NodeResult, step.EndNode-step.OnNode+1)
+	ledgers := make(chan []report.Ledger, step.EndNode-step.OnNode+1)
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		go func(node int, name string) {
+			var peers []int
+			cmd := "true"
+			for peer := step.OnNode; peer <= step.EndNode; peer++ {
+				if peer != node && ids[peer] != "" {
+					peers = append(peers, peer)
+					cmd += "; ipfs bitswap ledger --enc=json " + ids[peer]
+				}
+			}
+			command := podCommand(name, cmd, env, step.Timeout)
+			out, timedOut := runPodCommand(command)
+			nodeResult := &report.NodeResult{Node: node, Pod: name, TimedOut: timedOut, Retries: command.retries, Error: command.execErr}
+			own, err := parseLedgers(node, peers, out)
+			if err != nil && !timedOut && command.execErr == "" {
+				nodeResult.Error = err.Error()
+			}
+			if nodeResult.Error == "" && !timedOut {
+				nodeResult.Output = []string{ledgersJSON(own)}
+			}
+			ledgers <- own
+			outputs <- nodeResult
+		}(j, pods.Items[j-1].Metadata.Name)
+	}
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		result.Ledgers = append(result.Ledgers, <-ledgers...)
+		env = handleNodeResult(step, <-outputs, summary, result, summary.Iterations[len(summary.Iterations)-1], env)
+	}
+	printLedgers(result.Ledgers)
+	return env
+}
+
+// parseLedgers reads the ledgers a node printed, one per peer in order.
+func parseLedgers(node int, peers []int, out []string) ([]report.Ledger, error) {
+	var ledgers []report.Ledger
+	for _, line := range out {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if len(ledgers) == len(peers) {
+			return ledgers, fmt.Errorf("unexpected output of ipfs bitswap ledger: %s", line)
+		}
+		var ledger struct{ Sent, Recv, Exchanged uint64 }
+		err := json.Unmarshal([]byte(line), &ledger)
+		if err != nil {
+			return ledgers, fmt.Errorf("could not read the bitswap ledger of node %d: %s", peers[len(ledgers)], line)
+		}
+		ledgers = append(ledgers, report.Ledger{Node: node, Peer: peers[len(ledgers)], Sent: ledger.Sent, Received: ledger.Recv, Exchanged: ledger.Exchanged})
+	}
+	if len(ledgers) != len(peers) {
+		return ledgers, fmt.Errorf("got %d bitswap ledgers out of %d", len(ledgers), len(peers))
+	}
+	return ledgers, nil
+}
+
+// ledgersJSON is the output of a node of a bitswap_ledgers step.
+func ledgersJSON(ledgers []report.Ledger) string {
+	byPeer := make(map[string]interface{})
+	for _, ledger := range ledgers {
+		byPeer[strconv.Itoa(ledger.Peer)] = map[string]uint64{"sent": ledger.Sent, "received": ledger.Received, "exchanged": ledger.Exchanged}
+	}
+	line, _ := json.Marshal(byPeer)
+	return string(line)
+}
+
+// printLedgers prints the bytes every node sent to every other one, a row
+// per sender and a column per receiver.
+func printLedgers(ledgers []report.Ledger) {
+	nodes, rows := report.LedgerMatrix(ledgers)
+	if len(nodes) == 0 {
+		return
+	}
+	header := fmt.Sprintf("%12s", "sent \\ to")
+	for _, node := range nodes {
+		header += fmt.Sprintf(" %12d", node)
+	}
+	fmt.Println(header)
+	for i, row := range rows {
+		line := fmt.Sprintf("%12d", nodes[i])
+		for _, ledger := range row {
+			if ledger == nil {
+				line += fmt.Sprintf(" %12s", "-")
+			} else {
+				line += fmt.Sprintf(" %12d", ledger.Sent)
+			}
+		}
+		fmt.Println(line)
+	}
+}
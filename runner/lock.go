@@ -0,0 +1,71 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// lockPollInterval is how often a step waiting for a lock checks again.
+const lockPollInterval = 2 * time.Second
+
+var lockNameRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+var (
+	locks      = make(map[string]*sync.Mutex)
+	locksMutex sync.Mutex
+)
+
+// acquireLock takes the named lock, waiting for as long as it is held. Steps
+// of this run are serialized with an in-process mutex, and other runs against
+// the same cluster through a ConfigMap whose creation only one of them can
+// win. A ConfigMap left behind by a crashed run has to be deleted by hand;
+// its holder is printed while waiting. Cancelling the run stops the wait.
+func acquireLock(name string) error {
+	locksMutex.Lock()
+	mutex, ok := locks[name]
+	if !ok {
+		mutex = new(sync.Mutex)
+		locks[name] = mutex
+	}
+	locksMutex.Unlock()
+	mutex.Lock()
+
+	hostname, _ := os.Hostname()
+	holder := fmt.Sprintf("%s:%d", hostname, os.Getpid())
+	for {
+		err := Kubectl("create", "configmap", LockConfigMap(name), "--from-literal=holder="+holder)
+		if err == nil {
+			return nil
+		}
+		if !strings.Contains(err.Error(), "AlreadyExists") {
+			mutex.Unlock()
+			return err
+		}
+		color.Yellow("Waiting for lock %s (configmap/%s)", name, LockConfigMap(name))
+		if !sleep(lockPollInterval) {
+			mutex.Unlock()
+			return runContext.Err()
+		}
+	}
+}
+
+func releaseLock(name string) {
+	err := Kubectl("delete", "configmap", LockConfigMap(name))
+	if err != nil {
+		color.Red("Could not release lock %s: %s", name, err)
+	}
+	locksMutex.Lock()
+	mutex := locks[name]
+	locksMutex.Unlock()
+	mutex.Unlock()
+}
+
+func LockConfigMap(name string) string {
+	return "kubernetes-ipfs-lock-" + name
+}
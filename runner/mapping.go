@@ -0,0 +1,93 @@
+package runner
+
+import (
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// nodeMapping keeps the node numbers of the pods stable for the whole run.
+// The pods are sorted when the run starts; afterwards a pod keeps its number
+// for as long as it exists, and pods that appear take over the numbers of
+// those that went away, so "node 3" doesn't change after churn.
+type nodeMapping struct {
+	// pods holds the names of the pods by node, per group, the main pods
+	// under "".
+	pods map[string][]string
+	// served is the mapping as recorded in the summary.
+	served []report.NodePod
+}
+
+func newNodeMapping(served []report.NodePod) *nodeMapping {
+	m := &nodeMapping{pods: make(map[string][]string), served: served}
+	for _, pod := range served {
+		names := m.pods[pod.Group]
+		for len(names) < pod.Node {
+			names = append(names, "")
+		}
+		names[pod.Node-1] = pod.Pod
+		m.pods[pod.Group] = names
+	}
+	return m
+}
+
+// assign orders the pods of a group by the node numbers they had so far, and
+// records the first nodes of the group as served.
+func (m *nodeMapping) assign(group string, pods []Pod, nodes int) []Pod {
+	byName := make(map[string]Pod, len(pods))
+	for _, pod := range pods {
+		byName[pod.Metadata.Name] = pod
+	}
+	var ordered []Pod
+	taken := make(map[string]bool)
+	var free []int
+	for _, name := range m.pods[group] {
+		if pod, ok := byName[name]; ok {
+			ordered = append(ordered, pod)
+			taken[name] = true
+		} else {
+			free = append(free, len(ordered))
+			ordered = append(ordered, Pod{})
+		}
+	}
+	// New pods fill the numbers of the pods that went away first, in the
+	// order they were sorted in.
+	for _, pod := range pods {
+		if taken[pod.Metadata.Name] {
+			continue
+		}
+		if len(free) != 0 {
+			ordered[free[0]] = pod
+			free = free[1:]
+		} else {
+			ordered = append(ordered, pod)
+		}
+	}
+	// Numbers left free are given up to the pods after them.
+	kept := ordered[:0]
+	for _, pod := range ordered {
+		if pod.Metadata.Name != "" {
+			kept = append(kept, pod)
+		}
+	}
+	ordered = kept
+
+	names := make([]string, len(ordered))
+	for index, pod := range ordered {
+		names[index] = pod.Metadata.Name
+		if index < nodes && (index >= len(m.pods[group]) || m.pods[group][index] != names[index]) {
+			m.served = append(m.served, report.NodePod{Node: index + 1, Group: group, Pod: names[index]})
+		}
+	}
+	m.pods[group] = names
+	return ordered
+}
+
+// set records a pod put in the place of a node during an iteration.
+func (m *nodeMapping) set(group string, node int, name string) {
+	if m == nil {
+		return
+	}
+	if names := m.pods[group]; node <= len(names) {
+		names[node-1] = name
+	}
+	m.served = append(m.served, report.NodePod{Node: node, Group: group, Pod: name})
+}
@@ -0,0 +1,168 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// matrixRun is the outcome of one combination of a matrix.
+type matrixRun struct {
+	Parameters map[string]interface{}
+	Summary    report.Summary
+	Outcome    int
+}
+
+// RunMatrix runs the test once per combination of the matrix values, which
+// are passed to the test template along with the --set values. Values given
+// with --set pin their variable instead of being iterated. It returns 0 when
+// every combination met its expectations and 1 otherwise.
+func RunMatrix(filePath string, matrix map[string][]interface{}, opts *Options) int {
+	if opts.StateFile != "" {
+		Fatal("--state-file and --resume only apply to tests without a matrix")
+	}
+	for key := range matrix {
+		if _, ok := opts.Values[key]; ok {
+			delete(matrix, key)
+		}
+	}
+	for key, values := range matrix {
+		if len(values) == 0 {
+			Fatal(fmt.Sprintf("matrix variable %s has no values", key))
+		}
+	}
+	// Every combination is loaded and checked before the first one runs, so
+	// a broken one doesn't surface hours into the matrix.
+	combinations := matrixCombinations(matrix)
+	tests := make([]*config.Test, len(combinations))
+	options := make([]Options, len(combinations))
+	for i, parameters := range combinations {
+		label := matrixLabel(parameters)
+		run := *opts
+		run.Values = matrixValues(opts.Values, parameters)
+		run.parameters = parameters
+		run.ReportFile = MatrixFile(opts.ReportFile, label, opts.ReportFormat != "sqlite" && opts.ReportFormat != "influx")
+		run.HTMLReport = MatrixFile(opts.HTMLReport, label, true)
+		run.EventsOut = MatrixFile(opts.EventsOut, label, true)
+		run.OutputDir = MatrixFile(opts.OutputDir, label, true)
+		options[i] = run
+
+		tests[i] = LoadRunTest(filePath, &options[i])
+		if label != "" {
+			tests[i].Name += " [" + label + "]"
+		}
+		err := ValidateTest(tests[i])
+		if err != nil {
+			Fatal(fmt.Sprintf("%s: %s", label, err))
+		}
+	}
+
+	var runs []matrixRun
+	outcome := 0
+	for i, test := range tests {
+		summary, result := ExecuteTest(test, &options[i])
+		runs = append(runs, matrixRun{Parameters: combinations[i], Summary: summary, Outcome: result})
+		if result != 0 {
+			outcome = 1
+		}
+	}
+	printMatrixSummary(runs)
+	return outcome
+}
+
+// ValidateMatrix checks the test as rendered for every combination of the
+// matrix.
+func ValidateMatrix(filePath string, matrix map[string][]interface{}, values config.SetValues, defaults *Defaults) error {
+	for key, values := range matrix {
+		if len(values) == 0 {
+			return fmt.Errorf("matrix variable %s has no values", key)
+		}
+	}
+	for _, parameters := range matrixCombinations(matrix) {
+		test, err := config.LoadTest(filePath, matrixValues(values, parameters))
+		if err == nil {
+			defaults.ApplyTo(&test.Config)
+			err = ValidateTest(test)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", matrixLabel(parameters), err)
+		}
+	}
+	return nil
+}
+
+// matrixValues adds the values of a combination to the --set values.
+func matrixValues(values config.SetValues, parameters map[string]interface{}) config.SetValues {
+	merged := make(config.SetValues)
+	for key, value := range values {
+		merged[key] = value
+	}
+	for key, value := range parameters {
+		merged[key] = value
+	}
+	return merged
+}
+
+// matrixCombinations returns every combination of the matrix values, the
+// variables sorted by name and the last one changing fastest.
+func matrixCombinations(matrix map[string][]interface{}) []map[string]interface{} {
+	keys := make([]string, 0, len(matrix))
+	for key := range matrix {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	combinations := []map[string]interface{}{{}}
+	for _, key := range keys {
+		var next []map[string]interface{}
+		for _, combination := range combinations {
+			for _, value := range matrix[key] {
+				extended := map[string]interface{}{key: value}
+				for k, v := range combination {
+					extended[k] = v
+				}
+				next = append(next, extended)
+			}
+		}
+		combinations = next
+	}
+	return combinations
+}
+
+// matrixLabel names a combination, e.g. "file_size=1MB nodes=5".
+func matrixLabel(parameters map[string]interface{}) string {
+	pairs := make([]string, 0, len(parameters))
+	for key, value := range parameters {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", key, value))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, " ")
+}
+
+// MatrixFile gives each combination its own output file by adding the label
+// to the name, unless the file is shared or isn't a file at all.
+func MatrixFile(path string, label string, own bool) string {
+	if !own || label == "" || path == "" || path == "-" || report.IsURL(path) {
+		return path
+	}
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "-" + strings.Replace(label, " ", "-", -1) + ext
+}
+
+func printMatrixSummary(runs []matrixRun) {
+	fmt.Println("============================")
+	fmt.Println("== Matrix Summary")
+	fmt.Println("===============")
+	for _, run := range runs {
+		status := "passed"
+		if run.Outcome != 0 {
+			status = "FAILED"
+		}
+		fmt.Printf("== %s: %d/%d (success/failure), %d timeouts, %s, %s\n",
+			matrixLabel(run.Parameters), run.Summary.Successes, run.Summary.Failures, run.Summary.Timeouts,
+			run.Summary.End.Sub(run.Summary.Start), status)
+	}
+}
@@ -0,0 +1,139 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// incidentEvents are the reasons of pod events reported as incidents, with
+// their kind.
+var incidentEvents = map[string]string{
+	"Evicted":    "evicted",
+	"Preempting": "preempted",
+}
+
+// watchedPod is the pod of a node as it was when the iteration started.
+type watchedPod struct {
+	group string
+	node  int
+	pod   Pod
+}
+
+// watched returns the pods of the fleet's nodes, to compare them with at the
+// end of the iteration.
+func (fleet *Fleet) watched() []watchedPod {
+	var pods []watchedPod
+	for index, pod := range fleet.Pods.Items {
+		if index < fleet.Config.Nodes {
+			pods = append(pods, watchedPod{node: index + 1, pod: pod})
+		}
+	}
+	for _, group := range fleet.Config.Groups {
+		for index, pod := range fleet.Groups[group.Name].Items {
+			pods = append(pods, watchedPod{group: group.Name, node: index + 1, pod: pod})
+		}
+	}
+	return pods
+}
+
+// podIncidents compares the pods with their state at the start of the
+// iteration and returns the restarts, OOM kills and evictions since then.
+func podIncidents(fleet *Fleet, before []watchedPod, since time.Time) ([]report.PodIncident, error) {
+	current := make(map[string]Pod)
+	var events []podEvent
+	targets := make(map[kubeTarget]bool)
+	for _, cfg := range fleet.configs() {
+		pods, err := getPods(cfg)
+		if err != nil {
+			return nil, err
+		}
+		for _, pod := range pods.Items {
+			current[pod.Metadata.UID] = pod
+		}
+		if !targets[configTarget(cfg)] {
+			targets[configTarget(cfg)] = true
+			more, err := podEvents(configTarget(cfg))
+			if err != nil {
+				return nil, err
+			}
+			events = append(events, more...)
+		}
+	}
+
+	var incidents []report.PodIncident
+	for _, watched := range before {
+		incident := report.PodIncident{Node: watched.node, Group: watched.group, Pod: watched.pod.Metadata.Name}
+		if now, ok := current[watched.pod.Metadata.UID]; ok {
+			restarts := make(map[string]int)
+			for _, status := range watched.pod.Status.ContainerStatuses {
+				restarts[status.Name] = status.RestartCount
+			}
+			for _, status := range now.Status.ContainerStatuses {
+				count := status.RestartCount - restarts[status.Name]
+				if count <= 0 {
+					continue
+				}
+				incident.Time, incident.Kind = time.Now(), "restart"
+				incident.Message = fmt.Sprintf("container %s restarted %d times", status.Name, count)
+				if terminated := status.LastState.Terminated; terminated != nil {
+					incident.Time = terminated.FinishedAt
+					incident.Message += ", last terminated: " + terminated.Reason
+					if terminated.Reason == "OOMKilled" {
+						incident.Kind = "oom_killed"
+					}
+				}
+				incidents = append(incidents, incident)
+			}
+		}
+		for _, event := range events {
+			kind, ok := incidentEvents[event.Reason]
+			if ok && event.InvolvedObject.UID == watched.pod.Metadata.UID && !event.time().Before(since) {
+				incident.Time, incident.Kind, incident.Message = event.time(), kind, kind+": "+event.Message
+				incidents = append(incidents, incident)
+			}
+		}
+	}
+	return incidents, nil
+}
+
+// podEvent is a Kubernetes event about a pod.
+type podEvent struct {
+	InvolvedObject struct {
+		UID string `json:"uid"`
+	} `json:"involvedObject"`
+	Reason        string     `json:"reason"`
+	Message       string     `json:"message"`
+	LastTimestamp *time.Time `json:"lastTimestamp"`
+	EventTime     *time.Time `json:"eventTime"`
+}
+
+func (event podEvent) time() time.Time {
+	if event.LastTimestamp != nil {
+		return *event.LastTimestamp
+	}
+	if event.EventTime != nil {
+		return *event.EventTime
+	}
+	return time.Time{}
+}
+
+func podEvents(target kubeTarget) ([]podEvent, error) {
+	cmd := target.command(runContext, "get", "events", "--output=json", "--field-selector=involvedObject.kind=Pod")
+	out := new(bytes.Buffer)
+	errout := new(bytes.Buffer)
+	cmd.Stdout = out
+	cmd.Stderr = errout
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("get events error: %s %s", err, errout.String())
+	}
+	var events struct {
+		Items []podEvent `json:"items"`
+	}
+	err = json.Unmarshal(out.Bytes(), &events)
+	return events.Items, err
+}
@@ -0,0 +1,64 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+const defaultNotifyTemplate = `{"text": {{json .Message}}}`
+
+// notify sends the notification. failure is the error a run aborted with.
+func notify(cfg *config.Notify, summary *report.Summary, passed bool, failure string) error {
+	text := cfg.Template
+	if text == "" {
+		text = defaultNotifyTemplate
+	}
+	tmpl, err := template.New("notify").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			out, err := json.Marshal(v)
+			return string(out), err
+		},
+	}).Parse(text)
+	if err != nil {
+		return err
+	}
+	status := "passed"
+	if !passed {
+		status = "failed"
+	}
+	message := fmt.Sprintf("kubernetes-ipfs: %s %s, %d/%d/%d (success/failure/timeout) in %d iterations",
+		summary.Name, status, summary.Successes, summary.Failures, summary.Timeouts, summary.TestsRan)
+	if failure != "" {
+		message += ": " + failure
+	}
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, struct {
+		*report.Summary
+		Passed  bool
+		Error   string
+		Message string
+	}{summary, passed, failure, message})
+	if err != nil {
+		return err
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(os.ExpandEnv(cfg.URL), "application/json", &body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		out, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook replied %s: %s", resp.Status, out)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// defaultObserveInterval is the sampling interval, in seconds, used when the
+// observe block doesn't set one.
+const defaultObserveInterval = 5
+
+// observe keeps sampling the test nodes for the length of the window and adds
+// what it saw to the summary: bandwidth and peer counts become metrics, and
+// the daemon logs written during the window are attached per node.
+func observe(cfg *config.Observe, window time.Duration, pods []Pod, summary *report.Summary) {
+	collect := map[string]bool{"bandwidth": true, "peers": true, "logs": true}
+	if len(cfg.Collect) != 0 {
+		collect = make(map[string]bool)
+		for _, collector := range cfg.Collect {
+			collect[collector] = true
+		}
+	}
+	interval := cfg.Interval
+	if interval == 0 {
+		interval = defaultObserveInterval
+	}
+
+	start := time.Now()
+	deadline := start.Add(window)
+	var mutex sync.Mutex
+	for time.Now().Before(deadline) && runContext.Err() == nil {
+		var wg sync.WaitGroup
+		for index, pod := range pods {
+			wg.Add(1)
+			go func(node int, name string) {
+				defer wg.Done()
+				metrics := sampleNode(node, name, collect)
+				mutex.Lock()
+				summary.Metrics = append(summary.Metrics, metrics...)
+				mutex.Unlock()
+			}(index+1, pod.Metadata.Name)
+		}
+		wg.Wait()
+		pause := time.Duration(interval) * time.Second
+		if remaining := deadline.Sub(time.Now()); remaining < pause {
+			pause = remaining
+		}
+		if pause > 0 {
+			sleep(pause)
+		}
+	}
+
+	if collect["logs"] {
+		for index, pod := range pods {
+			summary.Logs = append(summary.Logs, report.NodeLog{
+				Node:  index + 1,
+				Pod:   pod.Metadata.Name,
+				Lines: podLogsSince(pod.Metadata.Name, start),
+			})
+		}
+	}
+}
+
+// sampleNode takes one sample of the enabled collectors on a node.
+func sampleNode(node int, name string, collect map[string]bool) []report.Metric {
+	now := time.Now()
+	var metrics []report.Metric
+	if collect["bandwidth"] {
+		out, _ := RunInPod(name, "ipfs stats bw --enc=json", nil, 10)
+		var bw struct {
+			TotalIn  float64
+			TotalOut float64
+			RateIn   float64
+			RateOut  float64
+		}
+		err := json.Unmarshal([]byte(strings.Join(out, "\n")), &bw)
+		if err != nil {
+			color.Red("Failed to sample bandwidth on node %d: %s", node, err)
+		} else {
+			metrics = append(metrics,
+				report.Metric{Time: now, Node: node, Pod: name, Name: "observe_total_in_bytes", Value: bw.TotalIn},
+				report.Metric{Time: now, Node: node, Pod: name, Name: "observe_total_out_bytes", Value: bw.TotalOut},
+				report.Metric{Time: now, Node: node, Pod: name, Name: "observe_rate_in_bytes", Value: bw.RateIn},
+				report.Metric{Time: now, Node: node, Pod: name, Name: "observe_rate_out_bytes", Value: bw.RateOut},
+			)
+		}
+	}
+	if collect["peers"] {
+		out, _ := RunInPod(name, "ipfs swarm peers | wc -l", nil, 10)
+		if len(out) == 0 {
+			color.Red("Failed to sample peers on node %d", node)
+		} else if peers, err := strconv.Atoi(strings.TrimSpace(out[0])); err == nil {
+			metrics = append(metrics, report.Metric{Time: now, Node: node, Pod: name, Name: "observe_peers", Value: float64(peers)})
+		}
+	}
+	return metrics
+}
+
+func podLogsSince(name string, since time.Time) []string {
+	var out bytes.Buffer
+	cmd := targetOf(name).command(runContext, "logs", name, "--since-time="+since.Format(time.RFC3339))
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		color.Red("Failed to get logs of %s: %s", name, err)
+		return nil
+	}
+	return strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+}
@@ -0,0 +1,391 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// apiPort is the port of the go-ipfs HTTP API inside the pods.
+const apiPort = 5001
+
+// Operation is a built-in ipfs command. It runs against the node's HTTP API
+// when the runner can reach it and falls back to the CLI through kubectl exec
+// otherwise. Both return the same JSON, which decode turns into named fields
+// and the lines used as the step's output.
+type Operation struct {
+	// Path is the API endpoint below /api/v0, e.g. "pin/add".
+	Path string
+	// Args lists the step arguments passed as positional arguments, and
+	// Options those passed as options, e.g. key for --key.
+	Args    []string
+	Options []string
+	// Stream marks operations returning raw data rather than JSON, and
+	// Upload those sending the content or local_file argument as a file.
+	Stream bool
+	Upload bool
+	Decode func(body []byte) (fields map[string]string, lines []string, err error)
+}
+
+// operations are the built-ins available to the `op` field of a step.
+var operations = map[string]Operation{
+	"add": {
+		Path:   "add",
+		Upload: true,
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct{ Name, Hash, Size string }
+			err := json.Unmarshal(lastJSONLine(body), &out)
+			return map[string]string{"cid": out.Hash, "size": out.Size}, []string{out.Hash}, err
+		},
+	},
+	"cat": {
+		Path:   "cat",
+		Args:   []string{"cid"},
+		Stream: true,
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			content := strings.TrimSuffix(string(body), "\n")
+			return map[string]string{"content": content, "size": strconv.Itoa(len(body))}, strings.Split(content, "\n"), nil
+		},
+	},
+	"pin_add": {
+		Path: "pin/add",
+		Args: []string{"cid"},
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct{ Pins []string }
+			err := json.Unmarshal(lastJSONLine(body), &out)
+			return map[string]string{"pins": strings.Join(out.Pins, "\n")}, out.Pins, err
+		},
+	},
+	"pin_ls": {
+		Path: "pin/ls",
+		Args: []string{"cid"},
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct {
+				Keys map[string]struct{ Type string }
+			}
+			err := json.Unmarshal(body, &out)
+			cids := make([]string, 0, len(out.Keys))
+			for cid := range out.Keys {
+				cids = append(cids, cid)
+			}
+			sort.Strings(cids)
+			return map[string]string{"cids": strings.Join(cids, "\n"), "count": strconv.Itoa(len(cids))}, cids, err
+		},
+	},
+	"swarm_connect": {
+		Path: "swarm/connect",
+		Args: []string{"addr"},
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct{ Strings []string }
+			err := json.Unmarshal(body, &out)
+			return map[string]string{"result": strings.Join(out.Strings, "\n")}, out.Strings, err
+		},
+	},
+	"name_publish": {
+		Path:    "name/publish",
+		Args:    []string{"value"},
+		Options: []string{"key", "lifetime", "ttl"},
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct{ Name, Value string }
+			err := json.Unmarshal(body, &out)
+			return map[string]string{"name": out.Name, "value": out.Value}, []string{out.Name}, err
+		},
+	},
+	"name_resolve": {
+		Path:    "name/resolve",
+		Args:    []string{"name"},
+		Options: []string{"nocache", "dht-timeout"},
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct{ Path string }
+			err := json.Unmarshal(body, &out)
+			// The CID the name points to, without the path below it.
+			cid := strings.SplitN(strings.TrimPrefix(out.Path, "/ipfs/"), "/", 2)[0]
+			return map[string]string{"path": out.Path, "cid": cid}, []string{out.Path, cid}, err
+		},
+	},
+	"files_write": {
+		Path:    "files/write",
+		Args:    []string{"to"},
+		Options: []string{"create", "parents", "truncate"},
+		Upload:  true,
+		Decode:  noResult,
+	},
+	"files_cp": {
+		Path:    "files/cp",
+		Args:    []string{"from", "to"},
+		Options: []string{"parents"},
+		Decode:  noResult,
+	},
+	"files_stat": {
+		Path: "files/stat",
+		Args: []string{"file"},
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct {
+				Hash           string
+				Size           int64
+				CumulativeSize int64
+				Type           string
+			}
+			err := json.Unmarshal(body, &out)
+			return map[string]string{
+				"cid":             out.Hash,
+				"size":            strconv.FormatInt(out.Size, 10),
+				"cumulative_size": strconv.FormatInt(out.CumulativeSize, 10),
+				"type":            out.Type,
+			}, []string{out.Hash}, err
+		},
+	},
+	"id": {
+		Path: "id",
+		Decode: func(body []byte) (map[string]string, []string, error) {
+			var out struct {
+				ID           string
+				Addresses    []string
+				AgentVersion string
+			}
+			err := json.Unmarshal(body, &out)
+			return map[string]string{
+				"peer_id":       out.ID,
+				"addresses":     strings.Join(out.Addresses, "\n"),
+				"agent_version": out.AgentVersion,
+			}, []string{out.ID}, err
+		},
+	},
+}
+
+var (
+	apiReachable      = make(map[string]bool)
+	apiReachableMutex sync.Mutex
+)
+
+// runOpAsync runs the step's built-in operation on a pod and hands the
+// result to the channel, like runInPodAsync does for commands.
+func runOpAsync(node int, pod Pod, step *config.Step, env []string, results chan *report.NodeResult) {
+	go func() {
+		results <- runOp(node, pod, step, env)
+	}()
+}
+
+// runOp runs the step's built-in operation on a pod.
+func runOp(node int, pod Pod, step *config.Step, env []string) *report.NodeResult {
+	result := &report.NodeResult{Node: node, Pod: pod.Metadata.Name}
+	op := operations[step.Op]
+	args := make(map[string]string)
+	for key, value := range step.Args {
+		args[key] = config.ExpandEnv(config.ForNode(value, node), env)
+	}
+	if step.MeasureTransfer {
+		runTimedCat(pod, args, step.Timeout, result)
+		return result
+	}
+
+	var body []byte
+	var err error
+	// A path names a file inside the pod, which only the CLI can read.
+	if args["path"] == "" && hasAPI(pod) {
+		body, err = opOverAPI(pod, op, args, step.Timeout)
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			result.TimedOut = true
+		} else if _, ok := err.(*url.Error); ok {
+			// The API couldn't be reached at all.
+			result.Error = err.Error()
+		}
+	} else {
+		body, result.TimedOut, err = opOverCLI(pod, op, args, step.Timeout)
+		if _, ok := err.(execError); ok {
+			result.Error = err.Error()
+		}
+	}
+	if failed, ok := err.(opError); ok {
+		result.HTTPStatus, result.ExitCode = failed.status, failed.exitCode
+	}
+	if err != nil {
+		color.Red("Operation %s failed on node %d: %s", step.Op, node, err)
+		return result
+	}
+	result.Fields, result.Output, err = op.Decode(body)
+	if err != nil {
+		color.Red("Could not decode result of %s on node %d: %s", step.Op, node, err)
+	}
+	return result
+}
+
+// hasAPI reports whether the runner can talk to the pod's HTTP API directly,
+// which is usually only the case when it runs inside the cluster. The nodes
+// of the iptb and docker backends have no pod IP, and the API on localhost
+// would be another node's, or none of them.
+func hasAPI(pod Pod) bool {
+	if pod.Status.PodIP == "" {
+		return false
+	}
+	apiReachableMutex.Lock()
+	reachable, ok := apiReachable[pod.Metadata.Name]
+	apiReachableMutex.Unlock()
+	if ok {
+		return reachable
+	}
+	// Pods are probed at once, which may probe one twice.
+	client := http.Client{Timeout: time.Second}
+	resp, err := client.Post(apiURL(pod, "version", nil), "", nil)
+	reachable = err == nil && resp.StatusCode == http.StatusOK
+	if err == nil {
+		resp.Body.Close()
+	}
+	apiReachableMutex.Lock()
+	apiReachable[pod.Metadata.Name] = reachable
+	apiReachableMutex.Unlock()
+	return reachable
+}
+
+func apiURL(pod Pod, path string, query url.Values) string {
+	u := fmt.Sprintf("http://%s:%d/api/v0/%s", pod.Status.PodIP, apiPort, path)
+	if len(query) != 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+func opOverAPI(pod Pod, op Operation, args map[string]string, timeout int) ([]byte, error) {
+	query := url.Values{}
+	for _, name := range op.Args {
+		if args[name] != "" {
+			query.Add("arg", args[name])
+		}
+	}
+	for _, name := range op.Options {
+		if args[name] != "" {
+			query.Set(name, args[name])
+		}
+	}
+	var body bytes.Buffer
+	contentType := ""
+	if op.Upload {
+		content, err := addContent(args)
+		if err != nil {
+			return nil, err
+		}
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", "file")
+		if err != nil {
+			return nil, err
+		}
+		part.Write(content)
+		writer.Close()
+		contentType = writer.FormDataContentType()
+	}
+	req, err := http.NewRequestWithContext(runContext, http.MethodPost, apiURL(pod, op.Path, query), &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	client := http.Client{Timeout: time.Duration(timeout) * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	out, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, opError{status: resp.StatusCode, message: fmt.Sprintf("%s: %s", resp.Status, out)}
+	}
+	return out, nil
+}
+
+func opOverCLI(pod Pod, op Operation, args map[string]string, timeout int) ([]byte, bool, error) {
+	cmd := "ipfs " + strings.Replace(op.Path, "/", " ", -1)
+	if !op.Stream {
+		cmd += " --enc=json"
+	}
+	for _, name := range op.Args {
+		if args[name] != "" {
+			cmd += " " + ShellQuote(args[name])
+		}
+	}
+	for _, name := range op.Options {
+		if args[name] != "" {
+			cmd += " " + ShellQuote("--"+name+"="+args[name])
+		}
+	}
+	if op.Upload {
+		if op.Path == "add" && args["path"] != "" {
+			cmd += " " + ShellQuote(args["path"])
+		} else {
+			content, err := addContent(args)
+			if err != nil {
+				return nil, false, err
+			}
+			cmd = "printf '%s' " + ShellQuote(string(content)) + " | " + cmd
+		}
+	}
+	command := podCommand(pod.Metadata.Name, cmd, nil, timeout)
+	var out, errout bytes.Buffer
+	timedOut, exitCode := command.run(&out, &errout)
+	if command.execErr != "" {
+		return nil, timedOut, execError(command.execErr)
+	}
+	lines := strings.Split(out.String(), "\n")
+	lines = lines[:len(lines)-1]
+	if exitCode != 0 && !timedOut {
+		// The terminal of kubectl exec mixes stderr into stdout.
+		message := strings.TrimSpace(errout.String() + strings.Join(lines, "\n"))
+		return nil, false, opError{exitCode: exitCode, message: fmt.Sprintf("exit code %d: %s", exitCode, message)}
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), timedOut, nil
+}
+
+// execError is kubectl exec failing to run a command in a pod.
+type execError string
+
+func (e execError) Error() string {
+	return string(e)
+}
+
+// opError is a node refusing an operation: its API replying with another
+// status than 200, or the CLI exiting with another code than 0.
+type opError struct {
+	status   int
+	exitCode int
+	message  string
+}
+
+func (e opError) Error() string {
+	return e.message
+}
+
+// addContent returns the data an operation uploads: its `content` argument,
+// or the local file named by `local_file`.
+func addContent(args map[string]string) ([]byte, error) {
+	if args["local_file"] != "" {
+		return ioutil.ReadFile(args["local_file"])
+	}
+	return []byte(args["content"]), nil
+}
+
+// noResult decodes the result of the operations returning nothing.
+func noResult(body []byte) (map[string]string, []string, error) {
+	return map[string]string{}, nil, nil
+}
+
+// lastJSONLine returns the last non-empty line of a newline-delimited JSON
+// stream, which is the final result of commands like add.
+func lastJSONLine(body []byte) []byte {
+	lines := bytes.Split(bytes.TrimSpace(body), []byte("\n"))
+	return lines[len(lines)-1]
+}
@@ -0,0 +1,90 @@
+package runner
+
+import (
+	"math"
+	"strconv"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+)
+
+// setOverrideValues passes the overrides to the test's template as well, as
+// nodes, times and selector, unless they are set with --set. Templated steps
+// then follow the overridden scale.
+func (opts *Options) setOverrideValues() {
+	overrides := map[string]string{"selector": opts.Selector}
+	if opts.Nodes != 0 {
+		overrides["nodes"] = strconv.Itoa(opts.Nodes)
+	}
+	if opts.Times != 0 {
+		overrides["times"] = strconv.Itoa(opts.Times)
+	}
+	for key, value := range overrides {
+		if _, ok := opts.Values[key]; !ok && value != "" {
+			opts.Values[key] = value
+		}
+	}
+}
+
+// override applies the overrides to a loaded test.
+func (opts *Options) override(test *config.Test) {
+	if opts.Nodes != 0 {
+		test.Config.Nodes = opts.Nodes
+	}
+	if opts.Times != 0 {
+		test.Config.Times = opts.Times
+	}
+	if opts.Selector != "" {
+		test.Config.Selector = opts.Selector
+	}
+	if opts.TimeoutScale != 1 {
+		for i := range test.Steps {
+			step := &test.Steps[i]
+			step.Timeout = int(math.Ceil(float64(step.Timeout) * opts.TimeoutScale))
+			if step.Poll != nil {
+				step.Poll.Timeout = int(math.Ceil(float64(step.Poll.Timeout) * opts.TimeoutScale))
+			}
+		}
+	}
+}
+
+// selectSteps drops the steps not selected by --tags and --skip-tags. A step
+// carries its own tags and those of the test. Steps tagged "always" run
+// unless skipped explicitly. Tag expectations no step is left to meet are
+// dropped too.
+func (opts *Options) selectSteps(test *config.Test) {
+	if len(opts.Tags) == 0 && len(opts.SkipTags) == 0 {
+		return
+	}
+	var steps []config.Step
+	carried := make(map[string]bool)
+	for _, step := range test.Steps {
+		tags := append(append([]string{}, test.Tags...), step.Tags...)
+		if hasTag(tags, opts.SkipTags...) {
+			continue
+		}
+		if len(opts.Tags) != 0 && !hasTag(tags, opts.Tags...) && !hasTag(tags, "always") {
+			continue
+		}
+		steps = append(steps, step)
+		for _, tag := range step.Tags {
+			carried[tag] = true
+		}
+	}
+	test.Steps = steps
+	for tag := range test.Config.Expected.Tags {
+		if !carried[tag] {
+			delete(test.Config.Expected.Tags, tag)
+		}
+	}
+}
+
+func hasTag(tags []string, wanted ...string) bool {
+	for _, tag := range tags {
+		for _, want := range wanted {
+			if tag == want {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,88 @@
+package runner
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// runAbort holds why a run stops early. Iterations running in parallel share
+// it, so a failing one stops the others after their current step.
+type runAbort struct {
+	mutex  sync.Mutex
+	reason string
+}
+
+// set records the reason the run stops, unless it already stopped.
+func (a *runAbort) set(reason string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.reason == "" {
+		a.reason = reason
+	}
+}
+
+func (a *runAbort) get() string {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.reason
+}
+
+// validateParallel checks that the test can run parallel_iterations at once:
+// each iteration gets the same share of the nodes, and steps acting beyond
+// their own nodes would get in the way of the other iterations.
+func validateParallel(test *config.Test) error {
+	parallel := test.Config.ParallelIterations
+	if parallel <= 1 {
+		return nil
+	}
+	if len(test.Config.Groups) != 0 {
+		return fmt.Errorf("parallel_iterations can't be used with groups")
+	}
+	if test.Config.Nodes%parallel != 0 {
+		return fmt.Errorf("parallel_iterations must divide the %d nodes evenly", test.Config.Nodes)
+	}
+	if test.Config.WaitForReplacement != "" {
+		return fmt.Errorf("wait_for_replacement can't be used with parallel_iterations")
+	}
+	for _, step := range test.Steps {
+		if step.Partition != nil || step.Heal != "" || step.KillNode != "" || step.WaitForReschedule {
+			return fmt.Errorf("step %s partitions or kills nodes, which can't be done with parallel_iterations", step.Name)
+		}
+	}
+	return nil
+}
+
+// iterationFleet returns the fleet of the k-th of the iterations running at
+// once: its own slice of the main pods, numbered from 1 again.
+func iterationFleet(fleet *Fleet, k int, parallel int) *Fleet {
+	if parallel <= 1 {
+		return fleet
+	}
+	share := fleet.Config.Nodes / parallel
+	own := *fleet
+	own.Pods = &GetPodsOutput{Items: fleet.Pods.Items[k*share : (k+1)*share]}
+	if fleet.Cluster == fleet.Pods {
+		own.Cluster = own.Pods
+	}
+	return &own
+}
+
+// addIteration adds the counts and measurements of an iteration, recorded in
+// a summary of its own, to the summary of the run.
+func addIteration(summary *report.Summary, iteration *report.Summary) {
+	addCounts(summary, iteration)
+	summary.TestsRan++
+}
+
+// addCounts adds the counts and measurements of other to summary.
+func addCounts(summary *report.Summary, other *report.Summary) {
+	summary.Successes += other.Successes
+	summary.Failures += other.Failures
+	summary.Timeouts += other.Timeouts
+	summary.Errors += other.Errors
+	summary.Metrics = append(summary.Metrics, other.Metrics...)
+	summary.Logs = append(summary.Logs, other.Logs...)
+}
@@ -0,0 +1,112 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// PartitionLabel is the pod label (suffixed with the partition name) that
+// marks which side of a partition a pod is on.
+const PartitionLabel = "kubernetes-ipfs/partition-"
+
+// activePartitions maps partitions that are in place to the pods they
+// labelled, so they can be healed at the end of the run.
+var activePartitions = make(map[string][]Pod)
+
+// handlePartitionStep creates or heals a partition, which fails the step
+// with an error when it can't.
+func handlePartitionStep(fleet *Fleet, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	var err error
+	if step.Heal != "" {
+		color.Blue("### Healing partition %s", step.Heal)
+		err = healPartition(step.Heal)
+	} else {
+		color.Blue("### Partitioning nodes into %d sides (%s)", len(step.Partition.Sides), step.Partition.Name)
+		err = createPartition(fleet, step.Partition)
+	}
+	if err != nil {
+		color.Red("Partition step %s failed: %s", step.Name, err)
+		summary.Errors++
+		result.Errors++
+	}
+	return env
+}
+
+func createPartition(fleet *Fleet, partition *config.Partition) error {
+	label := PartitionLabel + partition.Name
+	for index, side := range partition.Sides {
+		pods, err := fleet.resolve(side.OnGroup, side.OnNode, side.EndNode)
+		if err != nil {
+			return err
+		}
+		value := fmt.Sprintf("side%d", index+1)
+		for _, pod := range pods {
+			err = Kubectl("label", "pod", pod.Metadata.Name, label+"="+value, "--overwrite")
+			if err != nil {
+				return err
+			}
+			activePartitions[partition.Name] = append(activePartitions[partition.Name], pod)
+		}
+		selector := map[string]interface{}{"matchLabels": map[string]string{label: value}}
+		policy := map[string]interface{}{
+			"apiVersion": "networking.k8s.io/v1",
+			"kind":       "NetworkPolicy",
+			"metadata": map[string]interface{}{
+				"name":   fmt.Sprintf("kubernetes-ipfs-%s-%s", partition.Name, value),
+				"labels": map[string]string{"kubernetes-ipfs/partition": partition.Name},
+			},
+			"spec": map[string]interface{}{
+				"podSelector": selector,
+				"policyTypes": []string{"Ingress"},
+				"ingress":     []interface{}{map[string]interface{}{"from": []interface{}{map[string]interface{}{"podSelector": selector}}}},
+			},
+		}
+		manifest, err := json.Marshal(policy)
+		if err != nil {
+			return err
+		}
+		err = KubectlWithInput(manifest, "apply", "-f", "-")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// healPartition removes the NetworkPolicies of a partition and the labels it
+// put on the pods.
+func healPartition(name string) error {
+	err := Kubectl("delete", "networkpolicy", "-l", "kubernetes-ipfs/partition="+name)
+	if err != nil {
+		return err
+	}
+	for _, pod := range activePartitions[name] {
+		err = Kubectl("label", "pod", pod.Metadata.Name, PartitionLabel+name+"-")
+		if err != nil {
+			color.Red("Could not remove partition label from %s: %s", pod.Metadata.Name, err)
+		}
+	}
+	delete(activePartitions, name)
+	return nil
+}
+
+// healPartitions heals every partition a test left in place.
+func healPartitions() {
+	names := make([]string, 0, len(activePartitions))
+	for name := range activePartitions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		color.Blue("### Healing partition %s left over by the test", name)
+		err := healPartition(name)
+		if err != nil {
+			color.Red("Could not heal partition %s: %s", name, err)
+		}
+	}
+}
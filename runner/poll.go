@@ -0,0 +1,83 @@
+package runner
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/assert"
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// defaultPollInterval is the time, in seconds, between two attempts of a
+// polling step.
+const defaultPollInterval = 1
+
+// handlePollStep polls every node of the step in parallel, with its command
+// or its operation. Nodes whose assertions pass count their assertions as
+// successes; nodes still failing when the poll times out count as one timeout
+// each.
+func handlePollStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	interval := step.Poll.Interval
+	if interval == 0 {
+		interval = defaultPollInterval
+	}
+	color.Blue("### Polling step %s on nodes %d to %d every %ds for up to %ds",
+		step.Name, step.OnNode, step.EndNode, interval, step.Poll.Timeout)
+	if step.Op != "" {
+		color.Magenta("$ %s %v", step.Op, mask(fmt.Sprint(step.Args)))
+	} else {
+		color.Magenta("$ %s", step.CMD)
+	}
+	deadline := time.Now().Add(time.Duration(step.Poll.Timeout) * time.Second)
+
+	outputs := make(chan *report.NodeResult, step.EndNode-step.OnNode+1)
+	parallel := newLimiter(step.MaxParallel)
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		go func(node int, pod Pod) {
+			name := pod.Metadata.Name
+			nodeResult := &report.NodeResult{Node: node, Pod: name}
+			for {
+				var out []string
+				var timedOut bool
+				parallel.acquire()
+				if step.Op != "" {
+					attempt := runOp(node, pod, step, env)
+					out, timedOut = attempt.Output, attempt.TimedOut
+					nodeResult.Fields = attempt.Fields
+				} else {
+					out, timedOut = runPodCommand(stepCommand(name, step, node, env))
+				}
+				parallel.release()
+				nodeResult.Output = out
+				if !timedOut {
+					assertions, complete := assert.EvaluateAssertions(step.Assertions, nodeResult, env, summary.Iterations[len(summary.Iterations)-1])
+					nodeResult.Assertions = assertions
+					if complete && assert.AllPassed(assertions) {
+						break
+					}
+				}
+				if !time.Now().Add(time.Duration(interval)*time.Second).Before(deadline) || !sleep(time.Duration(interval)*time.Second) {
+					nodeResult.TimedOut = true
+					break
+				}
+			}
+			outputs <- nodeResult
+		}(j, pods.Items[j-1])
+	}
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		nodeResult := <-outputs
+		result.Nodes = append(result.Nodes, nodeResult)
+		if nodeResult.TimedOut {
+			color.Red("Node %d did not pass its assertions within %d seconds", nodeResult.Node, step.Poll.Timeout)
+			summary.Timeouts++
+			result.Timeouts++
+			continue
+		}
+		for _, assertion := range nodeResult.Assertions {
+			recordAssertion(assertion, summary, result)
+		}
+	}
+	return env
+}
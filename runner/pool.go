@@ -0,0 +1,67 @@
+package runner
+
+import (
+	"sync"
+	"time"
+)
+
+// limiter bounds how many things run at once. A nil limiter doesn't.
+type limiter chan struct{}
+
+func newLimiter(max int) limiter {
+	if max <= 0 {
+		return nil
+	}
+	return make(limiter, max)
+}
+
+func (l limiter) acquire() {
+	if l != nil {
+		l <- struct{}{}
+	}
+}
+
+func (l limiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// execLimiter bounds the commands running in pods at once over the whole
+// run, set by the test's max_parallel.
+var execLimiter limiter
+
+// rateLimiter spaces out events to at most a number per second. A nil
+// rateLimiter lets everything through.
+type rateLimiter struct {
+	mutex    sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Duration(float64(time.Second) / perSecond)}
+}
+
+// wait blocks until the next event is allowed.
+func (r *rateLimiter) wait() {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	at := r.next
+	r.next = r.next.Add(r.interval)
+	r.mutex.Unlock()
+	time.Sleep(at.Sub(now))
+}
+
+// kubectlRate limits how many commands are started in pods per second, set
+// by the test's kubectl_rate.
+var kubectlRate *rateLimiter
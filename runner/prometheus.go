@@ -0,0 +1,159 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/assert"
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// defaultPrometheus is the Prometheus service set up by init.sh, as
+// namespace/service:port.
+const defaultPrometheus = "monitoring/prometheus:9090"
+
+// handlePromQLStep runs the step's PromQL query against the cluster's
+// Prometheus and checks its assertions against the result, which becomes
+// the step's output as a single line of JSON: the `data.result` of the
+// Prometheus API, best checked with jq assertions.
+func handlePromQLStep(cfg *config.Config, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	query := config.ExpandEnv(step.PromQL, env)
+	color.Blue("### Running step %s against Prometheus", step.Name)
+	color.Magenta("$ promql %s", query)
+	nodeResult := &report.NodeResult{}
+	result.Nodes = append(result.Nodes, nodeResult)
+	out, err := queryPrometheus(cfg.Prometheus, query)
+	if err != nil {
+		color.Red("Prometheus query failed: %s", err)
+		summary.Failures++
+		result.Failures++
+		return env
+	}
+	nodeResult.Output = []string{out}
+	assertions, complete := assert.EvaluateAssertions(step.Assertions, nodeResult, env, summary.Iterations[len(summary.Iterations)-1])
+	for _, assertion := range assertions {
+		recordAssertion(assertion, summary, result)
+	}
+	nodeResult.Assertions = assertions
+	if !complete {
+		color.Red("Not enough lines in output.Skipping assertions")
+	}
+	return env
+}
+
+// queryPrometheus runs an instant query and returns its result as JSON.
+func queryPrometheus(server string, query string) (string, error) {
+	var data struct {
+		Result json.RawMessage
+	}
+	err := prometheusAPI(server, "query", url.Values{"query": {query}}, &data)
+	if err != nil {
+		return "", err
+	}
+	return string(data.Result), nil
+}
+
+// prometheusAPI calls an endpoint of the Prometheus API through the API
+// server's service proxy, so the runner doesn't need to reach Prometheus
+// directly, and decodes the data of its response.
+func prometheusAPI(server string, endpoint string, query url.Values, data interface{}) error {
+	if server == "" {
+		server = defaultPrometheus
+	}
+	parts := strings.SplitN(server, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("prometheus should be namespace/service:port, got %s", server)
+	}
+	path := fmt.Sprintf("/api/v1/namespaces/%s/services/%s/proxy/api/v1/%s", parts[0], parts[1], endpoint)
+	if len(query) != 0 {
+		path += "?" + query.Encode()
+	}
+	var out, errout bytes.Buffer
+	cmd := KubectlCommand("get", "--raw", path)
+	cmd.Stdout = &out
+	cmd.Stderr = &errout
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s %s", err, errout.String())
+	}
+	var response struct {
+		Status string
+		Error  string
+		Data   json.RawMessage
+	}
+	err = json.Unmarshal(out.Bytes(), &response)
+	if err != nil {
+		return err
+	}
+	if response.Status != "success" {
+		return fmt.Errorf("%s", response.Error)
+	}
+	return json.Unmarshal(response.Data, data)
+}
+
+// scrapeCheckInterval is how often waitForScrape asks Prometheus about its
+// targets.
+const scrapeCheckInterval = 2 * time.Second
+
+// waitForScrape waits until Prometheus scraped the targets of the test pods
+// once more after since, or all of its healthy targets when none belongs to
+// a test pod, so the final samples of a run are collected. It gives up after
+// twice the longest scrape interval of those targets.
+func waitForScrape(server string, pods []Pod, since time.Time) error {
+	names := make(map[string]bool)
+	for _, pod := range pods {
+		names[pod.Metadata.Name] = true
+	}
+	deadline := time.Time{}
+	for {
+		var data struct {
+			ActiveTargets []struct {
+				Labels         map[string]string
+				Health         string
+				LastScrape     time.Time
+				ScrapeInterval string
+			}
+		}
+		err := prometheusAPI(server, "targets", url.Values{"state": {"active"}}, &data)
+		if err != nil {
+			return err
+		}
+		waiting, own := 0, false
+		longest := time.Minute
+		for _, target := range data.ActiveTargets {
+			if names[target.Labels["pod"]] {
+				own = true
+			}
+		}
+		for _, target := range data.ActiveTargets {
+			if own && !names[target.Labels["pod"]] || !own && target.Health != "up" {
+				continue
+			}
+			if interval, err := time.ParseDuration(target.ScrapeInterval); err == nil && interval > longest {
+				longest = interval
+			}
+			if target.LastScrape.Before(since) {
+				waiting++
+			}
+		}
+		if waiting == 0 {
+			return nil
+		}
+		if deadline.IsZero() {
+			deadline = time.Now().Add(2 * longest)
+			color.Blue("### Waiting for Prometheus to scrape %d targets", waiting)
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d targets weren't scraped after %s", waiting, 2*longest)
+		}
+		if !sleep(scrapeCheckInterval) {
+			return runContext.Err()
+		}
+	}
+}
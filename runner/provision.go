@@ -0,0 +1,239 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+)
+
+// jsonPatchOp is a single RFC 6902 operation for `kubectl patch --type=json`.
+type jsonPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// createdManifest is a manifest applied by the run, with where it was
+// applied.
+type createdManifest struct {
+	path   string
+	target kubeTarget
+}
+
+// createdManifests are the manifests applied by the run, to delete when it
+// is over.
+var createdManifests []createdManifest
+
+// provisionDeployment creates the config's deployment from its manifest if
+// it doesn't exist, then patches its pod template with its scheduling
+// constraints and entrypoint, and waits for the rollout to finish.
+// Deployments without any provisioning settings are left alone.
+func provisionDeployment(cfg *config.Config) error {
+	err := applyManifest(cfg)
+	if err != nil {
+		return err
+	}
+	var patch []jsonPatchOp
+	nodeSelector := make(map[string]string)
+	for key, value := range cfg.NodeSelector {
+		nodeSelector[key] = value
+	}
+	if cfg.Arch != "" {
+		nodeSelector["kubernetes.io/arch"] = cfg.Arch
+	}
+	if len(nodeSelector) != 0 {
+		patch = append(patch, jsonPatchOp{"add", "/spec/template/spec/nodeSelector", nodeSelector})
+	}
+	if cfg.Image != "" {
+		patch = append(patch, jsonPatchOp{"add", "/spec/template/spec/containers/0/image", cfg.Image})
+	}
+	if len(cfg.Command) != 0 {
+		patch = append(patch, jsonPatchOp{"add", "/spec/template/spec/containers/0/command", cfg.Command})
+	}
+	if len(cfg.Args) != 0 {
+		patch = append(patch, jsonPatchOp{"add", "/spec/template/spec/containers/0/args", cfg.Args})
+	}
+	if len(patch) == 0 {
+		return nil
+	}
+
+	deployment := cfg.Workload()
+	color.Cyan("## Provisioning %s", deployment)
+	encoded, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	err = configTarget(cfg).kubectl("patch", deployment, "--type=json", "-p", string(encoded))
+	if err != nil {
+		return fmt.Errorf("patching %s failed: %s", deployment, err)
+	}
+	err = configTarget(cfg).kubectl("rollout", "status", deployment)
+	if err != nil {
+		return fmt.Errorf("rollout of %s failed: %s", deployment, err)
+	}
+	return nil
+}
+
+// applyManifest applies the config's manifest when its deployment doesn't
+// exist yet and waits for the rollout.
+func applyManifest(cfg *config.Config) error {
+	if cfg.Manifest == "" {
+		return nil
+	}
+	deployment := cfg.Workload()
+	if configTarget(cfg).kubectl("get", deployment) == nil {
+		return nil
+	}
+	color.Cyan("## Creating %s from %s", deployment, cfg.Manifest)
+	err := configTarget(cfg).kubectl("apply", "-f", cfg.Manifest)
+	if err != nil {
+		return fmt.Errorf("applying %s failed: %s", cfg.Manifest, err)
+	}
+	if cfg.DeleteManifest {
+		createdManifests = append(createdManifests, createdManifest{cfg.Manifest, configTarget(cfg)})
+	}
+	err = configTarget(cfg).kubectl("rollout", "status", deployment)
+	if err != nil {
+		return fmt.Errorf("rollout of %s failed: %s", deployment, err)
+	}
+	return nil
+}
+
+// deleteManifests deletes what the run created from manifests with
+// delete_manifest.
+func deleteManifests() {
+	for _, manifest := range createdManifests {
+		color.Cyan("## Deleting what %s created", manifest.path)
+		err := manifest.target.kubectl("delete", "-f", manifest.path)
+		if err != nil {
+			color.Red("Failed to delete %s: %s", manifest.path, err)
+		}
+	}
+	createdManifests = nil
+}
+
+// Kubectl runs a kubectl command, returning its stderr as the error.
+func Kubectl(args ...string) error {
+	return KubectlWithInput(nil, args...)
+}
+
+// KubectlCommand prepares a kubectl command in the test namespace, killed
+// when the run stops.
+func KubectlCommand(args ...string) *exec.Cmd {
+	return kubectlCommandContext(runContext, args...)
+}
+
+func kubectlCommandContext(ctx context.Context, args ...string) *exec.Cmd {
+	return kubeTarget{}.command(ctx, args...)
+}
+
+// KubectlWithInput runs a kubectl command fed with input, e.g. a manifest
+// for `kubectl apply -f -`.
+func KubectlWithInput(input []byte, args ...string) error {
+	return kubeTarget{}.run(input, args...)
+}
+
+// kubeTarget is the kubeconfig context and namespace of a group living in
+// another cluster than the test's. Empty fields stand for the test's.
+type kubeTarget struct {
+	context   string
+	namespace string
+}
+
+func (t kubeTarget) command(ctx context.Context, args ...string) *exec.Cmd {
+	ns := t.namespace
+	if ns == "" {
+		ns = Namespace
+	}
+	if ns != "" {
+		args = append([]string{"--namespace=" + ns}, args...)
+	}
+	if t.context != "" {
+		args = append([]string{"--context=" + t.context}, args...)
+	}
+	return exec.CommandContext(ctx, "kubectl", args...)
+}
+
+func (t kubeTarget) kubectl(args ...string) error {
+	return t.run(nil, args...)
+}
+
+func (t kubeTarget) run(input []byte, args ...string) error {
+	errbuf := new(bytes.Buffer)
+	err := withRetries(args, func() (string, error) {
+		cmd := t.command(runContext, args...)
+		if input != nil {
+			cmd.Stdin = bytes.NewReader(input)
+		}
+		errbuf.Reset()
+		cmd.Stderr = errbuf
+		err := cmd.Run()
+		return errbuf.String(), err
+	})
+	if err != nil {
+		return fmt.Errorf("%s %s", err, errbuf.String())
+	}
+	return nil
+}
+
+// KubectlOutput runs kubectl and returns the words it printed.
+func KubectlOutput(args ...string) ([]string, error) {
+	var out bytes.Buffer
+	errbuf := new(bytes.Buffer)
+	err := withRetries(args, func() (string, error) {
+		cmd := KubectlCommand(args...)
+		out.Reset()
+		errbuf.Reset()
+		cmd.Stdout = &out
+		cmd.Stderr = errbuf
+		err := cmd.Run()
+		return errbuf.String(), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s %s", err, errbuf.String())
+	}
+	return strings.Fields(out.String()), nil
+}
+
+// podTargets remembers where the pods of groups bound to another context or
+// namespace live, so commands run on them by name go to the right cluster.
+var (
+	podTargets      = make(map[string]kubeTarget)
+	podTargetsMutex sync.Mutex
+)
+
+// registerPods records the target of pods. A pod name can only live in one
+// place, as commands find their pod by name.
+func registerPods(target kubeTarget, pods []Pod) error {
+	podTargetsMutex.Lock()
+	defer podTargetsMutex.Unlock()
+	for _, pod := range pods {
+		if known, ok := podTargets[pod.Metadata.Name]; ok && known != target {
+			return fmt.Errorf("pod %s exists in two clusters or namespaces", pod.Metadata.Name)
+		}
+		podTargets[pod.Metadata.Name] = target
+	}
+	return nil
+}
+
+// configTarget returns where the pods of a config live.
+func configTarget(cfg *config.Config) kubeTarget {
+	if cfg.Group == nil {
+		return kubeTarget{}
+	}
+	return kubeTarget{context: cfg.Group.Context, namespace: cfg.Group.Namespace}
+}
+
+// targetOf returns where a pod lives.
+func targetOf(pod string) kubeTarget {
+	podTargetsMutex.Lock()
+	defer podTargetsMutex.Unlock()
+	return podTargets[pod]
+}
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// pushIteration sends the results of an iteration to a Prometheus
+// Pushgateway, grouped by test name and node count, so runs show up next to
+// the cluster metrics. Every push replaces the previous iteration's metrics.
+func pushIteration(gateway string, summary *report.Summary, iteration *report.IterationResult, pods []Pod) error {
+	outcomes := iteration.Outcomes()
+
+	var body bytes.Buffer
+	writeGauge(&body, "kubernetes_ipfs_iteration", "Index of the last finished iteration.")
+	fmt.Fprintf(&body, "kubernetes_ipfs_iteration %d\n", iteration.Index)
+	writeGauge(&body, "kubernetes_ipfs_successes", "Successful assertions in the iteration.")
+	fmt.Fprintf(&body, "kubernetes_ipfs_successes %d\n", outcomes.Successes)
+	writeGauge(&body, "kubernetes_ipfs_failures", "Failed assertions in the iteration.")
+	fmt.Fprintf(&body, "kubernetes_ipfs_failures %d\n", outcomes.Failures)
+	writeGauge(&body, "kubernetes_ipfs_timeouts", "Timeouts in the iteration.")
+	fmt.Fprintf(&body, "kubernetes_ipfs_timeouts %d\n", outcomes.Timeouts)
+	writeGauge(&body, "kubernetes_ipfs_errors", "Nodes kubectl couldn't run a step on in the iteration.")
+	fmt.Fprintf(&body, "kubernetes_ipfs_errors %d\n", outcomes.Errors)
+	writeGauge(&body, "kubernetes_ipfs_iteration_duration_seconds", "Duration of the iteration.")
+	fmt.Fprintf(&body, "kubernetes_ipfs_iteration_duration_seconds %s\n", promFloat(iteration.End.Sub(iteration.Start).Seconds()))
+	writeGauge(&body, "kubernetes_ipfs_step_duration_seconds", "Duration of each step in the iteration.")
+	for _, step := range iteration.Steps {
+		fmt.Fprintf(&body, "kubernetes_ipfs_step_duration_seconds{step=%s,index=\"%d\"} %s\n",
+			labelValue(step.Name), step.Index, promFloat(step.End.Sub(step.Start).Seconds()))
+	}
+
+	bandwidth := map[string][]report.Metric{}
+	for index, pod := range pods {
+		for _, metric := range sampleNode(index+1, pod.Metadata.Name, map[string]bool{"bandwidth": true}) {
+			bandwidth[metric.Name] = append(bandwidth[metric.Name], metric)
+		}
+	}
+	for _, name := range []string{"observe_total_in_bytes", "observe_total_out_bytes"} {
+		metric := "kubernetes_ipfs_" + strings.TrimPrefix(name, "observe_")
+		writeGauge(&body, metric, "Bytes transferred by each node since its daemon started.")
+		for _, sample := range bandwidth[name] {
+			fmt.Fprintf(&body, "%s{node=\"%d\"} %s\n", metric, sample.Node, promFloat(sample.Value))
+		}
+	}
+
+	url := strings.TrimSuffix(gateway, "/") + "/metrics/job/kubernetes-ipfs/test@base64/" +
+		base64.RawURLEncoding.EncodeToString([]byte(summary.Name)) + "/nodes/" + strconv.Itoa(summary.Nodes)
+	req, err := http.NewRequest(http.MethodPut, url, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; version=0.0.4")
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		out, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("pushgateway replied %s: %s", resp.Status, out)
+	}
+	return nil
+}
+
+func writeGauge(body *bytes.Buffer, name string, help string) {
+	fmt.Fprintf(body, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+func promFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// labelValue quotes a label value for the Prometheus text format.
+func labelValue(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+	s = strings.Replace(s, "\n", `\n`, -1)
+	return `"` + s + `"`
+}
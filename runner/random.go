@@ -0,0 +1,16 @@
+package runner
+
+import (
+	"fmt"
+	"hash/fnv"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// stepSeed derives the SEED variable of a step from the run's seed, so every
+// step of every iteration gets its own but reproducible one.
+func stepSeed(seed int64, iteration *report.IterationResult, step int) string {
+	hash := fnv.New64a()
+	fmt.Fprintf(hash, "%d/%s/%d", seed, iteration, step)
+	return fmt.Sprint(hash.Sum64() >> 1)
+}
@@ -0,0 +1,85 @@
+package runner
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// handleReplicasStep checks on every node of the step whether it has the CID
+// of a replicas step, each node's output telling whether it does, then
+// asserts how many do. The assertion names the nodes missing the CID, and
+// goes to a result of its own, like a PromQL step's, without a node.
+func handleReplicasStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	replicas := step.Replicas
+	cid := config.ExpandEnv(replicas.CID, env)
+	check, have := "ipfs pin ls "+ShellQuote(cid), "pinned"
+	if replicas.Blocks {
+		check, have = "ipfs block stat --offline "+ShellQuote(cid), "stored"
+	}
+	color.Blue("### Checking that %s is %s on nodes %d to %d", cid, have, step.OnNode, step.EndNode)
+	cmd := check + " > /dev/null && echo " + have + " || echo missing"
+	color.Magenta("$ %s", cmd)
+
+	outputs := make(chan *report.NodeResult, step.EndNode-step.OnNode+1)
+	parallel := newLimiter(step.MaxParallel)
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		go func(node int, name string) {
+			parallel.acquire()
+			defer parallel.release()
+			command := podCommand(name, cmd, env, step.Timeout)
+			out, timedOut := runPodCommand(command)
+			outputs <- &report.NodeResult{Node: node, Pod: name, Output: out, TimedOut: timedOut, Retries: command.retries, Error: command.execErr}
+		}(j, pods.Items[j-1].Metadata.Name)
+	}
+	var present int
+	var missing []*report.NodeResult
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		nodeResult := <-outputs
+		result.Nodes = append(result.Nodes, nodeResult)
+		switch {
+		case nodeResult.TimedOut:
+			summary.Timeouts++
+			result.Timeouts++
+			missing = append(missing, nodeResult)
+		case nodeResult.Error != "":
+			color.Red("### kubectl couldn't run the step on node %d: %s", nodeResult.Node, nodeResult.Error)
+			summary.Errors++
+			result.Errors++
+			missing = append(missing, nodeResult)
+		case len(nodeResult.Output) != 0 && strings.TrimSpace(nodeResult.Output[0]) == have:
+			present++
+		default:
+			missing = append(missing, nodeResult)
+		}
+	}
+	sort.Slice(missing, func(a, b int) bool { return missing[a].Node < missing[b].Node })
+
+	all := step.EndNode - step.OnNode + 1
+	count := config.LineCount{Exact: &all}
+	if replicas.Count != nil {
+		count = *replicas.Count
+	}
+	actual := fmt.Sprintf("%s on %d nodes", have, present)
+	if len(missing) != 0 {
+		var nodes []string
+		for _, node := range missing {
+			nodes = append(nodes, strconv.Itoa(node.Node)+" ("+node.Pod+")")
+		}
+		actual += ", missing on nodes " + strings.Join(nodes, ", ")
+		color.Red("### %s is missing on nodes %s", cid, strings.Join(nodes, ", "))
+	}
+	assertion := report.AssertionResult{
+		Expected: have + " on " + strings.TrimSuffix(count.String(), " lines") + " nodes",
+		Actual:   actual,
+		Passed:   count.Matches(present),
+	}
+	result.Nodes = append(result.Nodes, &report.NodeResult{Output: []string{actual}, Assertions: []report.AssertionResult{assertion}})
+	recordAssertion(assertion, summary, result)
+	return env
+}
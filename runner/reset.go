@@ -0,0 +1,141 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// gcRepoCmd unpins everything, empties the MFS root and collects the garbage
+// of a node's repo, leaving the daemon running.
+const gcRepoCmd = "for cid in $(ipfs pin ls --type=recursive -q); do ipfs pin rm $cid > /dev/null; done; " +
+	"ipfs files ls / | while IFS= read -r f; do ipfs files rm -r \"/$f\"; done; " +
+	"ipfs repo gc > /dev/null && echo ok"
+
+// wipeRepoCmd deletes the blocks and datastore of a node's repo, keeping its
+// config and keys, so it comes back with its identity and settings but an
+// empty repo once its daemon restarts. A daemon that is the main process of
+// its container can't be stopped without the container restarting, so the
+// repo is deleted under it, to be created again when it restarts; others are
+// stopped first.
+const wipeRepoCmd = "repo=${IPFS_PATH:-~/.ipfs}; if [ \"$(cat /proc/1/comm)\" != ipfs ]; then " +
+	"ipfs shutdown; while [ -e \"$repo/api\" ]; do sleep 0.5; done; fi; " +
+	"rm -rf \"$repo/blocks\" \"$repo/datastore\" && echo ok"
+
+// resetRepos resets the repos of pods, with gc or wipe, and returns the
+// nodes it couldn't reset, starting from node first.
+func resetRepos(cfg *config.Config, pods []Pod, first int, mode string) []*report.NodeResult {
+	cmd := gcRepoCmd
+	if mode == "wipe" {
+		cmd = wipeRepoCmd
+	}
+	results := make([]*report.NodeResult, len(pods))
+	done := make(chan int, len(pods))
+	for index, pod := range pods {
+		go func(index int, pod Pod) {
+			out, timedOut := RunInPod(pod.Metadata.Name, cmd, nil, 120)
+			results[index] = &report.NodeResult{Node: first + index, Pod: pod.Metadata.Name, Output: out, TimedOut: timedOut}
+			done <- index
+		}(index, pod)
+	}
+	var wiped []Pod
+	for range pods {
+		index := <-done
+		out := results[index].Output
+		if len(out) == 0 || strings.TrimSpace(out[len(out)-1]) != "ok" {
+			results[index].Error = fmt.Sprintf("could not %s the repo: %s", mode, strings.Join(out, "\n"))
+			color.Red("Node %d: %s", results[index].Node, results[index].Error)
+		} else if mode == "wipe" {
+			wiped = append(wiped, pods[index])
+		}
+	}
+	if len(wiped) != 0 {
+		err := restartDaemons(cfg, wiped)
+		if err != nil {
+			color.Red("Could not restart the daemons after wiping their repos: %s", err)
+			for _, result := range results {
+				if result.Error == "" {
+					result.Error = err.Error()
+				}
+			}
+		}
+	}
+	return results
+}
+
+// handleResetStep resets the repos of the step's nodes, which fail the step
+// when they can't be reset.
+func handleResetStep(pods GetPodsOutput, fleet *Fleet, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	color.Blue("### Resetting the repos of nodes %d to %d with %s", step.OnNode, step.EndNode, step.ResetRepo)
+	cfg := fleet.Config
+	for _, group := range fleet.Config.Groups {
+		if group.Name == step.OnGroup {
+			cfg = group.Config()
+		}
+	}
+	reset := pods.Items[step.OnNode-1 : step.EndNode]
+	results := resetRepos(cfg, reset, step.OnNode, step.ResetRepo)
+	if step.ResetRepo == "wipe" {
+		reconnectWiped(fleet, reset, results)
+	}
+	for _, nodeResult := range results {
+		result.Nodes = append(result.Nodes, nodeResult)
+		if nodeResult.Error != "" {
+			summary.Failures++
+			result.Failures++
+		}
+	}
+	return env
+}
+
+// reconnectWiped connects the nodes whose repo was wiped to their peers in
+// the topology of the test again, as their daemons restarted, recording it
+// in their results when it fails.
+func reconnectWiped(fleet *Fleet, pods []Pod, results []*report.NodeResult) {
+	var wiped []Pod
+	for i, result := range results {
+		if result.Error == "" {
+			wiped = append(wiped, pods[i])
+		}
+	}
+	err := fleet.reconnect(wiped)
+	if err != nil {
+		color.Red("Could not reconnect the wiped nodes: %s", err)
+		for _, result := range results {
+			if result.Error == "" {
+				result.Error = err.Error()
+			}
+		}
+	}
+}
+
+// resetFleet resets the repos of every node of the fleet before an
+// iteration, with the reset_repo of the test, and returns how many nodes it
+// couldn't reset.
+func resetFleet(fleet *Fleet, mode string) int {
+	color.Cyan("## Resetting the repos of the nodes with %s", mode)
+	var results []*report.NodeResult
+	if fleet.Config.Selector != "" {
+		pods := fleet.Pods.Items
+		if fleet.Config.Nodes < len(pods) {
+			pods = pods[:fleet.Config.Nodes]
+		}
+		results = append(results, resetRepos(fleet.Config, pods, 1, mode)...)
+	}
+	for _, group := range fleet.Config.Groups {
+		results = append(results, resetRepos(group.Config(), fleet.Groups[group.Name].Items, 1, mode)...)
+	}
+	if mode == "wipe" {
+		reconnectWiped(fleet, fleet.testPods(), results)
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
@@ -0,0 +1,165 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// resourceSampler samples `kubectl top pods` in the background while an
+// iteration runs.
+type resourceSampler struct {
+	fleet   *Fleet
+	pods    []watchedPod
+	stop    chan struct{}
+	done    sync.WaitGroup
+	metrics []report.Metric
+}
+
+// sampleResources starts sampling the fleet's pods every interval until
+// the sampler is stopped.
+func sampleResources(fleet *Fleet, interval time.Duration) *resourceSampler {
+	s := &resourceSampler{fleet: fleet, pods: fleet.watched(), stop: make(chan struct{})}
+	s.done.Add(1)
+	go func() {
+		defer s.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			s.sample()
+			select {
+			case <-ticker.C:
+			case <-s.stop:
+				return
+			case <-runContext.Done():
+				return
+			}
+		}
+	}()
+	return s
+}
+
+// sample takes the usage of the pods of every group it can get it for. The
+// usage is kept by group, as groups may live in clusters or namespaces of
+// their own with pods of the same names.
+func (s *resourceSampler) sample() {
+	now := time.Now()
+	usage := make(map[string]map[string][2]float64)
+	for _, cfg := range s.fleet.configs() {
+		group := ""
+		if cfg.Group != nil {
+			group = cfg.Group.Name
+		}
+		usage[group] = make(map[string][2]float64)
+		err := topPods(configTarget(cfg), cfg.Selector, usage[group])
+		if err != nil {
+			color.Red("Failed to sample resources: %s", err)
+		}
+	}
+	for _, watched := range s.pods {
+		name := watched.pod.Metadata.Name
+		if used, ok := usage[watched.group][name]; ok {
+			s.metrics = append(s.metrics,
+				report.Metric{Time: now, Node: watched.node, Group: watched.group, Pod: name, Name: "cpu_millicores", Value: used[0]},
+				report.Metric{Time: now, Node: watched.node, Group: watched.group, Pod: name, Name: "memory_bytes", Value: used[1]},
+			)
+		}
+	}
+}
+
+// finish stops sampling and returns the usage of every node along with the
+// samples, as metrics.
+func (s *resourceSampler) finish() ([]report.ResourceUsage, []report.Metric) {
+	close(s.stop)
+	s.done.Wait()
+	var usages []report.ResourceUsage
+	for _, watched := range s.pods {
+		usage := report.ResourceUsage{Node: watched.node, Group: watched.group, Pod: watched.pod.Metadata.Name}
+		for _, metric := range s.metrics {
+			if metric.Group != usage.Group || metric.Pod != usage.Pod {
+				continue
+			}
+			switch metric.Name {
+			case "cpu_millicores":
+				usage.Samples++
+				usage.CPUAverage += metric.Value
+				if metric.Value > usage.CPUPeak {
+					usage.CPUPeak = metric.Value
+				}
+			case "memory_bytes":
+				usage.MemoryAverage += metric.Value
+				if metric.Value > usage.MemoryPeak {
+					usage.MemoryPeak = metric.Value
+				}
+			}
+		}
+		if usage.Samples != 0 {
+			usage.CPUAverage /= float64(usage.Samples)
+			usage.MemoryAverage /= float64(usage.Samples)
+			usages = append(usages, usage)
+		}
+	}
+	return usages, s.metrics
+}
+
+// topPods adds the CPU, in millicores, and memory, in bytes, of the pods
+// matching the selector to usage.
+func topPods(target kubeTarget, selector string, usage map[string][2]float64) error {
+	cmd := target.command(runContext, "top", "pods", "--no-headers", "--selector="+selector)
+	out := new(bytes.Buffer)
+	errout := new(bytes.Buffer)
+	cmd.Stdout = out
+	cmd.Stderr = errout
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("top pods error: %s %s", err, errout.String())
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		cpu, err := parseQuantity(fields[1])
+		if err != nil {
+			return err
+		}
+		memory, err := parseQuantity(fields[2])
+		if err != nil {
+			return err
+		}
+		usage[fields[0]] = [2]float64{cpu * 1000, memory}
+	}
+	return nil
+}
+
+// quantitySuffixes are the suffixes of Kubernetes quantities printed by
+// kubectl top.
+var quantitySuffixes = []struct {
+	suffix     string
+	multiplier float64
+}{
+	{"Ki", 1 << 10}, {"Mi", 1 << 20}, {"Gi", 1 << 30}, {"Ti", 1 << 40},
+	{"n", 1e-9}, {"u", 1e-6}, {"m", 1e-3}, {"k", 1e3}, {"M", 1e6}, {"G", 1e9}, {"T", 1e12},
+}
+
+// parseQuantity parses a Kubernetes quantity such as "250m" or "64Mi".
+func parseQuantity(s string) (float64, error) {
+	multiplier := 1.0
+	for _, q := range quantitySuffixes {
+		if strings.HasSuffix(s, q.suffix) {
+			s, multiplier = strings.TrimSuffix(s, q.suffix), q.multiplier
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid quantity %q", s)
+	}
+	return value * multiplier, nil
+}
@@ -0,0 +1,172 @@
+package runner
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// kubectlAttempts is how many times a kubectl command failing with a
+// transient error is tried before giving up.
+const kubectlAttempts = 5
+
+// transientErrors are messages of kubectl failing because of the API server
+// or the network to it, rather than because of what it was asked to do.
+var transientErrors = []string{
+	"Unable to connect to the server",
+	"the server is currently unable to handle the request",
+	"the server was unable to return a response in the time allotted",
+	"TLS handshake timeout",
+	"connection refused",
+	"connection reset by peer",
+	"i/o timeout",
+	"http2: client connection lost",
+	"etcdserver: request timed out",
+	"etcdserver: leader changed",
+	"Internal error occurred",
+	"Too many requests",
+}
+
+// unsentErrors are the transientErrors of requests that never reached the
+// API server, which are safe to send again whatever they ask for.
+var unsentErrors = []string{
+	"Unable to connect to the server",
+	"TLS handshake timeout",
+	"connection refused",
+	"Too many requests",
+}
+
+// idempotentVerbs are the kubectl commands that only read, or leave the
+// cluster as they found it when run twice, which any transient error
+// retries.
+var idempotentVerbs = map[string]bool{
+	"get":            true,
+	"describe":       true,
+	"logs":           true,
+	"top":            true,
+	"wait":           true,
+	"version":        true,
+	"api-resources":  true,
+	"api-versions":   true,
+	"cluster-info":   true,
+	"auth":           true,
+	"apply":          true,
+	"rollout status": true,
+}
+
+// transientExecErrors are kubectl exec failures that happen before the
+// command starts in the pod, so running it again doesn't run it twice. They
+// are only looked for at the start of stderr lines, where kubectl prints its
+// own errors.
+var transientExecErrors = []string{
+	"Unable to connect to the server",
+	"error: unable to upgrade connection",
+	"Error from server: error dialing backend",
+	"Error from server (ServiceUnavailable)",
+	"Error from server (InternalError)",
+	"Error from server (Timeout)",
+	"Error from server (TooManyRequests)",
+}
+
+// execErrors start the lines kubectl exec prints when it fails itself,
+// rather than the command it runs.
+var execErrors = append([]string{
+	"Error from server",
+	"error: Internal error occurred",
+	"error: error executing command in container",
+	"error: container not found",
+	"error: cannot exec into a container",
+}, transientExecErrors...)
+
+// kubectlExecError returns the error kubectl exec printed about itself, if
+// any, in the stderr of a command.
+func kubectlExecError(stderr string) string {
+	for _, line := range strings.Split(stderr, "\n") {
+		for _, message := range execErrors {
+			if strings.HasPrefix(line, message) {
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// kubectlRetries counts the kubectl commands retried during the run.
+var kubectlRetries int64
+
+// isTransient tells whether a kubectl command failed in a way worth trying
+// it again: any transient error for idempotent commands, only those of
+// requests that weren't sent for the others, like deleting a pod.
+func isTransient(args []string, stderr string) bool {
+	messages := unsentErrors
+	if idempotent(args) {
+		messages = transientErrors
+	}
+	for _, message := range messages {
+		if strings.Contains(stderr, message) {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotent tells whether the kubectl args are those of an idempotent
+// command, from their first words past the flags.
+func idempotent(args []string) bool {
+	var words []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			words = append(words, arg)
+		}
+	}
+	return len(words) != 0 && (idempotentVerbs[words[0]] || len(words) > 1 && idempotentVerbs[words[0]+" "+words[1]])
+}
+
+func isTransientExec(stderr string) bool {
+	for _, line := range strings.Split(stderr, "\n") {
+		for _, message := range transientExecErrors {
+			if strings.HasPrefix(line, message) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// retryBackoff is the wait before the given retry, doubling from a second.
+func retryBackoff(retry int) time.Duration {
+	return time.Second << uint(retry-1)
+}
+
+// withRetries runs the kubectl command of args until it succeeds, fails for
+// a reason other than a transient error, or was tried kubectlAttempts times.
+// run returns the stderr of the command along with its error.
+func withRetries(args []string, run func() (string, error)) error {
+	for attempt := 1; ; attempt++ {
+		stderr, err := run()
+		if err == nil || attempt == kubectlAttempts || !isTransient(args, stderr) {
+			return err
+		}
+		wait := retryBackoff(attempt)
+		atomic.AddInt64(&kubectlRetries, 1)
+		color.Yellow("kubectl failed: %s, retrying in %s", mask(strings.TrimSpace(stderr)), wait)
+		if !sleep(wait) {
+			return err
+		}
+	}
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// embedded tells whether the test runs through Run, whose caller gets the
+// failures as errors rather than the program exiting.
+var embedded bool
+
+// fatalError is a failure stopping a run started through Run.
+type fatalError string
+
+func (e fatalError) Error() string {
+	return string(e)
+}
+
+// Run runs a loaded test as the run command does, with the options its flags
+// would set, and returns the summary of the run. The error tells why the
+// test couldn't run, or that it didn't meet its expectations. Runs share the
+// package's state, so only one runs at a time.
+func Run(test config.Test, opts Options) (summary report.Summary, err error) {
+	if len(test.Matrix) != 0 {
+		return summary, fmt.Errorf("test %s has a matrix, which only the run command expands", test.Name)
+	}
+	if opts.Values == nil {
+		opts.Values = make(config.SetValues)
+	}
+	if opts.Defaults == nil {
+		opts.Defaults = new(Defaults)
+	}
+	if opts.Backend == "" {
+		opts.Backend = "kubernetes"
+	}
+	if opts.TimeoutScale == 0 {
+		opts.TimeoutScale = 1
+	}
+	embedded = true
+	defer func() {
+		embedded = false
+		if r := recover(); r != nil {
+			failure, ok := r.(fatalError)
+			if !ok {
+				panic(r)
+			}
+			err = failure
+		}
+	}()
+	opts.Prepare()
+	opts.prepareTest(&test)
+	summary, outcome := ExecuteTest(&test, &opts)
+	switch {
+	case summary.Aborted != "":
+		return summary, fmt.Errorf("test %s aborted: %s", test.Name, summary.Aborted)
+	case outcome != 0:
+		return summary, fmt.Errorf("test %s didn't meet its expectations", test.Name)
+	}
+	return summary, nil
+}
+
+// Recovering calls run and returns a failure stopping it as the error rather
+// than exiting, as Run does, for the commands that run tests over and over.
+func Recovering(run func()) (err error) {
+	embedded = true
+	defer func() {
+		embedded = false
+		if r := recover(); r != nil {
+			failure, ok := r.(fatalError)
+			if !ok {
+				panic(r)
+			}
+			err = failure
+		}
+	}()
+	run()
+	return nil
+}
+
+// ValidateRemote rejects what would let a test submitted to serve reach the
+// machine of the runner: run commands, read or write its files, read its
+// environment or make it send requests to other addresses.
+func ValidateRemote(test *config.Test) error {
+	cfg := &test.Config
+	switch {
+	case len(test.Include) != 0:
+		return fmt.Errorf("include reads files of the runner")
+	case len(cfg.EnvFromHost) != 0 || len(cfg.Secrets) != 0:
+		return fmt.Errorf("env_from_host and secrets read the environment of the runner")
+	case len(cfg.Helm) != 0:
+		return fmt.Errorf("helm installs charts and values files of the runner")
+	case cfg.Notify != nil || cfg.Prometheus != "":
+		return fmt.Errorf("notify and prometheus make the runner send requests")
+	}
+	if cfg.Manifest != "" {
+		return fmt.Errorf("manifest reads a file of the runner")
+	}
+	for _, group := range cfg.Groups {
+		if group.Manifest != "" {
+			return fmt.Errorf("group %s: manifest reads a file of the runner", group.Name)
+		}
+	}
+	for _, step := range test.Steps {
+		if step.WriteToFile != "" {
+			return fmt.Errorf("step %s: write_to_file writes a file of the runner", step.Name)
+		}
+		if step.Gateway != nil && step.Gateway.URL != "" {
+			return fmt.Errorf("step %s: a gateway url makes the runner send requests", step.Name)
+		}
+		for _, assertion := range step.Assertions {
+			if assertion.Command != "" {
+				return fmt.Errorf("step %s: command assertions run on the runner", step.Name)
+			}
+		}
+		if _, ok := stepTypes[step.Type]; step.Type != "" && !ok {
+			return fmt.Errorf("step %s: step type %s isn't built into the runner", step.Name, step.Type)
+		}
+		if _, ok := step.Args["local_file"]; ok {
+			return fmt.Errorf("step %s: local_file reads a file of the runner", step.Name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,1919 @@
+// Package runner runs kubernetes-ipfs tests on the nodes of a backend.
+// Programs and go test suites embed it through Run.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/dgrisham/kubernetes-ipfs/assert"
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// DEBUG decides if we should have debug output enabled or not
+var DEBUG = false
+
+// Pod is
+type Pod struct {
+	Metadata struct {
+		Name              string            `json:"name"`
+		UID               string            `json:"uid"`
+		Labels            map[string]string `json:"labels"`
+		CreationTimestamp time.Time         `json:"creationTimestamp"`
+		DeletionTimestamp *time.Time        `json:"deletionTimestamp"`
+	} `json:"metadata"`
+	Spec struct {
+		NodeName string `json:"nodeName"`
+	} `json:"spec"`
+	Status struct {
+		Phase      string `json:"phase"`
+		PodIP      string `json:"podIP"`
+		Conditions []struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"conditions"`
+		ContainerStatuses []struct {
+			Name         string `json:"name"`
+			RestartCount int    `json:"restartCount"`
+			LastState    struct {
+				Terminated *struct {
+					Reason     string    `json:"reason"`
+					FinishedAt time.Time `json:"finishedAt"`
+				} `json:"terminated"`
+			} `json:"lastState"`
+		} `json:"containerStatuses"`
+	} `json:"status"`
+}
+
+// GetPodsOutput is
+type GetPodsOutput struct {
+	Items []Pod `json:"items"`
+}
+
+// fatalHook runs before fatal exits, to report the failure.
+var fatalHook func(message string)
+
+// Fatal prints the failure and exits, or makes Run return it as an error.
+func Fatal(i interface{}) {
+	fmt.Fprintln(os.Stderr, mask(fmt.Sprint(i)))
+	if hook := fatalHook; hook != nil {
+		fatalHook = nil
+		hook(mask(fmt.Sprint(i)))
+	}
+	if embedded {
+		panic(fatalError(mask(fmt.Sprint(i))))
+	}
+	// Exiting skips the cleanup deferred by ExecuteTest.
+	cleanUp()
+	os.Exit(1)
+}
+
+// cleanUp deletes what the run created and uninstalls the releases it
+// installed, when it is over or stopped by a failure.
+func cleanUp() {
+	if runContext.Err() != nil {
+		// Tearing down needs kubectl again.
+		runContext = context.Background()
+	}
+	deleteManifests()
+	uninstallReleases()
+}
+
+// Options are the options of a run, which the flags of the run command set.
+type Options struct {
+	ReportFormat        string
+	ReportFile          string
+	Anonymize           bool
+	Pushgateway         string
+	Grafana             string
+	EventsOut           string
+	HTMLReport          string
+	OutputDir           string
+	BaselinePath        string
+	RegressionThreshold float64
+	WarnOnRegression    bool
+	Values              config.SetValues
+	Defaults            *Defaults
+	parameters          map[string]interface{}
+
+	// Overrides of the test's config
+	Nodes        int
+	Times        int
+	Selector     string
+	TimeoutScale float64
+
+	// Stop at the first failing step
+	FailFast bool
+
+	// Seed of the run's randomness, picked when 0
+	Seed int64
+
+	// Progress saved for --resume, and the progress resumed
+	StateFile string
+	Resume    bool
+	resumed   *runState
+
+	// Cancels the run, in watch mode
+	ctx   context.Context
+	Watch bool
+
+	// Pause before every step, with --step
+	Step    bool
+	stepper *stepper
+
+	// Tags selecting the steps to run
+	Tags     []string
+	SkipTags []string
+
+	// Where the nodes run, kubernetes, iptb or docker
+	Backend string
+
+	// Run a test submitted to serve, which doesn't see the environment of
+	// the runner
+	Remote bool
+}
+
+// Prepare completes the run's options with the defaults and checks them.
+func (opts *Options) Prepare() {
+	err := opts.Defaults.applyToRun(opts)
+	if err != nil {
+		Fatal(err)
+	}
+	if opts.ReportFormat != "" && opts.ReportFile == "" {
+		Fatal("--report requires --report-file")
+	}
+	backend, err = newBackend(opts.Backend)
+	if err != nil {
+		Fatal(err)
+	}
+	opts.setOverrideValues()
+	opts.Defaults.SetValues(opts.Values)
+	if opts.Resume {
+		if opts.StateFile == "" {
+			Fatal("--resume requires --state-file")
+		}
+		state, err := loadState(opts.StateFile)
+		if err != nil {
+			Fatal(err)
+		}
+		opts.resumed = state
+		opts.Seed = state.Seed
+	}
+	opts.Seed = config.SeedRun(opts.Seed)
+	opts.stepper = newStepper(opts.Step)
+}
+
+// LoadRunTest loads a test with the run's values, defaults and overrides.
+func LoadRunTest(filePath string, opts *Options) *config.Test {
+	test, err := config.LoadTest(filePath, opts.Values)
+	if err != nil {
+		Fatal(err)
+	}
+	opts.prepareTest(test)
+	return test
+}
+
+// prepareTest applies the defaults and overrides of the run to a loaded
+// test.
+func (opts *Options) prepareTest(test *config.Test) {
+	opts.Defaults.ApplyTo(&test.Config)
+	test.ApplyDefaultTimeout()
+	opts.override(test)
+	opts.selectSteps(test)
+}
+
+// ExecuteTest runs a loaded test, reports on it and returns its summary and
+// outcome, 0 when its expectations were met and 1 otherwise.
+func ExecuteTest(test *config.Test, opts *Options) (report.Summary, int) {
+	Namespace = test.Config.Namespace
+	if test.Config.Shell != "" {
+		shell = test.Config.Shell
+	}
+	execLimiter = newLimiter(test.Config.MaxParallel)
+	kubectlRate = newRateLimiter(test.Config.KubectlRate)
+	outputLimit = test.Config.OutputLimit
+	config.HostFallback = !opts.Remote
+	activeTopology = nil
+	outputDir = opts.OutputDir
+	var summary report.Summary
+	hostVariables, err := hostEnv(test.Config.EnvFromHost)
+	if err != nil {
+		Fatal(err)
+	}
+	secretVariables, err := secretEnv(test.Config.Secrets)
+	if err != nil {
+		Fatal(err)
+	}
+	hostVariables = config.MergeVariables(hostVariables, secretVariables)
+
+	Debug("Configuration:")
+	debugSpew(test)
+
+	if test.Config.WorkloadKind == "daemonset" && test.Config.Nodes == 0 && !localBackend() {
+		// Without nodes, a DaemonSet's test runs on all of its pods.
+		running, err := getRunningPods(&test.Config)
+		if err != nil {
+			Fatal(err)
+		}
+		test.Config.Nodes = running
+	}
+	summary.Name = test.Name
+	summary.Parameters = opts.parameters
+	summary.Seed = opts.Seed
+	summary.Nodes = test.Config.Nodes
+	for _, group := range test.Config.Groups {
+		summary.Nodes += group.Nodes
+	}
+	summary.TestsToRun = test.Config.Times
+	summary.Start = time.Now()
+	atomic.StoreInt64(&kubectlRetries, 0)
+
+	err = ValidateTest(test)
+	if err != nil {
+		Fatal(err)
+	}
+	runContext = context.Background()
+	if opts.ctx != nil {
+		runContext = opts.ctx
+	}
+	if test.Config.GlobalTimeout != "" {
+		timeout, _ := config.ParseWait(test.Config.GlobalTimeout)
+		var cancel context.CancelFunc
+		runContext, cancel = context.WithTimeout(runContext, timeout)
+		defer cancel()
+	}
+	if test.Config.Notify != nil {
+		fatalHook = func(message string) {
+			summary.End = time.Now()
+			err := notify(test.Config.Notify, scrubSummary(&summary, mask), false, message)
+			if err != nil {
+				color.Red("Failed to send notification: %s", err)
+			}
+		}
+	}
+	var events *eventWriter
+	if opts.EventsOut != "" {
+		events, err = newEventWriter(opts.EventsOut)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+
+	// Under Run, a failure stopping the run unwinds through here.
+	defer cleanUp()
+	err = installReleases(test.Config.Helm)
+	if err != nil {
+		Fatal(err)
+	}
+	if test.Config.Selector != "" {
+		err = provisionDeployment(&test.Config)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+	for _, group := range test.Config.Groups {
+		err = provisionDeployment(group.Config())
+		if err != nil {
+			Fatal(fmt.Sprintf("group %s: %s", group.Name, err))
+		}
+	}
+
+	var testPods []Pod
+	// Variables of run scope, carried from one iteration to the next
+	var runEnv []string
+	abort := new(runAbort)
+	parallel := test.Config.ParallelIterations
+	if parallel < 1 {
+		parallel = 1
+	}
+	// Warm-up iterations run first and are left out of the summary.
+	warmup := test.Config.WarmupIterations
+	first := 0
+	state := opts.resumed
+	if state != nil {
+		if state.Test != test.Name || state.Steps != len(test.Steps) {
+			Fatal(fmt.Sprintf("%s holds the progress of another test", opts.StateFile))
+		}
+		color.Cyan("## Resuming after %d of %d iterations", state.Next, warmup+test.Config.Times)
+		first, runEnv = state.Next, state.RunEnv
+		summary.Start, summary.Iterations, summary.TestsRan = state.Summary.Start, state.Summary.Iterations, state.Summary.TestsRan
+		summary.Successes, summary.Failures, summary.Timeouts = state.Summary.Successes, state.Summary.Failures, state.Summary.Timeouts
+		summary.Metrics, summary.Logs = state.Summary.Metrics, state.Summary.Logs
+		summary.Mapping = state.Summary.Mapping
+		atomic.StoreInt64(&kubectlRetries, state.Summary.Retries)
+	}
+	mapping := newNodeMapping(summary.Mapping)
+	for first < warmup+test.Config.Times && runContext.Err() == nil && summary.Aborted == "" {
+		color.Cyan("## Running test '" + test.Name + "'")
+		if err != nil {
+			Fatal(err)
+		}
+
+		pods := new(GetPodsOutput)
+		if test.Config.Selector != "" || localBackend() {
+			pods, err = backend.Nodes(&test.Config)
+			if err != nil {
+				Fatal(err)
+			}
+		}
+		pods.Items = mapping.assign("", pods.Items, test.Config.Nodes)
+		testPods = pods.Items[:test.Config.Nodes]
+		groupPods := make(map[string]*GetPodsOutput)
+		for _, group := range test.Config.Groups {
+			groupPods[group.Name], err = backend.Nodes(group.Config())
+			if err != nil {
+				Fatal(fmt.Sprintf("group %s: %s", group.Name, err))
+			}
+			groupNodes := group.Nodes
+			if groupNodes == 0 {
+				groupNodes = len(groupPods[group.Name].Items)
+			}
+			groupPods[group.Name].Items = mapping.assign(group.Name, groupPods[group.Name].Items, groupNodes)
+			if group.Nodes != 0 {
+				groupPods[group.Name].Items = groupPods[group.Name].Items[:group.Nodes]
+			}
+			testPods = append(testPods, groupPods[group.Name].Items...)
+		}
+		summary.Mapping = mapping.served
+		if first == 0 && opts.resumed == nil {
+			err = setupNodes(test, testPods, opts.Seed)
+			if err != nil {
+				Fatal(err)
+			}
+		}
+		fleet := &Fleet{Config: &test.Config, Pods: pods, Groups: groupPods, Cluster: pods, Mapping: mapping}
+		if test.Config.ClusterSelector != "" {
+			fleet.Cluster, err = GetPodsBySelector(test.Config.ClusterSelector)
+			if err != nil {
+				Fatal(err)
+			}
+		}
+		nodes := len(testPods)
+		if parallel > 1 {
+			pods.Items = testPods
+			nodes /= parallel
+			color.Cyan("## Using %d nodes for each of %d parallel iterations", nodes, parallel)
+		} else {
+			color.Cyan("## Using " + strconv.Itoa(nodes) + " nodes for this test")
+		}
+
+		// Every iteration records its steps in a summary of its own, whose
+		// counts are added to the run's once the whole batch is over. A batch
+		// is either all warm-ups or all measured iterations.
+		measured := first >= warmup
+		start, end := 0, warmup
+		if measured {
+			start, end = warmup, warmup+test.Config.Times
+		}
+		if measured && first == warmup && warmup != 0 {
+			color.Cyan("## Warm-up done, measuring from now on")
+			summary.Start = time.Now()
+		}
+		batch := parallel
+		if first+batch > end {
+			batch = end - first
+		}
+		own := make([]*report.Summary, batch)
+		envs := make([][]string, batch)
+		var wg sync.WaitGroup
+		for k := 0; k < batch; k++ {
+			iteration := &report.IterationResult{Index: first - start + k + 1, Warmup: !measured}
+			own[k] = &report.Summary{Name: summary.Name, Iterations: []*report.IterationResult{iteration}}
+			env := config.MergeVariables(append([]string{}, hostVariables...), runEnv)
+			if k == 0 && state != nil && state.Partial != nil {
+				own[0] = state.Partial
+				iteration = own[0].Iterations[0]
+				iteration.Warmup = !measured
+				env = config.MergeVariables(append([]string{}, hostVariables...), state.Env)
+			}
+			if measured {
+				summary.Iterations = append(summary.Iterations, iteration)
+			}
+			// Iterations running one by one save their progress after every
+			// step, parallel ones after the batch.
+			var checkpoint func(env []string)
+			if opts.StateFile != "" && batch == 1 {
+				checkpoint = func(env []string) {
+					saved := summary
+					if measured {
+						saved.Iterations = saved.Iterations[:len(saved.Iterations)-1]
+					}
+					saveState(opts, test, &runState{Next: first, RunEnv: runEnv, Summary: saved, Partial: own[0], Env: env}, hostVariables)
+				}
+			}
+			wg.Add(1)
+			go func(k int, env []string) {
+				defer wg.Done()
+				iterationEvents := events
+				if !measured {
+					iterationEvents = nil
+				}
+				envs[k] = runIteration(test, opts, iterationFleet(fleet, k, parallel), nodes, own[k], env, iterationEvents, abort, checkpoint)
+			}(k, env)
+		}
+		wg.Wait()
+		state = nil
+		first += batch
+		summary.Aborted = abort.get()
+		summary.Mapping = mapping.served
+		// The next batch starts from the run variables of the last
+		// iteration, which is all of them when iterations run one by one.
+		runEnv = test.Config.Variables.Keep(envs[batch-1], config.ScopeRun)
+		if !measured {
+			if opts.StateFile != "" {
+				saveState(opts, test, &runState{Next: first, RunEnv: runEnv, Summary: summary}, hostVariables)
+			}
+			continue
+		}
+		for k, iteration := range own {
+			addIteration(&summary, iteration)
+			outcomes := iteration.Iterations[0].Outcomes()
+			events.emit("iteration_finished", map[string]interface{}{
+				"iteration": iteration.Iterations[0].Index,
+				"successes": outcomes.Successes,
+				"failures":  outcomes.Failures,
+				"timeouts":  outcomes.Timeouts,
+				"duration":  iteration.Iterations[0].End.Sub(iteration.Iterations[0].Start).Seconds(),
+			})
+			if opts.Pushgateway != "" {
+				err = pushIteration(opts.Pushgateway, &summary, iteration.Iterations[0], iterationFleet(fleet, k, parallel).Pods.Items)
+				if err != nil {
+					color.Red("Failed to push metrics: %s", err)
+				}
+			}
+		}
+		if opts.StateFile != "" {
+			saveState(opts, test, &runState{Next: first, RunEnv: runEnv, Summary: summary}, hostVariables)
+		}
+	}
+	fmt.Println(time.Now().String())
+	if opts.ctx != nil && opts.ctx.Err() != nil {
+		summary.Aborted = "cancelled"
+	} else if runContext.Err() != nil && summary.Aborted == "" {
+		summary.Aborted = "global_timeout of " + test.Config.GlobalTimeout + " exceeded"
+	}
+	if summary.Aborted != "" {
+		color.Red("Run aborted: %s", summary.Aborted)
+		// Tearing down needs kubectl again.
+		runContext = context.Background()
+	} else {
+		grace, _ := config.ParseWait(test.Config.GraceShutdown)
+		if test.Config.Observe != nil {
+			fmt.Println("Now observing nodes for " + grace.String() + " before shutdown...")
+			observe(test.Config.Observe, grace, testPods, &summary)
+		} else {
+			fmt.Println("Now waiting for " + grace.String() + " before shutdown...")
+			sleep(grace)
+		}
+		if test.Config.WaitForScrape {
+			err = waitForScrape(test.Config.Prometheus, testPods, time.Now())
+			if err != nil {
+				color.Red("Failed to wait for Prometheus to scrape: %s", err)
+			}
+		}
+	}
+	healPartitions()
+	cleanUp()
+	summary.End = time.Now()
+	summary.Retries = atomic.LoadInt64(&kubectlRetries)
+	summary.Phases = report.RunPhases(&summary)
+	summary.Transfers = report.RunTransfers(&summary)
+	summary.Metrics = append(summary.Metrics, report.Metric{Time: summary.End, Name: "duration_seconds", Value: summary.End.Sub(summary.Start).Seconds()})
+	if opts.Grafana != "" {
+		err = annotate(opts.Grafana, summary.Start, summary.End, "Test "+test.Name, "test")
+		if err != nil {
+			color.Red("Failed to annotate test on Grafana: %s", err)
+		}
+	}
+	var anon *anonymizer
+	reported := &summary
+	if len(secrets) != 0 {
+		reported = scrubSummary(reported, mask)
+	}
+	if opts.Anonymize {
+		anon = newAnonymizer()
+		anon.learnPods(&summary)
+		anon.learnClusterEndpoint()
+		reported = anon.summary(reported)
+	}
+	printSummary(summary, anon)
+	if opts.ReportFormat != "" {
+		err = report.WriteReport(opts.ReportFormat, opts.ReportFile, reported)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+	if opts.HTMLReport != "" {
+		err = report.WriteHTMLReport(opts.HTMLReport, reported)
+		if err != nil {
+			Fatal(err)
+		}
+	}
+	events.emit("run_finished", map[string]interface{}{
+		"successes": summary.Successes,
+		"failures":  summary.Failures,
+		"timeouts":  summary.Timeouts,
+		"duration":  summary.End.Sub(summary.Start).Seconds(),
+	})
+	events.Close()
+	outcome := evaluateOutcome(summary, test)
+	if summary.Aborted != "" {
+		outcome = 1
+	}
+	if opts.BaselinePath != "" {
+		baseline, err := report.LoadSummary(opts.BaselinePath)
+		if err != nil {
+			Fatal(err)
+		}
+		if report.CompareBaseline(baseline, &summary, opts.RegressionThreshold) && !opts.WarnOnRegression {
+			outcome = 1
+		}
+	}
+	if opts.StateFile != "" {
+		// The run is over, there is nothing left to resume.
+		os.Remove(opts.StateFile)
+	}
+	if test.Config.Notify != nil {
+		err = notify(test.Config.Notify, reported, outcome == 0, summary.Aborted)
+		if err != nil {
+			color.Red("Failed to send notification: %s", err)
+		}
+	}
+	return *reported, outcome
+}
+
+// runIteration runs the steps of an iteration on a fleet of the given number
+// of nodes. summary only holds this iteration, so that iterations running in
+// parallel don't count into each other.
+func runIteration(test *config.Test, opts *Options, fleet *Fleet, nodes int, summary *report.Summary, env []string, events *eventWriter, abort *runAbort, checkpoint func(env []string)) []string {
+	iteration := summary.Iterations[0]
+	// A resumed iteration has run some of its steps already.
+	done := len(iteration.Steps)
+	if done == 0 {
+		iteration.Start = time.Now()
+	}
+	if done == 0 && test.Config.ResetRepo != "" {
+		// The steps would measure what is left in the repos that weren't
+		// reset, which fails the iteration.
+		failed := resetFleet(fleet, test.Config.ResetRepo)
+		iteration.Errors += failed
+		summary.Errors += failed
+	}
+	if done == 0 && hasDuplicateBlocks(test) {
+		baselineDuplicates(fleet)
+	}
+	var watched []watchedPod
+	if test.Config.MonitorPods {
+		watched = fleet.watched()
+	}
+	var sampler *resourceSampler
+	if test.Config.SampleResources != "" {
+		interval, _ := config.ParseWait(test.Config.SampleResources)
+		sampler = sampleResources(fleet, interval)
+	}
+	var churn *churner
+	if test.Config.Churn != nil && !iteration.Warmup {
+		churn = startChurn(fleet, test.Config.Churn, opts.Seed+int64(iteration.Index), iteration.Index, events)
+	}
+	if hasDependencies(test.Steps) {
+		env = runGraph(test, opts, fleet, nodes, summary, env, events, abort)
+	} else {
+		for index, step := range test.Steps {
+			if index < done {
+				continue
+			}
+			if runContext.Err() != nil || abort.get() != "" {
+				break
+			}
+			env = runIterationStep(test, opts, fleet, nodes, summary, index, step, env, events, abort)
+			if checkpoint != nil && index+1 < len(test.Steps) {
+				checkpoint(env)
+			}
+		}
+	}
+	if churn != nil {
+		iteration.Churn = append(iteration.Churn, churn.finish()...)
+	}
+	finishStress(summary)
+	iteration.End = time.Now()
+	if sampler != nil {
+		var metrics []report.Metric
+		iteration.Resources, metrics = sampler.finish()
+		summary.Metrics = append(summary.Metrics, metrics...)
+	}
+	if test.Config.MonitorPods {
+		incidents, err := podIncidents(fleet, watched, iteration.Start)
+		if err != nil {
+			color.Red("Failed to check the pods after %s: %s", iteration, err)
+		}
+		for _, incident := range incidents {
+			color.Yellow("Pod %s of node %d: %s", incident.Pod, incident.Node, incident.Message)
+			events.emit("pod_incident", map[string]interface{}{
+				"iteration": iteration.Index,
+				"node":      incident.Node,
+				"pod":       incident.Pod,
+				"kind":      incident.Kind,
+				"message":   incident.Message,
+			})
+		}
+		iteration.Incidents = incidents
+	}
+	return env
+}
+
+// runIterationStep runs the step at index of the test as part of the
+// iteration recorded in summary, and returns the variables it leaves.
+func runIterationStep(test *config.Test, opts *Options, fleet *Fleet, nodes int, summary *report.Summary, index int, step config.Step, env []string, events *eventWriter, abort *runAbort) []string {
+	iteration := summary.Iterations[0]
+	if step.EndNode == 0 {
+		step.EndNode = step.OnNode
+	}
+	result := &report.StepResult{Index: index + 1, Name: step.Name, CMD: step.CMD, Tags: step.Tags, Phase: step.Phase, Start: time.Now()}
+	iteration.Steps = append(iteration.Steps, result)
+	if step.When != "" {
+		times := test.Config.Times
+		if iteration.Warmup {
+			times = test.Config.WarmupIterations
+		}
+		run, err := shouldRun(step.When, iteration.Index, times, iteration.Warmup, nodes, env)
+		if err != nil {
+			// The step didn't run, but not because the test said so.
+			color.Red("Could not evaluate when of step %s: %s", step.Name, err)
+			summary.Errors++
+			result.Errors++
+			result.Skipped = true
+			result.End = result.Start
+			return env
+		}
+		if !run {
+			color.Yellow("### Skipping step %s, %s doesn't hold", step.Name, step.When)
+			result.Skipped = true
+			result.End = result.Start
+			return env
+		}
+	}
+	switch opts.stepper.confirm(iteration, result.Index, &step, env) {
+	case stepSkip:
+		color.Yellow("### Skipping step %s", step.Name)
+		result.Skipped = true
+		result.End = result.Start
+		return env
+	case stepAbort:
+		abort.set(fmt.Sprintf("aborted before step %s of %s", step.Name, iteration))
+		result.Skipped = true
+		result.End = result.Start
+		return env
+	}
+	// Nodes killed on purpose are left to wait_for_reschedule.
+	if test.Config.WaitForReplacement != "" && len(fleet.Killed) == 0 {
+		wait, _ := config.ParseWait(test.Config.WaitForReplacement)
+		err := fleet.replaceChurned(time.Now().Add(wait))
+		if err != nil {
+			color.Red("Could not replace churned nodes before step %s: %s", step.Name, err)
+		}
+	}
+	events.emit("step_started", map[string]interface{}{"iteration": iteration.Index, "step": result.Index, "name": step.Name})
+	env = config.SetVariable(env, "SEED", stepSeed(opts.Seed, iteration, result.Index))
+	env = runStep(fleet, &step, summary, result, env)
+	env = test.Config.Variables.Keep(env, config.ScopeIteration, config.ScopeRun)
+	result.End = time.Now()
+	events.stepFinished(iteration.Index, result)
+	if opts.Grafana != "" {
+		err := annotate(opts.Grafana, result.Start, result.End, fmt.Sprintf("%s: step %d %s (%s)", test.Name, result.Index, step.Name, iteration), "step")
+		if err != nil {
+			color.Red("Failed to annotate step on Grafana: %s", err)
+		}
+	}
+	if (opts.FailFast || test.Config.FailFast) && !iteration.Warmup && stepFailed(&step, result) {
+		abort.set(fmt.Sprintf("fail fast after step %s of %s", step.Name, iteration))
+	}
+	return env
+}
+
+// Fleet holds the pods a test runs on during one iteration.
+type Fleet struct {
+	Config  *config.Config
+	Pods    *GetPodsOutput
+	Groups  map[string]*GetPodsOutput
+	Cluster *GetPodsOutput
+	// Killed holds the pods taken down by kill_node steps that have not been
+	// replaced yet.
+	Killed []KilledPod
+	// Mapping records the pods that take over nodes during the iteration.
+	Mapping *nodeMapping
+}
+
+// configs returns the config of the main pods, when the test has any, and of
+// every group.
+func (fleet *Fleet) configs() []*config.Config {
+	var configs []*config.Config
+	if fleet.Config.Selector != "" {
+		configs = append(configs, fleet.Config)
+	}
+	for _, group := range fleet.Config.Groups {
+		configs = append(configs, group.Config())
+	}
+	return configs
+}
+
+// resolve returns the pods from on_node to end_node of a group, or of the
+// main pods when group is empty. Without on_node, the whole set is returned.
+func (fleet *Fleet) resolve(group string, onNode int, endNode int) ([]Pod, error) {
+	pods := fleet.Pods
+	if group != "" {
+		pods = fleet.Groups[group]
+		if pods == nil {
+			return nil, fmt.Errorf("unknown group %s", group)
+		}
+	}
+	if onNode == 0 {
+		return pods.Items, nil
+	}
+	if endNode == 0 {
+		endNode = onNode
+	}
+	if onNode < 1 || endNode > len(pods.Items) || onNode > endNode {
+		return nil, fmt.Errorf("nodes %d to %d are out of range, %d nodes available", onNode, endNode, len(pods.Items))
+	}
+	return pods.Items[onNode-1 : endNode], nil
+}
+
+// runStep resolves the nodes a step targets and hands it to the handler for
+// its type.
+func runStep(fleet *Fleet, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	if step.Lock != "" {
+		err := acquireLock(step.Lock)
+		if err != nil {
+			color.Red("Could not take lock %s for step %s: %s", step.Lock, step.Name, err)
+			return env
+		}
+		defer releaseLock(step.Lock)
+	}
+	if step.StdinFrom != "" || step.StdinFromStep != "" {
+		step.CMD = stdinCommand(step, summary) + " | " + step.CMD
+	}
+	pods := fleet.Pods
+	if step.OnGroup != "" {
+		pods = fleet.Groups[step.OnGroup]
+		if step.OnNode == 0 {
+			step.OnNode, step.EndNode = 1, len(pods.Items)
+		}
+	} else if step.OnNode == 0 && step.TargetsNodes() {
+		step.OnNode, step.EndNode = 1, len(pods.Items)
+		if fleet.Config.Nodes < step.EndNode {
+			step.EndNode = fleet.Config.Nodes
+		}
+	}
+	switch {
+	case step.Wait != "":
+		wait, _ := config.ParseWait(step.Wait)
+		color.Blue("### Waiting %s", wait)
+		sleep(wait)
+		return env
+	case step.PromQL != "":
+		return handlePromQLStep(fleet.Config, step, summary, result, env)
+	case config.IsClusterStep(step):
+		return handleClusterStep(*fleet.Cluster, step, summary, result, env)
+	case step.Shape != nil || step.ShapeReset:
+		return handleShapeStep(*pods, fleet, step, summary, result, env)
+	case step.Partition != nil || step.Heal != "":
+		return handlePartitionStep(fleet, step, summary, result, env)
+	case step.Replicas != nil:
+		return handleReplicasStep(*pods, step, summary, result, env)
+	case step.BitswapLedgers:
+		return handleLedgerStep(*pods, step, summary, result, env)
+	case step.DuplicateBlocks != nil:
+		return handleDuplicateBlocksStep(*pods, step, summary, result, env)
+	case step.Stress != nil:
+		return handleStressStep(*pods, step, summary, result, env)
+	case step.Latency != nil:
+		return handleLatencyStep(*pods, step, summary, result, env)
+	case step.Poll != nil:
+		return handlePollStep(*pods, step, summary, result, env)
+	case step.ResetRepo != "":
+		return handleResetStep(*pods, fleet, step, summary, result, env)
+	case step.KillNode != "":
+		return handleKillStep(*pods, fleet, step, result, env)
+	case step.WaitForReschedule:
+		return handleRescheduleStep(fleet, step, summary, result, env)
+	default:
+		return handleStep(*pods, step, summary, result, env)
+	}
+}
+
+// stdinCommand returns the shell command printing what a step reads on
+// stdin: a variable of the step environment, or the output of the latest
+// run of an earlier step in this iteration, node after node.
+func stdinCommand(step *config.Step, summary *report.Summary) string {
+	if step.StdinFrom != "" {
+		return "printf '%s\\n' \"$" + step.StdinFrom + "\""
+	}
+	var lines []string
+	iteration := summary.Iterations[len(summary.Iterations)-1]
+	// The last step result is the one of the step itself.
+	for i := len(iteration.Steps) - 2; i >= 0; i-- {
+		if iteration.Steps[i].Name != step.StdinFromStep {
+			continue
+		}
+		nodes := append([]*report.NodeResult(nil), iteration.Steps[i].Nodes...)
+		sort.Slice(nodes, func(a, b int) bool { return nodes[a].Node < nodes[b].Node })
+		for _, node := range nodes {
+			lines = append(lines, node.Output...)
+		}
+		break
+	}
+	return "printf '%s\\n' " + ShellQuote(strings.Join(lines, "\n"))
+}
+
+func handleStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	color.Blue("### Running step %s on nodes %d to %d", step.Name, step.OnNode, step.EndNode)
+	if len(step.Inputs) != 0 {
+		for _, input := range step.Inputs {
+			color.Blue("### Getting variable " + input)
+		}
+	}
+	if step.Op != "" {
+		color.Magenta("$ %s %v", step.Op, mask(fmt.Sprint(step.Args)))
+	} else if step.Type != "" {
+		color.Magenta("$ %s %v", step.Type, mask(fmt.Sprint(step.Args)))
+	} else if step.Gateway != nil {
+		color.Magenta("$ GET %s%s", step.Gateway.CID, step.Gateway.Path)
+	} else {
+		color.Magenta("$ %s", mask(step.CMD))
+	}
+	endNode := step.EndNode
+	numNodes := endNode - step.OnNode + 1
+	color.Magenta("Running parallel on %d nodes.", numNodes)
+
+	// Initialize a channel with depth of number of nodes we're testing on simultaneously
+	outputs := make(chan *report.NodeResult, numNodes)
+	// With max_parallel, a node only starts once an earlier one has handed
+	// in its result.
+	parallel := newLimiter(step.MaxParallel)
+	iteration := summary.Iterations[len(summary.Iterations)-1]
+	// The nodes start while the results of the earlier ones save their
+	// variables, which must not show up in the environment of later nodes.
+	nodeEnv := append([]string(nil), env...)
+	go func() {
+		for j := step.OnNode; j <= endNode; j++ {
+			parallel.acquire()
+			// Hand this channel to the pod runner and let it fill the queue
+			if step.Op != "" {
+				runOpAsync(j, pods.Items[j-1], step, nodeEnv, outputs)
+			} else if step.Type != "" {
+				runTypeAsync(j, pods.Items[j-1], step, nodeEnv, outputs)
+			} else if step.Gateway != nil {
+				runGatewayAsync(j, pods.Items[j-1], step, nodeEnv, outputs)
+			} else {
+				name := pods.Items[j-1].Metadata.Name
+				runInPodAsync(j, stepCommand(name, step, j, nodeEnv), newOutputCapture(iteration, result.Index, j), outputs)
+			}
+		}
+	}()
+	// Iterate through the queue to pull out results one-by-one
+	// These may be out of order, but is there a better way to do this? Do we need them in order?
+	for j := step.OnNode; j <= endNode; j++ {
+		nodeResult := <-outputs
+		parallel.release()
+		env = handleNodeResult(step, nodeResult, summary, result, iteration, env)
+	}
+	return env
+}
+
+// handleNodeResult records the result of a node, checks its exit code and
+// assertions, and saves its outputs to the step environment.
+func handleNodeResult(step *config.Step, nodeResult *report.NodeResult, summary *report.Summary, result *report.StepResult, iteration *report.IterationResult, env []string) []string {
+	result.Nodes = append(result.Nodes, nodeResult)
+	out := nodeResult.Output
+	if nodeResult.DroppedLines != 0 {
+		color.Yellow("### Output of node %d exceeds output_limit, %d lines in its middle were dropped", nodeResult.Node, nodeResult.DroppedLines)
+	}
+	if nodeResult.TimedOut {
+		summary.Timeouts++
+		result.Timeouts++
+		return env // skip handling the output or other assertions since it timed out.
+	}
+	if nodeResult.Error != "" {
+		color.Red("### kubectl couldn't run the step on node %d: %s", nodeResult.Node, nodeResult.Error)
+		summary.Errors++
+		result.Errors++
+		return env
+	}
+	if step.Op == "" {
+		expectExitCode := 0
+		if step.ExpectExitCode != nil {
+			expectExitCode = *step.ExpectExitCode
+		}
+		if nodeResult.ExitCode != expectExitCode {
+			assertion := report.AssertionResult{
+				Expected: fmt.Sprintf("exit code %d", expectExitCode),
+				Actual:   fmt.Sprintf("exit code %d", nodeResult.ExitCode),
+			}
+			nodeResult.Assertions = append(nodeResult.Assertions, assertion)
+			recordAssertion(assertion, summary, result)
+		}
+		for _, assertion := range assert.EvaluateStderrAssertions(step.StderrAssertions, nodeResult.Stderr) {
+			nodeResult.Assertions = append(nodeResult.Assertions, assertion)
+			recordAssertion(assertion, summary, result)
+		}
+	} else if nodeResult.HTTPStatus != 0 || nodeResult.ExitCode != 0 {
+		// The node refused the op, e.g. pinning a CID it can't find.
+		actual := fmt.Sprintf("exit code %d", nodeResult.ExitCode)
+		if nodeResult.HTTPStatus != 0 {
+			actual = fmt.Sprintf("HTTP status %d", nodeResult.HTTPStatus)
+		}
+		assertion := report.AssertionResult{Expected: "op " + step.Op + " succeeding", Actual: actual}
+		nodeResult.Assertions = append(nodeResult.Assertions, assertion)
+		recordAssertion(assertion, summary, result)
+	}
+	if step.Gateway != nil {
+		for _, assertion := range gatewayAssertions(step.Gateway, nodeResult.Node, nodeResult.Fields, env) {
+			nodeResult.Assertions = append(nodeResult.Assertions, assertion)
+			recordAssertion(assertion, summary, result)
+		}
+	}
+	if step.DuplicateBlocks != nil && step.DuplicateBlocks.MaxRatio != nil {
+		assertion := duplicateBlocksAssertion(*step.DuplicateBlocks.MaxRatio, nodeResult.Fields)
+		nodeResult.Assertions = append(nodeResult.Assertions, assertion)
+		recordAssertion(assertion, summary, result)
+	}
+	if len(step.WriteToFile) != 0 {
+		f, err := os.OpenFile(step.WriteToFile, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0664)
+		if err != nil {
+			color.Red("Failed to open output file: %s", err)
+		} else {
+			f.WriteString(mask(strings.Join(out, "\n")))
+		}
+	}
+	if len(step.Outputs) != 0 {
+		for _, output := range step.Outputs {
+			line, ok := nodeResult.Line(output.Line)
+			if output.FromStep != "" {
+				line, ok = assert.StepOutputLine(iteration, output.FromStep, nodeResult.Node, output.Line)
+			}
+			if !ok {
+				color.Red("Not enough lines in output to save line %d to %s. Skipping", output.Line, output.SaveTo)
+				continue
+			}
+			color.Magenta("### Saving output from line %d to variable %s: %s", output.Line, output.SaveTo, mask(line))
+			env = config.SaveVariable(env, output.SaveTo, nodeResult.Node, line)
+		}
+	}
+	if step.SaveAllTo != "" {
+		color.Magenta("### Saving output to variable %s: %d lines", step.SaveAllTo, len(out))
+		env = config.SaveVariable(env, step.SaveAllTo, nodeResult.Node, strings.Join(assert.OutputLines(out), "\n"))
+	}
+	if len(step.Save) != 0 {
+		fields := make([]string, 0, len(step.Save))
+		for field := range step.Save {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			value, ok := nodeResult.Fields[field]
+			if !ok {
+				color.Red("Step %s has no field %s. Skipping", step.Name, field)
+				continue
+			}
+			color.Magenta("### Saving field %s to variable %s: %s", field, step.Save[field], mask(value))
+			env = config.SaveVariable(env, step.Save[field], nodeResult.Node, value)
+		}
+	}
+	if len(step.Assertions) != 0 {
+		assertions, complete := assert.EvaluateAssertions(step.Assertions, nodeResult, env, iteration)
+		for _, assertion := range assertions {
+			recordAssertion(assertion, summary, result)
+		}
+		nodeResult.Assertions = append(nodeResult.Assertions, assertions...)
+		if !complete {
+			color.Red("Not enough lines in output.Skipping assertions")
+		}
+	}
+	return env
+}
+
+// recordAssertion prints the outcome of an assertion and counts it.
+func recordAssertion(assertion report.AssertionResult, summary *report.Summary, result *report.StepResult) {
+	if !assertion.Passed {
+		color.Set(color.FgRed)
+		fmt.Println("Assertion failed!")
+		fmt.Printf("Actual value=%s\n", mask(assertion.Actual))
+		fmt.Printf("Expected value=%s\n\n", mask(assertion.Expected))
+		color.Unset()
+		summary.Failures = summary.Failures + 1
+		result.Failures++
+	} else {
+		summary.Successes = summary.Successes + 1
+		result.Successes++
+		color.Green("Assertion Passed")
+	}
+}
+
+// ValidateTest checks the parts of a test that would otherwise only fail
+// halfway through a run.
+func ValidateTest(test *config.Test) error {
+	if test.Config.Selector == "" && (test.Config.Nodes != 0 || len(test.Config.Groups) == 0) {
+		return fmt.Errorf("config needs a selector")
+	}
+	err := test.Config.Variables.Validate()
+	if err != nil {
+		return err
+	}
+	if _, err := config.ParseWait(test.Config.GlobalTimeout); test.Config.GlobalTimeout != "" && err != nil {
+		return fmt.Errorf("invalid global_timeout: %s", err)
+	}
+	if _, err := config.ParseWait(test.Config.GraceShutdown); test.Config.GraceShutdown != "" && err != nil {
+		return fmt.Errorf("invalid grace_shutdown: %s", err)
+	}
+	if _, err := config.ParseWait(test.Config.WaitForReplacement); test.Config.WaitForReplacement != "" && err != nil {
+		return fmt.Errorf("invalid wait_for_replacement: %s", err)
+	}
+	if interval, err := config.ParseWait(test.Config.SampleResources); test.Config.SampleResources != "" && (err != nil || interval <= 0) {
+		return fmt.Errorf("invalid sample_resources: %s, expected a positive interval", test.Config.SampleResources)
+	}
+	if test.Config.WarmupIterations < 0 || test.Config.ParallelIterations < 0 {
+		return fmt.Errorf("warmup_iterations and parallel_iterations can't be negative")
+	}
+	if test.Config.ResetRepo != "" && test.Config.ResetRepo != "gc" && test.Config.ResetRepo != "wipe" {
+		return fmt.Errorf("reset_repo must be gc or wipe")
+	}
+	if test.Config.DefaultTimeout < 0 {
+		return fmt.Errorf("default_timeout can't be negative")
+	}
+	if churn := test.Config.Churn; churn != nil {
+		err := validateChurn(test, churn)
+		if err != nil {
+			return err
+		}
+	}
+	if topology := test.Config.Topology; topology != nil {
+		switch {
+		case !topologyShapes[topology.Shape]:
+			return fmt.Errorf("topology shape must be mesh, ring, star, random or explicit")
+		case topology.Shape == "random" && topology.Degree < 1:
+			return fmt.Errorf("a random topology needs a degree")
+		case topology.Shape == "explicit" && len(topology.Edges) == 0:
+			return fmt.Errorf("an explicit topology needs edges")
+		case topology.Center < 0:
+			return fmt.Errorf("topology center can't be negative")
+		// Checking the private network connects every node to the first.
+		case test.Config.PrivateNetwork:
+			return fmt.Errorf("topology can't be used with private_network")
+		}
+	}
+	err = validateParallel(test)
+	if err != nil {
+		return err
+	}
+	err = validateGraph(test)
+	if err != nil {
+		return err
+	}
+	err = backend.Validate(test)
+	if err != nil {
+		return err
+	}
+	nodes := test.Config.Nodes
+	if test.Config.ParallelIterations > 1 {
+		nodes /= test.Config.ParallelIterations
+	}
+	if !config.ValidWorkloadKind(test.Config.WorkloadKind) {
+		return fmt.Errorf("workload_kind must be deployment, statefulset or daemonset")
+	}
+	if _, err := os.Stat(test.Config.Manifest); test.Config.Manifest != "" && err != nil {
+		return fmt.Errorf("invalid manifest: %s", err)
+	}
+	for _, release := range test.Config.Helm {
+		if release.Release == "" || release.Chart == "" {
+			return fmt.Errorf("every helm release needs a release name and a chart")
+		}
+	}
+	groups := make(map[string]bool)
+	for _, group := range test.Config.Groups {
+		if group.Name == "" || group.Selector == "" {
+			return fmt.Errorf("every group needs a name and a selector")
+		}
+		if !config.ValidWorkloadKind(group.WorkloadKind) {
+			return fmt.Errorf("group %s: workload_kind must be deployment, statefulset or daemonset", group.Name)
+		}
+		if _, err := os.Stat(group.Manifest); group.Manifest != "" && err != nil {
+			return fmt.Errorf("group %s has an invalid manifest: %s", group.Name, err)
+		}
+		if groups[group.Name] {
+			return fmt.Errorf("group %s is defined twice", group.Name)
+		}
+		groups[group.Name] = true
+	}
+	previous := make(map[string]bool)
+	for _, step := range test.Steps {
+		if step.OnGroup != "" && !groups[step.OnGroup] {
+			return fmt.Errorf("step %s runs on unknown group %s", step.Name, step.OnGroup)
+		}
+		if _, ok := operations[step.Op]; step.Op != "" && !ok {
+			return fmt.Errorf("step %s uses unknown operation %s", step.Name, step.Op)
+		}
+		if step.Shape != nil && !step.ShapeReset {
+			if step.Shape.Netem() == "" {
+				return fmt.Errorf("step %s shapes traffic without latency, loss or rate", step.Name)
+			}
+			if step.Shape.ToGroup != "" && !groups[step.Shape.ToGroup] {
+				return fmt.Errorf("step %s shapes traffic to unknown group %s", step.Name, step.Shape.ToGroup)
+			}
+		}
+		if step.Poll != nil && (step.Poll.Timeout <= 0 || len(step.Assertions) == 0) {
+			return fmt.Errorf("step %s polls without a poll timeout or without assertions", step.Name)
+		}
+		if step.ResetRepo != "" && step.ResetRepo != "gc" && step.ResetRepo != "wipe" {
+			return fmt.Errorf("step %s: reset_repo must be gc or wipe", step.Name)
+		}
+		if step.ResetRepo != "" && (step.Op != "" || step.Type != "" || step.CMD != "") {
+			return fmt.Errorf("step %s resets repos instead of running an operation, a type or a cmd", step.Name)
+		}
+		if step.BitswapLedgers && (step.Op != "" || step.Type != "" || step.CMD != "") {
+			return fmt.Errorf("step %s collects bitswap ledgers instead of running an operation, a type or a cmd", step.Name)
+		}
+		if duplicates := step.DuplicateBlocks; duplicates != nil {
+			if duplicates.MaxRatio != nil && (*duplicates.MaxRatio < 0 || *duplicates.MaxRatio > 1) {
+				return fmt.Errorf("step %s: duplicate_blocks max_ratio must be between 0 and 1", step.Name)
+			}
+			if step.Op != "" || step.Type != "" || step.CMD != "" || step.BitswapLedgers {
+				return fmt.Errorf("step %s computes duplicate blocks instead of running an operation, a type or a cmd", step.Name)
+			}
+		}
+		if stress := step.Stress; stress != nil {
+			if duration, err := config.ParseWait(stress.Duration); err != nil || duration < time.Second {
+				return fmt.Errorf("step %s: stress needs a duration of at least a second", step.Name)
+			}
+			if !stress.Load() && len(stress.Limits) == 0 {
+				return fmt.Errorf("step %s: stress needs cpu, memory, io or limits", step.Name)
+			}
+			if stress.CPU < 0 || stress.IO < 0 || stress.CPULoad < 0 || stress.CPULoad > 100 {
+				return fmt.Errorf("step %s: stress cpu and io can't be negative, and cpu_load is a percentage", step.Name)
+			}
+			for resource, limit := range stress.Limits {
+				if _, err := parseQuantity(limit); (resource != "cpu" && resource != "memory") || err != nil {
+					return fmt.Errorf("step %s: stress limits are a cpu or memory quantity, got %s: %s", step.Name, resource, limit)
+				}
+			}
+			if step.Op != "" || step.Type != "" || step.CMD != "" {
+				return fmt.Errorf("step %s runs stress instead of an operation, a type or a cmd", step.Name)
+			}
+		}
+		if step.KillNode != "" && step.KillNode != "pod" && step.KillNode != "daemon" {
+			return fmt.Errorf("step %s: kill_node must be pod or daemon", step.Name)
+		}
+		if step.Lock != "" && !lockNameRegexp.MatchString(step.Lock) {
+			return fmt.Errorf("step %s has an invalid lock name %s, use lowercase letters, digits and dashes", step.Name, step.Lock)
+		}
+		if step.Partition != nil && (step.Partition.Name == "" || len(step.Partition.Sides) < 2) {
+			return fmt.Errorf("step %s needs a partition name and at least two sides", step.Name)
+		}
+		if step.Partition != nil {
+			for _, group := range test.Config.Groups {
+				if group.Remote() {
+					return fmt.Errorf("step %s partitions nodes, which NetworkPolicies can't do across clusters or namespaces like group %s's", step.Name, group.Name)
+				}
+			}
+		}
+		if step.StdinFromStep != "" && !previous[step.StdinFromStep] {
+			return fmt.Errorf("step %s reads stdin from step %s, which does not run before it", step.Name, step.StdinFromStep)
+		}
+		for _, output := range step.Outputs {
+			if output.FromStep != "" && !previous[output.FromStep] {
+				return fmt.Errorf("step %s saves output from step %s, which does not run before it", step.Name, output.FromStep)
+			}
+		}
+		for _, assertion := range step.Assertions {
+			if from := assertion.ShouldBeEqualToStep; from != nil && !previous[from.FromStep] {
+				return fmt.Errorf("step %s compares to the output of step %s, which does not run before it", step.Name, from.FromStep)
+			}
+		}
+		if (step.StdinFrom != "" || step.StdinFromStep != "") && (step.CMD == "" || step.StdinFrom != "" && step.StdinFromStep != "") {
+			return fmt.Errorf("step %s needs a cmd and only one of stdin_from and stdin_from_step", step.Name)
+		}
+		if step.MeasureTransfer && step.Op != "cat" {
+			return fmt.Errorf("step %s: measure_transfer only times op cat", step.Name)
+		}
+		if step.Op != "" && step.CMD != "" {
+			return fmt.Errorf("step %s has both an operation and a cmd", step.Name)
+		}
+		if step.Type != "" {
+			if step.Op != "" || step.CMD != "" || step.Poll != nil {
+				return fmt.Errorf("step %s has a type, which runs instead of an operation, a cmd or a poll", step.Name)
+			}
+			if _, err := lookupStepType(step.Type); err != nil {
+				return fmt.Errorf("step %s: %s", step.Name, err)
+			}
+		}
+		if latency := step.Latency; latency != nil {
+			if latency.Lookup() != "findprovs" && latency.Lookup() != "resolve" {
+				return fmt.Errorf("step %s: latency measure must be findprovs or resolve", step.Name)
+			}
+			if latency.Provider < 1 {
+				return fmt.Errorf("step %s: latency needs a provider node", step.Name)
+			}
+			// Nodes look the content up until they find it.
+			if step.Timeout <= 0 {
+				return fmt.Errorf("step %s measures latency without a timeout", step.Name)
+			}
+			if step.Op != "" || step.Type != "" || step.CMD != "" || step.Poll != nil || step.MaxParallel != 0 || step.ExpectExitCode != nil {
+				return fmt.Errorf("step %s measures latency instead of running an operation, a type or a cmd, on all of its nodes at once", step.Name)
+			}
+		}
+		if gateway := step.Gateway; gateway != nil {
+			if (gateway.CID == "") == (gateway.Path == "") {
+				return fmt.Errorf("step %s: gateway needs either a cid or a path", step.Name)
+			}
+			if _, err := config.ParseWait(gateway.MaxLatency); gateway.MaxLatency != "" && err != nil {
+				return fmt.Errorf("step %s: invalid gateway max_latency: %s", step.Name, err)
+			}
+			if step.Op != "" || step.Type != "" || step.CMD != "" || step.Poll != nil || step.Latency != nil {
+				return fmt.Errorf("step %s fetches from the gateway instead of running an operation, a type or a cmd", step.Name)
+			}
+		}
+		if replicas := step.Replicas; replicas != nil {
+			if replicas.CID == "" {
+				return fmt.Errorf("step %s: replicas needs a cid", step.Name)
+			}
+			if c := replicas.Count; c != nil && c.Exact == nil && c.Min == nil && c.Max == nil {
+				return fmt.Errorf("step %s: replicas count needs a number, min or max", step.Name)
+			}
+			if step.Op != "" || step.Type != "" || step.CMD != "" || step.Poll != nil || step.Latency != nil || step.Gateway != nil {
+				return fmt.Errorf("step %s checks replicas instead of running an operation, a type or a cmd", step.Name)
+			}
+		}
+		if _, err := config.ParseWait(step.Wait); step.Wait != "" && err != nil {
+			return fmt.Errorf("step %s has an invalid wait: %s", step.Name, err)
+		}
+		// The size of a DaemonSet without nodes is only known when it runs,
+		// and its steps may leave out on_node to run on all of its pods.
+		unknown := nodes == 0 && test.Config.WorkloadKind == "daemonset"
+		if step.OnGroup == "" && step.TargetsNodes() && !unknown && (step.OnNode < 1 || step.OnNode > nodes || step.EndNode > nodes) {
+			return fmt.Errorf("step %s runs on node %d, but the test only has %d nodes", step.Name, step.OnNode, nodes)
+		}
+		for _, assertion := range step.Assertions {
+			if on := assertion.ShouldBeEqualToVarOnNode; on != nil && (on.Var == "" || on.Node < 1) {
+				return fmt.Errorf("step %s: should_be_equal_to_var_on_node needs a var and a node", step.Name)
+			}
+			if c := assertion.ShouldHaveLines; c != nil && c.Exact == nil && c.Min == nil && c.Max == nil {
+				return fmt.Errorf("step %s: should_have_lines needs a number, min or max", step.Name)
+			}
+			if _, err := assert.ParseJQ(assertion.JQ); assertion.JQ != "" && err != nil {
+				return fmt.Errorf("step %s has an invalid jq assertion: %s", step.Name, err)
+			}
+			if assertion.Command != "" && (assertion.ShouldBeEqualTo != "" || assertion.ShouldNotBeEqualTo != "" || assertion.ShouldContain != "" ||
+				assertion.ShouldNotContain != "" || assertion.ShouldHaveLines != nil || assertion.JQ != "" ||
+				assertion.ShouldBeEqualToVarOnNode != nil || assertion.ShouldBeEqualToStep != nil) {
+				return fmt.Errorf("step %s: an assertion with a command doesn't compare as well", step.Name)
+			}
+		}
+		if _, err := assert.ParseJQ(step.When); step.When != "" && err != nil {
+			return fmt.Errorf("step %s has an invalid when: %s", step.Name, err)
+		}
+		if step.Raw {
+			words, err := config.SplitWords(step.CMD)
+			if err != nil {
+				return fmt.Errorf("step %s: %s", step.Name, err)
+			}
+			if len(words) == 0 {
+				return fmt.Errorf("step %s: raw needs a cmd", step.Name)
+			}
+			if step.Op != "" || step.Type != "" || step.StdinFrom != "" || step.StdinFromStep != "" {
+				return fmt.Errorf("step %s: raw only applies to cmd, without stdin_from", step.Name)
+			}
+		}
+		for _, assertion := range step.StderrAssertions {
+			if _, err := regexp.Compile(assertion.Matches); err != nil {
+				return fmt.Errorf("step %s has an invalid stderr pattern: %s", step.Name, err)
+			}
+		}
+		previous[step.Name] = true
+	}
+	return nil
+}
+
+// ensurePods makes sure enough pods matching the config are running, scaling
+// its deployment up if needed, and returns them.
+func ensurePods(cfg *config.Config) (*GetPodsOutput, error) {
+	// We'll check for running pods.
+	// In the event we ask the controller to scale, and the pods are just still starting
+	// e.g. If someone cancels the scale-up and restarts right after, then it'll just keep
+	// on doing the same thing.
+	running_nodes, err := getRunningPods(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Nodes > running_nodes && cfg.WorkloadKind == "daemonset" {
+		return nil, fmt.Errorf("only %d pods of %s are running, one per Kubernetes node, %d needed", running_nodes, cfg.Workload(), cfg.Nodes)
+	}
+	if cfg.Nodes > running_nodes {
+		fmt.Println("Not enough nodes running. Scaling up...")
+		err := ScaleTo(cfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	pods, err := getPods(cfg) // Get the pod list after a scale-up
+	if err != nil {
+		return nil, err
+	}
+	// Steps routed to a terminating or crash-looping pod would only fail.
+	pods.Items = readyPods(pods.Items)
+	if len(pods.Items) < cfg.Nodes {
+		return nil, fmt.Errorf("only %d pods found, %d needed", len(pods.Items), cfg.Nodes)
+	}
+	return pods, nil
+}
+
+func getPods(cfg *config.Config) (*GetPodsOutput, error) {
+	// Only return pods that match our deployment.
+	pods, err := getPodsIn(configTarget(cfg), cfg.Selector)
+	if err != nil {
+		return nil, err
+	}
+	err = registerPods(configTarget(cfg), pods.Items)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.WorkloadKind {
+	case "", "deployment":
+		// kubectl doesn't promise an order, so node numbers go to the
+		// oldest pods first.
+		sort.SliceStable(pods.Items, func(a, b int) bool {
+			ta, tb := pods.Items[a].Metadata.CreationTimestamp, pods.Items[b].Metadata.CreationTimestamp
+			if !ta.Equal(tb) {
+				return ta.Before(tb)
+			}
+			return pods.Items[a].Metadata.Name < pods.Items[b].Metadata.Name
+		})
+	case "statefulset":
+		// Node numbers follow the ordinals of a StatefulSet, node 1 being
+		// pod 0, which kubectl would sort as strings (pod-10 before pod-2).
+		sort.SliceStable(pods.Items, func(a, b int) bool {
+			return podOrdinal(pods.Items[a]) < podOrdinal(pods.Items[b])
+		})
+	case "daemonset":
+		// The pods of a DaemonSet are numbered after the Kubernetes nodes
+		// they run on.
+		sort.SliceStable(pods.Items, func(a, b int) bool {
+			return pods.Items[a].Spec.NodeName < pods.Items[b].Spec.NodeName
+		})
+	}
+	return pods, nil
+}
+
+// podOrdinal returns the ordinal at the end of the name of a StatefulSet's
+// pod.
+func podOrdinal(pod Pod) int {
+	name := pod.Metadata.Name
+	ordinal, err := strconv.Atoi(name[strings.LastIndex(name, "-")+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
+
+func GetPodsBySelector(selector string) (*GetPodsOutput, error) {
+	return getPodsIn(kubeTarget{}, selector)
+}
+
+func getPodsIn(target kubeTarget, selector string) (*GetPodsOutput, error) {
+	out := new(bytes.Buffer)
+	errout := new(bytes.Buffer)
+	args := []string{"get", "pods", "--output=json", "--selector=" + selector}
+	err := withRetries(args, func() (string, error) {
+		cmd := target.command(runContext, args...)
+		out.Reset()
+		errout.Reset()
+		cmd.Stdout = out
+		cmd.Stderr = errout
+		err := cmd.Run()
+		return errout.String(), err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("get pods error: %s %s %s", err, errout.String(), out.String())
+	}
+
+	pods := new(GetPodsOutput)
+	err = json.Unmarshal(out.Bytes(), pods)
+	if err != nil {
+		return nil, err
+	}
+
+	return pods, nil
+}
+
+func getRunningPods(cfg *config.Config) (int, error) {
+	pods, err := getPods(cfg)
+	if err != nil {
+		return 0, fmt.Errorf("%s\n", err)
+	}
+	current_number_running := 0
+	for _, pod := range pods.Items {
+		if podReady(pod) {
+			current_number_running++
+		}
+	}
+	return current_number_running, nil
+}
+
+// Scale the k8s deployment to the size required for the tests.
+func ScaleTo(cfg *config.Config) error {
+	if cfg.WorkloadKind == "daemonset" {
+		return fmt.Errorf("%s runs one pod per Kubernetes node and can't be scaled", cfg.Workload())
+	}
+	number := cfg.Nodes
+	fmt.Printf("Scaling in progress...\n")
+	cmd := configTarget(cfg).command(runContext, "scale", "--replicas="+strconv.Itoa(number), cfg.Workload())
+	errbuf := new(bytes.Buffer)
+	cmd.Stderr = errbuf
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf(errbuf.String())
+	}
+	// Wait until the pods are in "ready" state
+	number_running := 0
+	for number_running < number {
+		number_running, err = getRunningPods(cfg)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("\tContainers running (current/target): (%d/%d)\n", number_running, number)
+		time.Sleep(time.Duration(3) * time.Second)
+	}
+	fmt.Println("Scale complete")
+	return nil
+}
+
+func runInPodAsync(node int, command *podExec, out *outputCapture, results chan *report.NodeResult) {
+	go func() {
+		var errout bytes.Buffer
+		timedOut, exitCode := command.run(out, &errout)
+		var stderr []string
+		if errout.Len() != 0 {
+			stderr = strings.Split(strings.TrimRight(errout.String(), "\n"), "\n")
+		}
+		out.Close()
+		// Feed our output into the channel.
+		results <- &report.NodeResult{Node: node, Pod: command.pod, Output: out.lines(), DroppedLines: out.dropped, DroppedFrom: out.droppedFrom(), OutputFile: out.path,
+			Stderr: stderr, TimedOut: timedOut, ExitCode: exitCode, Retries: command.retries, Error: command.execErr}
+	}()
+}
+
+// shell runs the commands in the pods, "bash" unless the test sets one.
+var shell = "bash"
+
+// runContext ends when the whole run has to stop, which kills every command
+// still running.
+var runContext = context.Background()
+
+// sleep waits for d, or until the run stops, and reports whether it waited
+// all of d.
+func sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-runContext.Done():
+		return false
+	}
+}
+
+// podExec is a command to run in a pod, within a timeout in seconds.
+type podExec struct {
+	pod     string
+	args    []string
+	timeout int
+	// pidFile holds the PID of the shell running the command in the pod,
+	// to stop it there on timeout. Raw commands have none.
+	pidFile string
+	// retries counts the times kubectl exec failed before the command
+	// started and was tried again.
+	retries int
+	// execErr tells why kubectl couldn't run the command, when it couldn't.
+	execErr string
+}
+
+var podExecs uint64
+
+// podCommand prepares running cmdToRun in a pod with the step environment.
+// The variables are handed to env(1) as separate arguments rather than
+// spliced into the shell command, so values with quotes, newlines or other
+// shell syntax arrive unchanged. The command runs in a session of its own,
+// with setsid where the image has it, so stopping its process group stops
+// everything it started.
+func podCommand(name string, cmdToRun string, env []string, timeout int) *podExec {
+	pidFile := fmt.Sprintf("/tmp/kubernetes-ipfs-%d-%d.pid", os.Getpid(), atomic.AddUint64(&podExecs, 1))
+	args := []string{"exec", name, "-t", "--"}
+	if len(env) != 0 {
+		args = append(args, "env")
+		for _, e := range env {
+			found := config.EnvVarRegexp.FindStringSubmatch(e)
+			if len(found) == 3 {
+				args = append(args, found[1]+"="+found[2])
+			}
+		}
+	}
+	session := "if command -v setsid > /dev/null; then setsid \"$0\" -c \"$1\"; else \"$0\" -c \"$1\"; fi; " +
+		"code=$?; rm -f " + pidFile + "; exit $code"
+	args = append(args, shell, "-c", session, shell, "echo $$ > "+pidFile+"; "+cmdToRun)
+	return &podExec{pod: name, args: args, timeout: timeout, pidFile: pidFile}
+}
+
+// stepCommand prepares running the cmd of a step on a node. Raw steps exec
+// the words of cmd directly, without a shell, with their variables filled in
+// by the runner.
+func stepCommand(name string, step *config.Step, node int, env []string) *podExec {
+	cmdToRun := config.ForNode(step.CMD, node)
+	if !step.Raw {
+		return podCommand(name, cmdToRun, env, step.Timeout)
+	}
+	// validateTest made sure cmd splits.
+	words, _ := config.SplitWords(cmdToRun)
+	args := []string{"exec", name, "-t", "--"}
+	for _, word := range words {
+		args = append(args, config.ExpandEnv(word, env))
+	}
+	return &podExec{pod: name, args: args, timeout: step.Timeout}
+}
+
+// run runs the command and reports whether it timed out, and its exit code.
+// The timeout starts once the command is let through by max_parallel and
+// kubectl_rate. When it expires, or the run stops, the command is killed
+// both locally and in the pod.
+func (p *podExec) run(stdout io.Writer, stderr io.Writer) (bool, int) {
+	execLimiter.acquire()
+	defer execLimiter.release()
+	kubectlRate.wait()
+	ctx, cancel := context.WithCancel(runContext)
+	defer cancel()
+	if p.timeout != 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, time.Duration(p.timeout)*time.Second)
+		defer cancelTimeout()
+	}
+
+	var errout bytes.Buffer
+	var err error
+	for attempt := 1; ; attempt++ {
+		errout.Reset()
+		out := &countingWriter{w: stdout}
+		cmd := p.command(ctx, p.args...)
+		live := new(maskedLines)
+		cmd.Stdout = out
+		cmd.Stderr = io.MultiWriter(&errout, live)
+		done := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-ctx.Done():
+				p.stopInPod()
+			case <-done:
+			}
+		}()
+		err = cmd.Run()
+		live.Flush()
+		close(done)
+		<-stopped
+		// Only failures of kubectl itself before the command printed
+		// anything are retried, so no command runs twice.
+		if err == nil || ctx.Err() != nil || out.n != 0 || attempt == kubectlAttempts || !isTransientExec(errout.String()) {
+			break
+		}
+		wait := retryBackoff(attempt)
+		p.retries++
+		atomic.AddInt64(&kubectlRetries, 1)
+		color.Yellow("kubectl exec in %s failed: %s, retrying in %s", p.pod, mask(strings.TrimSpace(errout.String())), wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		timer.Stop()
+	}
+	stderr.Write(errout.Bytes())
+	timedOut := ctx.Err() != nil
+	if _, ok := err.(*exec.ExitError); ok && !timedOut {
+		p.execErr = kubectlExecError(errout.String())
+	} else if err != nil && !timedOut {
+		p.execErr = err.Error()
+	}
+	if timedOut {
+		if runContext.Err() != nil {
+			color.Red("Command stopped with the run")
+		} else {
+			color.Red("Command timed out after %d seconds", p.timeout)
+		}
+	}
+	exitCode := 0
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		exitCode = exitErr.ExitCode()
+	}
+	return timedOut, exitCode
+}
+
+// stopInPod terminates the process group of a killed command in the pod,
+// its shell and everything it started, which would otherwise keep running
+// after kubectl is gone.
+func (p *podExec) stopInPod() {
+	if p.pidFile == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stop := fmt.Sprintf("test -f %[1]s && { kill -TERM -- -$(cat %[1]s) 2>/dev/null || kill -TERM $(cat %[1]s); rm -f %[1]s; }", p.pidFile)
+	p.command(ctx, "exec", p.pod, "--", "sh", "-c", stop).Run()
+}
+
+// command returns the command running the kubectl exec args on the node
+// of the pod, through the backend.
+func (p *podExec) command(ctx context.Context, args ...string) *exec.Cmd {
+	return backend.Command(ctx, p.pod, args)
+}
+
+// RunInPod runs a command in a pod and returns its output.
+func RunInPod(name string, cmdToRun string, env []string, timeout int) ([]string, bool) {
+	return runPodCommand(podCommand(name, cmdToRun, env, timeout))
+}
+
+// runPodCommand runs a command prepared for a pod and returns its output.
+func runPodCommand(command *podExec) ([]string, bool) {
+	var out bytes.Buffer
+	timedOut, _ := command.run(&out, ioutil.Discard)
+	lines := strings.Split(out.String(), "\n")
+	return lines[:len(lines)-1], timedOut
+}
+
+func Debug(str string) {
+	debugEnvVar := os.Getenv("DEBUG")
+	if debugEnvVar != "" {
+		fmt.Println(str)
+	}
+}
+
+func debugSpew(thing interface{}) {
+	debugEnvVar := os.Getenv("DEBUG")
+	if debugEnvVar != "" {
+		fmt.Print(mask(spew.Sdump(thing)))
+	}
+}
+
+func printSummary(summary report.Summary, anon *anonymizer) {
+	fmt.Println("============================")
+	fmt.Println("== Test Summary")
+	fmt.Println("===============")
+	fmt.Println("==")
+	fmt.Println("== Started: " + summary.Start.String())
+	fmt.Println("== Ended: " + summary.End.String())
+	fmt.Println("==")
+	successes := strconv.Itoa(summary.Successes)
+	failures := strconv.Itoa(summary.Failures)
+	timeouts := strconv.Itoa(summary.Timeouts)
+	fmt.Println("== Successes: " + successes + "/" + failures + " (success/failure)")
+	fmt.Println("== Timeouts: " + timeouts)
+	if summary.Errors != 0 {
+		fmt.Println("== Errors (kubectl couldn't run a step on a node): " + strconv.Itoa(summary.Errors))
+	}
+	fmt.Println("== Seed: " + strconv.FormatInt(summary.Seed, 10))
+	if summary.Retries != 0 {
+		fmt.Println("== Retried kubectl commands: " + strconv.FormatInt(summary.Retries, 10))
+	}
+	if summary.Aborted != "" {
+		fmt.Println("== Aborted: " + summary.Aborted)
+	}
+	if len(summary.Phases) != 0 {
+		fmt.Println("==")
+		fmt.Println("== Phases:")
+		for _, phase := range summary.Phases {
+			line := fmt.Sprintf("== %s (%d steps): %d/%d (success/failure), %d timeouts", phase.Name, phase.Steps, phase.Successes, phase.Failures, phase.Timeouts)
+			if phase.Errors != 0 {
+				line += fmt.Sprintf(", %d errors", phase.Errors)
+			}
+			fmt.Println(line + ", " + time.Duration(phase.Seconds*float64(time.Second)).Round(time.Millisecond).String())
+		}
+	}
+	if len(summary.Transfers) != 0 {
+		fmt.Println("==")
+		fmt.Println("== Transfers (mean/median/p95):")
+		for _, transfer := range summary.Transfers {
+			fmt.Printf("== %s (%d): time to first byte %.3fs/%.3fs/%.3fs, %.2f/%.2f/%.2f MB/s\n", transfer.Step, transfer.Transfers,
+				transfer.TTFB.Mean, transfer.TTFB.Median, transfer.TTFB.P95, transfer.Throughput.Mean, transfer.Throughput.Median, transfer.Throughput.P95)
+		}
+	}
+	affected := 0
+	for _, iteration := range summary.Iterations {
+		if len(iteration.Incidents) != 0 {
+			affected++
+		}
+	}
+	if affected != 0 {
+		fmt.Println("==")
+		fmt.Printf("== Pod incidents in %d/%d iterations\n", affected, len(summary.Iterations))
+		for _, iteration := range summary.Iterations {
+			for _, incident := range iteration.Incidents {
+				fmt.Printf("== %s, node %d (%s): %s\n", iteration, incident.Node, anon.scrub(incident.Pod), anon.scrub(incident.Message))
+			}
+		}
+	}
+	if usages := report.RunResources(&summary); len(usages) != 0 {
+		fmt.Println("==")
+		fmt.Println("== Resources (peak/average):")
+		for _, usage := range usages {
+			node := "== Node " + strconv.Itoa(usage.Node)
+			if usage.Group != "" {
+				node += " of " + usage.Group
+			}
+			fmt.Printf("%s: CPU %.0fm/%.0fm, memory %.1fMi/%.1fMi\n", node,
+				usage.CPUPeak, usage.CPUAverage, usage.MemoryPeak/(1<<20), usage.MemoryAverage/(1<<20))
+		}
+	}
+	if len(summary.Mapping) != 0 {
+		fmt.Println("==")
+		for _, pod := range summary.Mapping {
+			node := "== Node " + strconv.Itoa(pod.Node)
+			if pod.Group != "" {
+				node += " of " + pod.Group
+			}
+			fmt.Println(node + ": " + anon.scrub(pod.Pod))
+		}
+	}
+
+	// Get the grafana service dynamically; this will work even for real k8s deployments instead of just minikube
+	var port_out bytes.Buffer
+	port_cmd := exec.Command("kubectl", "get", "service", "grafana", "--namespace=monitoring", "-o", "jsonpath='{.spec.ports[0].nodePort}'")
+	port_cmd.Stdout = &port_out
+	port_cmd.Run()
+	// Ignore this error for now... We handle it in address_cmd
+
+	var address_out bytes.Buffer
+	address_cmd := exec.Command("kubectl", "get", "nodes", "-o", "jsonpath='{.items[0].status.addresses[?(@.type == \"InternalIP\")].address}'")
+	address_cmd.Stdout = &address_out
+	if address_cmd.Run() != nil {
+		// Use fallback address, we weren't able to get the
+		address := strings.Replace(address_out.String(), "'", "", -1)
+		port := strings.Replace(port_out.String(), "'", "", -1)
+
+		metricsLink := fmt.Sprintf("http://%s:%s", address, port)
+		metricsLink += "/dashboard/db/kubernetes-pod-resources?from=" + unixToStr(summary.Start.Unix()) + "&to=" + unixToStr(summary.End.Unix())
+		fmt.Println("==")
+		fmt.Println("== Metrics: " + anon.scrub(metricsLink))
+	}
+}
+
+func evaluateOutcome(summary report.Summary, test *config.Test) int {
+	expected := test.Config.Expected
+	met := true
+	// Steps with an expectation of their own are judged by it in every
+	// iteration, and left out of the tag and test totals.
+	ownExpectation := func(step *report.StepResult) bool {
+		return test.Steps[step.Index-1].Expected != nil
+	}
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			if ownExpectation(step) {
+				what := fmt.Sprintf("step %s in iteration %d", step.Name, iteration.Index)
+				if !expectationMet(what, step.Outcomes, *test.Steps[step.Index-1].Expected) {
+					met = false
+				}
+			}
+		}
+	}
+	actual := countOutcomes(summary, func(step *report.StepResult) bool {
+		return !ownExpectation(step)
+	})
+	what := "the test"
+	if len(expected.Tags) != 0 {
+		what = "untagged steps"
+		// Steps with a tag expectation are judged by the first of them and
+		// taken out of the global totals, so that every step counts once.
+		expectedTag := func(step *report.StepResult) string {
+			for _, tag := range step.Tags {
+				if _, ok := expected.Tags[tag]; ok {
+					return tag
+				}
+			}
+			return ""
+		}
+		actual = countOutcomes(summary, func(step *report.StepResult) bool {
+			return !ownExpectation(step) && expectedTag(step) == ""
+		})
+		tags := make([]string, 0, len(expected.Tags))
+		for tag := range expected.Tags {
+			tags = append(tags, tag)
+		}
+		sort.Strings(tags)
+		for _, tag := range tags {
+			tagged := countOutcomes(summary, func(step *report.StepResult) bool {
+				return !ownExpectation(step) && expectedTag(step) == tag
+			})
+			if !expectationMet("tag "+tag, tagged, expected.Tags[tag]) {
+				met = false
+			}
+		}
+	}
+	for _, iteration := range summary.Iterations {
+		actual.Errors += iteration.Errors
+	}
+	if !expectationMet(what, actual, expected) {
+		met = false
+	}
+	if !met {
+		color.Set(color.FgRed)
+		fmt.Println("Expectations were not met")
+		color.Unset()
+		return 1
+	}
+
+	color.Set(color.FgGreen)
+	fmt.Println("Expectations were met")
+	color.Unset()
+	return 0
+}
+
+// stepFailed reports whether a step failed, for --fail-fast: it missed its
+// own expectation, or without one, had a failure or a timeout.
+func stepFailed(step *config.Step, result *report.StepResult) bool {
+	if step.Expected != nil {
+		return !expectationMet("step "+step.Name, result.Outcomes, *step.Expected)
+	}
+	return result.Failures != 0 || result.Timeouts != 0 || result.Errors != 0
+}
+
+// countOutcomes adds up the outcomes of every step result selected by include.
+func countOutcomes(summary report.Summary, include func(*report.StepResult) bool) report.Outcomes {
+	var total report.Outcomes
+	for _, iteration := range summary.Iterations {
+		for _, step := range iteration.Steps {
+			if include(step) {
+				total.Successes += step.Successes
+				total.Failures += step.Failures
+				total.Timeouts += step.Timeouts
+				total.Errors += step.Errors
+			}
+		}
+	}
+	return total
+}
+
+func expectationMet(what string, actual report.Outcomes, expected config.Expected) bool {
+	met := true
+	counts := []struct {
+		name   string
+		actual int
+		bound  config.Bound
+	}{
+		{"successes", actual.Successes, expected.Successes},
+		{"failures", actual.Failures, expected.Failures},
+		{"timeouts", actual.Timeouts, expected.Timeouts},
+		{"errors", actual.Errors, expected.Errors},
+	}
+	for _, count := range counts {
+		if expected.SuccessRate != nil && !count.bound.Set {
+			continue
+		}
+		if !count.bound.Matches(count.actual) {
+			color.Red("Expected %s %s for %s, got %d", count.name, count.bound, what, count.actual)
+			met = false
+		}
+	}
+	if expected.SuccessRate != nil {
+		total := actual.Successes + actual.Failures + actual.Timeouts + actual.Errors
+		rate := 100.0
+		if total != 0 {
+			rate = 100 * float64(actual.Successes) / float64(total)
+		}
+		if rate < *expected.SuccessRate {
+			color.Red("Expected a success rate of at least %g%% for %s, got %.2f%% (%d/%d/%d success/failure/timeout)",
+				*expected.SuccessRate, what, rate, actual.Successes, actual.Failures, actual.Timeouts)
+			met = false
+		}
+	}
+	return met
+}
+
+func unixToStr(i int64) string {
+	return strconv.FormatInt(i, 10) + "000"
+}
+
+// ShellQuote quotes s so bash in the pod passes it through as a single word.
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
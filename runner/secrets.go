@@ -0,0 +1,60 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+)
+
+// secrets are the values masked in the console output, the files written
+// and the reports. They are all known before the first step runs.
+var secrets []string
+
+func addSecret(value string) {
+	if value != "" {
+		secrets = append(secrets, value)
+	}
+}
+
+// secretEnv returns a step environment holding the secrets of a test, their
+// values expanded with the runner's environment, and registers them for
+// masking.
+func secretEnv(values map[string]string) ([]string, error) {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var env []string
+	for _, name := range names {
+		value := os.ExpandEnv(values[name])
+		if value == "" {
+			return nil, fmt.Errorf("secret %s is empty", name)
+		}
+		addSecret(value)
+		env = config.SetVariable(env, name, value)
+	}
+	return env, nil
+}
+
+// mask hides the secret values in s.
+func mask(s string) string {
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, "***", -1)
+	}
+	return s
+}
+
+func maskLines(lines []string) []string {
+	if len(secrets) == 0 {
+		return lines
+	}
+	masked := make([]string, len(lines))
+	for i, line := range lines {
+		masked[i] = mask(line)
+	}
+	return masked
+}
@@ -0,0 +1,377 @@
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+)
+
+// restartCmd returns the command restarting the go-ipfs daemon inside a pod,
+// the restart_cmd of the config or one starting it again in the background.
+// With a private network, LIBP2P_FORCE_PNET makes the daemon refuse to start
+// without a swarm key, so a key that failed to land shows up as a daemon that
+// never comes back.
+func restartCmd(cfg *config.Config) string {
+	if cfg.RestartCmd != "" {
+		return cfg.RestartCmd
+	}
+	env := ""
+	if cfg.PrivateNetwork {
+		env = "LIBP2P_FORCE_PNET=1 "
+	}
+	return "ipfs shutdown; while pgrep -x ipfs > /dev/null; do sleep 0.5; done; " +
+		env + "nohup ipfs daemon > /tmp/ipfs-daemon.log 2>&1 &"
+}
+
+// daemonStartTimeout is how long we wait for a restarted daemon to answer.
+const daemonStartTimeout = 60 * time.Second
+
+// setupNodes runs the pre-test phases requested in the test against the
+// pods taking part in it, drawing what is random from the run's seed.
+func setupNodes(test *config.Test, pods []Pod, seed int64) error {
+	cfg := &test.Config
+	if len(test.NodeConfig) != 0 {
+		err := applyNodeConfig(cfg, test.NodeConfig, pods)
+		if err != nil {
+			return fmt.Errorf("node config failed: %s", err)
+		}
+	}
+	if cfg.Isolate {
+		err := isolateNodes(cfg, pods)
+		if err != nil {
+			return fmt.Errorf("isolation failed: %s", err)
+		}
+	}
+	if cfg.PrivateNetwork {
+		err := setupPrivateNetwork(cfg, pods)
+		if err != nil {
+			return fmt.Errorf("private network setup failed: %s", err)
+		}
+	}
+	if cfg.Topology != nil {
+		err := setupTopology(cfg, pods, seed)
+		if err != nil {
+			return fmt.Errorf("topology setup failed: %s", err)
+		}
+	}
+	if cfg.Isolate {
+		ids, err := peerIDs(pods)
+		if err != nil {
+			return err
+		}
+		err = verifyOnlyTestPeers(pods, ids, false)
+		if err != nil {
+			return fmt.Errorf("isolation failed: %s", err)
+		}
+		color.Green("Isolation of %d nodes verified", len(pods))
+	}
+	return nil
+}
+
+// applyNodeConfig runs `ipfs config` for every setting on its node range and
+// restarts the daemons it touched so the settings take effect.
+func applyNodeConfig(cfg *config.Config, nodeConfigs []config.NodeConfig, pods []Pod) error {
+	touched := make(map[int]bool)
+	for _, nodeConfig := range nodeConfigs {
+		start, end := nodeConfig.OnNode, nodeConfig.EndNode
+		if start == 0 {
+			start, end = 1, len(pods)
+		}
+		if end == 0 {
+			end = start
+		}
+		if start < 1 || end > len(pods) || start > end {
+			return fmt.Errorf("node range %d to %d is outside of the %d test nodes", start, end, len(pods))
+		}
+		keys := make([]string, 0, len(nodeConfig.Settings))
+		for key := range nodeConfig.Settings {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			cmd, err := ipfsConfigCmd(key, nodeConfig.Settings[key])
+			if err != nil {
+				return err
+			}
+			color.Blue("### Setting %s on nodes %d to %d", key, start, end)
+			for j := start; j <= end; j++ {
+				out, _ := RunInPod(pods[j-1].Metadata.Name, cmd+" && echo ok", nil, 10)
+				if len(out) == 0 || strings.TrimSpace(out[len(out)-1]) != "ok" {
+					return fmt.Errorf("could not set %s on node %d: %s", key, j, strings.Join(out, "\n"))
+				}
+				touched[j] = true
+			}
+		}
+	}
+	restart := make([]Pod, 0, len(touched))
+	for j := 1; j <= len(pods); j++ {
+		if touched[j] {
+			restart = append(restart, pods[j-1])
+		}
+	}
+	return restartDaemons(cfg, restart)
+}
+
+// ipfsConfigCmd builds the `ipfs config` invocation for a setting. Strings are
+// set verbatim, anything else (numbers, booleans, lists, maps) is passed as
+// JSON.
+func ipfsConfigCmd(key string, value interface{}) (string, error) {
+	if str, ok := value.(string); ok {
+		return fmt.Sprintf("ipfs config %s %s", ShellQuote(key), ShellQuote(str)), nil
+	}
+	encoded, err := json.Marshal(jsonValue(value))
+	if err != nil {
+		return "", fmt.Errorf("invalid value for %s: %s", key, err)
+	}
+	return fmt.Sprintf("ipfs config --json %s %s", ShellQuote(key), ShellQuote(string(encoded))), nil
+}
+
+// jsonValue converts the maps produced by the yaml decoder into maps that
+// encoding/json can marshal.
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprint(key)] = jsonValue(val)
+		}
+		return m
+	case []interface{}:
+		for index, val := range v {
+			v[index] = jsonValue(val)
+		}
+		return v
+	default:
+		return v
+	}
+}
+
+// isolateNodes removes the bootstrap peers of every pod and disables MDNS,
+// then restarts the daemons, unless the private network setup is about to,
+// so they drop the peers they found.
+func isolateNodes(cfg *config.Config, pods []Pod) error {
+	color.Cyan("## Isolating %d nodes from the bootstrap peers and MDNS", len(pods))
+	for _, pod := range pods {
+		err := isolateNode(pod)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.PrivateNetwork {
+		return nil
+	}
+	return restartDaemons(cfg, pods)
+}
+
+// isolateNode removes the bootstrap peers of a pod and disables MDNS, which
+// takes effect once its daemon restarts.
+func isolateNode(pod Pod) error {
+	isolate := "ipfs bootstrap rm --all > /dev/null && ipfs config --json Discovery.MDNS.Enabled false && echo ok"
+	out, _ := RunInPod(pod.Metadata.Name, isolate, nil, 10)
+	if len(out) == 0 || strings.TrimSpace(out[len(out)-1]) != "ok" {
+		return fmt.Errorf("could not isolate %s: %s", pod.Metadata.Name, strings.Join(out, "\n"))
+	}
+	return nil
+}
+
+// adopt sets up a pod that took over a node of the fleet the way setupNodes
+// set up the test's pods, so a replacement doesn't bring the public network
+// into an isolated or private test: it gets the swarm key of the other nodes
+// and is isolated, then its daemon restarts and it joins the topology.
+func (fleet *Fleet) adopt(pod Pod) error {
+	cfg := fleet.Config
+	if !cfg.Isolate && !cfg.PrivateNetwork {
+		return fleet.reconnect([]Pod{pod})
+	}
+	color.Cyan("## Setting up %s like the other test nodes", pod.Metadata.Name)
+	if cfg.PrivateNetwork {
+		key, err := fleet.swarmKey(pod)
+		if err != nil {
+			return err
+		}
+		err = installSwarmKey(pod, key)
+		if err != nil {
+			return err
+		}
+	}
+	if cfg.Isolate {
+		err := isolateNode(pod)
+		if err != nil {
+			return err
+		}
+	}
+	err := restartDaemons(cfg, []Pod{pod})
+	if err != nil {
+		return err
+	}
+	return fleet.reconnect([]Pod{pod})
+}
+
+// swarmKey reads the swarm key of the private network from a node of the
+// fleet other than pod.
+func (fleet *Fleet) swarmKey(pod Pod) (string, error) {
+	for _, peer := range fleet.testPods() {
+		if peer.Metadata.Name == pod.Metadata.Name {
+			continue
+		}
+		out, _ := RunInPod(peer.Metadata.Name, "cat ${IPFS_PATH:-~/.ipfs}/swarm.key", nil, 10)
+		if len(out) != 0 && strings.HasPrefix(out[0], "/key/swarm/psk/") {
+			return strings.Join(out, "\n"), nil
+		}
+	}
+	return "", fmt.Errorf("no node to copy the swarm key from")
+}
+
+// installSwarmKey writes a swarm key in the repo of a pod.
+func installSwarmKey(pod Pod, key string) error {
+	writeKey := fmt.Sprintf("repo=${IPFS_PATH:-~/.ipfs} && printf '%%s' '%s' > $repo/swarm.key && echo ok", key)
+	out, _ := RunInPod(pod.Metadata.Name, writeKey, nil, 10)
+	if len(out) == 0 || strings.TrimSpace(out[0]) != "ok" {
+		return fmt.Errorf("could not write swarm key on %s", pod.Metadata.Name)
+	}
+	return nil
+}
+
+// setupPrivateNetwork generates a swarm key, installs it on every pod,
+// restarts the daemons and checks the nodes only ever see each other.
+func setupPrivateNetwork(cfg *config.Config, pods []Pod) error {
+	color.Cyan("## Setting up private network on %d nodes", len(pods))
+	key, err := generateSwarmKey()
+	if err != nil {
+		return err
+	}
+	for _, pod := range pods {
+		err = installSwarmKey(pod, key)
+		if err != nil {
+			return err
+		}
+	}
+	err = restartDaemons(cfg, pods)
+	if err != nil {
+		return err
+	}
+	return verifyPrivateNetwork(pods)
+}
+
+// generateSwarmKey returns a new pre-shared key in the format go-ipfs
+// expects in $IPFS_PATH/swarm.key.
+func generateSwarmKey() (string, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	return "/key/swarm/psk/1.0.0/\n/base16/\n" + hex.EncodeToString(key), nil
+}
+
+// restartDaemons restarts the ipfs daemon on every pod and waits until each
+// of them answers API calls again.
+func restartDaemons(cfg *config.Config, pods []Pod) error {
+	for _, pod := range pods {
+		color.Blue("### Restarting daemon on %s", pod.Metadata.Name)
+		restarted, err := backend.Restart(cfg, pod)
+		if err != nil {
+			return err
+		}
+		if !restarted {
+			RunInPod(pod.Metadata.Name, restartCmd(cfg), nil, 30)
+		}
+	}
+	for _, pod := range pods {
+		err := waitForDaemon(pod.Metadata.Name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func waitForDaemon(name string) error {
+	return waitForDaemonUntil(name, time.Now().Add(daemonStartTimeout))
+}
+
+// waitForDaemonUntil polls the ipfs daemon in a pod until it answers or the
+// deadline passes.
+func waitForDaemonUntil(name string, deadline time.Time) error {
+	for time.Now().Before(deadline) {
+		out, _ := RunInPod(name, "ipfs swarm peers > /dev/null && echo ready", nil, 10)
+		if len(out) > 0 && strings.TrimSpace(out[0]) == "ready" {
+			return nil
+		}
+		time.Sleep(time.Second)
+	}
+	return fmt.Errorf("daemon on %s did not come back in time", name)
+}
+
+// verifyPrivateNetwork connects every node to the first one and checks that
+// no node is connected to anything outside of the test pods.
+func verifyPrivateNetwork(pods []Pod) error {
+	ids, err := peerIDs(pods)
+	if err != nil {
+		return err
+	}
+	first := pods[0]
+	firstID := ""
+	for id, name := range ids {
+		if name == first.Metadata.Name {
+			firstID = id
+		}
+	}
+	for _, pod := range pods[1:] {
+		connect := fmt.Sprintf("ipfs swarm connect /ip4/%s/tcp/4001/ipfs/%s", first.Status.PodIP, firstID)
+		RunInPod(pod.Metadata.Name, connect, nil, 30)
+	}
+	err = verifyOnlyTestPeers(pods, ids, true)
+	if err != nil {
+		return err
+	}
+	color.Green("Private network of %d nodes verified", len(pods))
+	return nil
+}
+
+// verifyOnlyTestPeers checks that all the peers of every pod are test pods,
+// ids mapping their peer ids to their names, and with needPeers that every
+// pod has some, when there are others.
+func verifyOnlyTestPeers(pods []Pod, ids map[string]string, needPeers bool) error {
+	for _, pod := range pods {
+		peers, _ := RunInPod(pod.Metadata.Name, "ipfs swarm peers", nil, 10)
+		if needPeers && len(pods) > 1 && len(peers) == 0 {
+			return fmt.Errorf("%s has no peers among the test nodes", pod.Metadata.Name)
+		}
+		for _, addr := range peers {
+			parts := strings.Split(strings.TrimSpace(addr), "/")
+			if _, ok := ids[parts[len(parts)-1]]; !ok {
+				return fmt.Errorf("%s is connected to %s, which is not a test node", pod.Metadata.Name, addr)
+			}
+		}
+	}
+	return nil
+}
+
+// peerIDs maps the peer ids of the pods to their names.
+func peerIDs(pods []Pod) (map[string]string, error) {
+	ids := make(map[string]string)
+	for _, pod := range pods {
+		id, err := peerID(pod.Metadata.Name)
+		if err != nil {
+			return nil, err
+		}
+		ids[id] = pod.Metadata.Name
+	}
+	return ids, nil
+}
+
+func peerID(name string) (string, error) {
+	out, _ := RunInPod(name, "ipfs id -f='<id>' && echo", nil, 10)
+	if len(out) == 0 || strings.TrimSpace(out[0]) == "" {
+		return "", fmt.Errorf("could not get peer id of %s", name)
+	}
+	return strings.TrimSpace(out[0]), nil
+}
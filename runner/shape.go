@@ -0,0 +1,75 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// defaultShapeDevice is the pod network interface shaped by default.
+const defaultShapeDevice = "eth0"
+
+// shapeCmd builds the tc commands applying the shape. Traffic to specific
+// destinations goes through the third band of a prio qdisc, which only sees
+// packets sent there by the destination filters.
+func shapeCmd(shape *config.Shape, destinations []string) string {
+	dev := shape.Device
+	if dev == "" {
+		dev = defaultShapeDevice
+	}
+	if len(destinations) == 0 {
+		return fmt.Sprintf("tc qdisc replace dev %s root netem %s", dev, shape.Netem())
+	}
+	cmds := []string{
+		fmt.Sprintf("tc qdisc del dev %s root 2> /dev/null; tc qdisc add dev %s root handle 1: prio", dev, dev),
+		fmt.Sprintf("tc qdisc add dev %s parent 1:3 handle 30: netem %s", dev, shape.Netem()),
+	}
+	for _, ip := range destinations {
+		cmds = append(cmds, fmt.Sprintf("tc filter add dev %s protocol ip parent 1:0 prio 3 u32 match ip dst %s/32 flowid 1:3", dev, ip))
+	}
+	return strings.Join(cmds, " && ")
+}
+
+func ShapeResetCmd(shape *config.Shape) string {
+	dev := defaultShapeDevice
+	if shape != nil && shape.Device != "" {
+		dev = shape.Device
+	}
+	return fmt.Sprintf("tc qdisc del dev %s root 2> /dev/null; true", dev)
+}
+
+// handleShapeStep applies (or removes, with shape_reset) traffic shaping on
+// the step's nodes, counting the nodes it failed on as errors.
+func handleShapeStep(pods GetPodsOutput, fleet *Fleet, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	var cmd string
+	if step.ShapeReset {
+		color.Blue("### Resetting traffic shaping on nodes %d to %d", step.OnNode, step.EndNode)
+		cmd = ShapeResetCmd(step.Shape)
+	} else {
+		var destinations []string
+		if step.Shape.ToGroup != "" {
+			for _, pod := range fleet.Groups[step.Shape.ToGroup].Items {
+				destinations = append(destinations, pod.Status.PodIP)
+			}
+		}
+		color.Blue("### Shaping traffic on nodes %d to %d: %s", step.OnNode, step.EndNode, step.Shape.Netem())
+		cmd = shapeCmd(step.Shape, destinations)
+	}
+	color.Magenta("$ %s", cmd)
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		name := pods.Items[j-1].Metadata.Name
+		out, timedOut := RunInPod(name, cmd+" && echo ok", nil, 30)
+		nodeResult := &report.NodeResult{Node: j, Pod: name, Output: out, TimedOut: timedOut}
+		result.Nodes = append(result.Nodes, nodeResult)
+		if len(out) == 0 || strings.TrimSpace(out[len(out)-1]) != "ok" {
+			nodeResult.Error = "traffic shaping failed"
+			color.Red("Traffic shaping failed on node %d: %s", j, strings.Join(out, "\n"))
+			summary.Errors++
+			result.Errors++
+		}
+	}
+	return env
+}
@@ -0,0 +1,114 @@
+package runner
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// stepper pauses the run before every step with --step, until the user
+// runs, skips or aborts it.
+type stepper struct {
+	mutex  sync.Mutex
+	input  *bufio.Reader
+	paused bool
+}
+
+func newStepper(enabled bool) *stepper {
+	if !enabled {
+		return nil
+	}
+	return &stepper{input: bufio.NewReader(os.Stdin), paused: true}
+}
+
+// Answers to the prompt of the stepper
+const (
+	stepRun = iota
+	stepSkip
+	stepAbort
+)
+
+// confirm shows what the step is about to do and asks what to do with it. A
+// nil stepper, or one told to continue, runs every step. Iterations running
+// in parallel are asked one at a time.
+func (s *stepper) confirm(iteration *report.IterationResult, index int, step *config.Step, env []string) int {
+	if s == nil {
+		return stepRun
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if !s.paused {
+		return stepRun
+	}
+	color.Cyan("## Next: step %d %s of %s", index, step.Name, iteration)
+	fmt.Println(mask(describeStep(step, env)))
+	for {
+		fmt.Print("[Enter] run, (s)kip, (a)bort, (c)ontinue without pausing: ")
+		answer, err := s.input.ReadString('\n')
+		if err != nil {
+			// Without a terminal there is no one left to ask.
+			s.paused = false
+			return stepRun
+		}
+		switch strings.TrimSpace(answer) {
+		case "":
+			return stepRun
+		case "s":
+			return stepSkip
+		case "a":
+			return stepAbort
+		case "c":
+			s.paused = false
+			return stepRun
+		}
+	}
+}
+
+// describeStep tells what a step targets and the command it runs on its
+// first node, with the variables saved so far expanded.
+func describeStep(step *config.Step, env []string) string {
+	var lines []string
+	target := fmt.Sprintf("nodes %d to %d", step.OnNode, step.EndNode)
+	if step.OnGroup != "" {
+		target += " of group " + step.OnGroup
+	}
+	switch {
+	case step.Wait != "":
+		lines = append(lines, "wait "+step.Wait)
+	case step.PromQL != "":
+		lines = append(lines, "promql "+step.PromQL)
+	case config.IsClusterStep(step):
+		lines = append(lines, "ipfs-cluster step")
+	case step.ResetRepo != "":
+		lines = append(lines, target, "reset repos with "+step.ResetRepo)
+	case step.Replicas != nil:
+		lines = append(lines, target, "replicas of "+config.ExpandEnv(step.Replicas.CID, env))
+	case step.BitswapLedgers:
+		lines = append(lines, target, "bitswap ledgers")
+	case step.DuplicateBlocks != nil:
+		lines = append(lines, target, "duplicate blocks ratio")
+	case step.Stress != nil:
+		lines = append(lines, target, "stress for "+step.Stress.Duration)
+	case step.Gateway != nil:
+		lines = append(lines, target, fmt.Sprintf("GET %s%s%s", step.Gateway.URL, step.Gateway.CID, step.Gateway.Path))
+	case step.Latency != nil:
+		lines = append(lines, target, fmt.Sprintf("%s latency from node %d", step.Latency.Lookup(), step.Latency.Provider))
+	case step.Op != "" || step.Type != "":
+		args := make([]string, 0, len(step.Args))
+		for key, value := range step.Args {
+			args = append(args, key+"="+config.ExpandEnv(config.ForNode(value, step.OnNode), env))
+		}
+		lines = append(lines, target, fmt.Sprintf("$ %s%s %s", step.Op, step.Type, strings.Join(args, " ")))
+	case step.CMD != "":
+		lines = append(lines, target, "$ "+config.ExpandEnv(config.ForNode(step.CMD, step.OnNode), env))
+	default:
+		lines = append(lines, target)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,131 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+)
+
+// stepPluginPrefix names the executables on the PATH providing the step
+// types that weren't registered, kubernetes-ipfs-step-<type>.
+const stepPluginPrefix = "kubernetes-ipfs-step-"
+
+// StepType runs a step of a custom type on one of its nodes, e.g. publishing
+// an IPNS record and resolving it. It fills in the node's result as a command
+// would: its output lines, stderr and exit code, the fields save can pick,
+// or why it couldn't run. The step's assertions and outputs then apply to it
+// as usual. The context ends when the step times out or the run stops.
+type StepType func(ctx context.Context, node StepNode, result *report.NodeResult)
+
+// StepNode is a node a step of a custom type runs on.
+type StepNode struct {
+	// Node is the number of the node, from 1, and Pod its pod, or the
+	// container or testbed node of a local backend.
+	Node int
+	Pod  string
+	// Args are the args of the step, with the variables filled in.
+	Args map[string]string
+	// Env is the step environment, as NAME="value" entries.
+	Env []string
+}
+
+// stepTypes are the step types registered by the programs embedding the
+// runner.
+var stepTypes = make(map[string]StepType)
+
+// RegisterStepType adds a step type, which steps select with type. It
+// takes precedence over a plugin of the same name.
+func RegisterStepType(name string, run StepType) {
+	stepTypes[name] = run
+}
+
+// lookupStepType returns the step type of a name, registered or provided by
+// a plugin on the PATH.
+func lookupStepType(name string) (StepType, error) {
+	if run, ok := stepTypes[name]; ok {
+		return run, nil
+	}
+	path, err := exec.LookPath(stepPluginPrefix + name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown step type %s, with no %s%s on the PATH", name, stepPluginPrefix, name)
+	}
+	return pluginStepType(path), nil
+}
+
+// pluginStepType runs an executable on the machine for every node, with the
+// step environment, the node as NODE and POD, the namespace as NAMESPACE and
+// every arg as ARG_<NAME>. What it prints is the output of the node.
+func pluginStepType(path string) StepType {
+	return func(ctx context.Context, node StepNode, result *report.NodeResult) {
+		cmd := exec.CommandContext(ctx, path)
+		cmd.Env = os.Environ()
+		for _, e := range node.Env {
+			found := config.EnvVarRegexp.FindStringSubmatch(e)
+			if len(found) == 3 {
+				cmd.Env = append(cmd.Env, found[1]+"="+found[2])
+			}
+		}
+		cmd.Env = append(cmd.Env, "NODE="+strconv.Itoa(node.Node), "POD="+node.Pod, "NAMESPACE="+Namespace)
+		for key, value := range node.Args {
+			cmd.Env = append(cmd.Env, "ARG_"+strings.ToUpper(key)+"="+value)
+		}
+		// Children left running don't hold the step past its timeout.
+		cmd.WaitDelay = time.Second
+		out := new(bytes.Buffer)
+		errout := new(bytes.Buffer)
+		cmd.Stdout = out
+		cmd.Stderr = errout
+		err := cmd.Run()
+		if out.Len() != 0 {
+			result.Output = strings.Split(strings.TrimRight(out.String(), "\n"), "\n")
+		}
+		if errout.Len() != 0 {
+			result.Stderr = strings.Split(strings.TrimRight(errout.String(), "\n"), "\n")
+		}
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+		} else if err != nil && ctx.Err() == nil {
+			result.Error = err.Error()
+		}
+	}
+}
+
+// runTypeAsync runs a step of a custom type on a pod and hands the result to
+// the channel, like runInPodAsync does for commands.
+func runTypeAsync(node int, pod Pod, step *config.Step, env []string, results chan *report.NodeResult) {
+	go func() {
+		result := &report.NodeResult{Node: node, Pod: pod.Metadata.Name}
+		defer func() {
+			results <- result
+		}()
+		// validateTest made sure the type exists.
+		run, err := lookupStepType(step.Type)
+		if err != nil {
+			result.Error = err.Error()
+			return
+		}
+		args := make(map[string]string)
+		for key, value := range step.Args {
+			args[key] = config.ExpandEnv(config.ForNode(value, node), env)
+		}
+		ctx, cancel := context.WithCancel(runContext)
+		defer cancel()
+		if step.Timeout != 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, time.Duration(step.Timeout)*time.Second)
+			defer cancelTimeout()
+		}
+		run(ctx, StepNode{Node: node, Pod: pod.Metadata.Name, Args: args, Env: env}, result)
+		if ctx.Err() != nil {
+			result.TimedOut = true
+		}
+	}()
+}
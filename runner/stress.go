@@ -0,0 +1,243 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// stressGrace is how long, in seconds, a stress step waits past its duration
+// for stress-ng to hand back its metrics.
+const stressGrace = 30
+
+// handleStressStep resizes the pods of the step's nodes when the step has
+// limits, runs stress-ng on them as the step's command, so its output and
+// exit code are checked as usual, and gives the pods their resources back
+// once the load is over. The load shows as a stress_active metric of every
+// node, 1 while it lasts, to line it up with what is sampled meanwhile.
+func handleStressStep(pods GetPodsOutput, step *config.Step, summary *report.Summary, result *report.StepResult, env []string) []string {
+	stress := step.Stress
+	// validateTest made sure it parses.
+	duration, _ := config.ParseWait(stress.Duration)
+	color.Blue("### Stressing nodes %d to %d for %s", step.OnNode, step.EndNode, duration)
+	var resized []*resizedPod
+	for j := step.OnNode; j <= step.EndNode && len(stress.Limits) != 0; j++ {
+		pod, err := resizePod(pods.Items[j-1].Metadata.Name, stress.Limits)
+		if err != nil {
+			color.Red("Could not resize node %d: %s", j, err)
+			summary.Errors++
+			result.Errors++
+			continue
+		}
+		resized = append(resized, pod)
+	}
+	start := time.Now()
+	for j := step.OnNode; j <= step.EndNode; j++ {
+		name := pods.Items[j-1].Metadata.Name
+		summary.Metrics = append(summary.Metrics,
+			report.Metric{Time: start, Node: j, Pod: name, Name: "stress_active", Value: 1},
+			report.Metric{Time: start.Add(duration), Node: j, Pod: name, Name: "stress_active", Value: 0},
+		)
+	}
+
+	if stress.Load() {
+		load := *step
+		load.CMD, load.Raw = stressCmd(stress, duration), false
+		if seconds := int(duration.Seconds()) + stressGrace; !stress.Background && load.Timeout != 0 && load.Timeout < seconds {
+			load.Timeout = seconds
+		}
+		env = handleStep(pods, &load, summary, result, env)
+	}
+	if len(resized) == 0 {
+		return env
+	}
+	// restore returns how many pods it couldn't give their resources back.
+	restore := func() int {
+		sleep(duration - time.Since(start))
+		failed := 0
+		for _, pod := range resized {
+			err := pod.restore()
+			if err != nil {
+				color.Red("Could not give %s its resources back: %s", pod.name, err)
+				failed++
+			}
+		}
+		return failed
+	}
+	if stress.Background {
+		done := make(chan int, 1)
+		go func() { done <- restore() }()
+		stressRestoresMutex.Lock()
+		stressRestores = append(stressRestores, stressRestore{summary: summary, result: result, done: done})
+		stressRestoresMutex.Unlock()
+	} else {
+		failed := restore()
+		summary.Errors += failed
+		result.Errors += failed
+	}
+	return env
+}
+
+// stressRestore is a stress step left running in the background, which
+// gives its pods their resources back once its load is over.
+type stressRestore struct {
+	summary *report.Summary
+	result  *report.StepResult
+	// done gets the number of pods it couldn't restore.
+	done chan int
+}
+
+var (
+	stressRestoresMutex sync.Mutex
+	stressRestores      []stressRestore
+)
+
+// finishStress waits until the stress steps of the iteration recorded in
+// summary that were left running in the background gave their pods their
+// resources back, counting the pods they couldn't restore as errors of the
+// steps.
+func finishStress(summary *report.Summary) {
+	stressRestoresMutex.Lock()
+	var mine, others []stressRestore
+	for _, restore := range stressRestores {
+		if restore.summary == summary {
+			mine = append(mine, restore)
+		} else {
+			others = append(others, restore)
+		}
+	}
+	stressRestores = others
+	stressRestoresMutex.Unlock()
+	for _, restore := range mine {
+		failed := <-restore.done
+		summary.Errors += failed
+		restore.result.Errors += failed
+	}
+}
+
+// stressCmd returns the stress-ng command putting the load of a stress step
+// on a node, started in the background when the step is.
+func stressCmd(stress *config.Stress, duration time.Duration) string {
+	args := []string{"stress-ng"}
+	if stress.CPU != 0 {
+		args = append(args, "--cpu", strconv.Itoa(stress.CPU))
+		if stress.CPULoad != 0 {
+			args = append(args, "--cpu-load", strconv.Itoa(stress.CPULoad))
+		}
+	}
+	if stress.Memory != "" {
+		args = append(args, "--vm", "1", "--vm-bytes", ShellQuote(stress.Memory), "--vm-keep")
+	}
+	if stress.IO != 0 {
+		args = append(args, "--io", strconv.Itoa(stress.IO))
+	}
+	args = append(args, "--timeout", strconv.Itoa(int(duration.Seconds()))+"s", "--metrics-brief")
+	cmd := strings.Join(args, " ")
+	if stress.Background {
+		cmd = "nohup " + cmd + " > /tmp/stress-ng.log 2>&1 &"
+	}
+	return cmd
+}
+
+// resizedPod is a pod whose first container a stress step resized, with the
+// resources to give it back.
+type resizedPod struct {
+	name      string
+	container string
+	resources map[string]map[string]interface{}
+}
+
+// containerResources is the part of a pod a stress step resizes.
+type containerResources struct {
+	Spec struct {
+		Containers []struct {
+			Name      string `json:"name"`
+			Resources struct {
+				Limits   map[string]string `json:"limits"`
+				Requests map[string]string `json:"requests"`
+			} `json:"resources"`
+		} `json:"containers"`
+	} `json:"spec"`
+}
+
+// resizePod sets limits on the first container of a pod in place, and returns
+// what it changed. Kubernetes doesn't resize a container into another QoS
+// class, so the container must have limits and requests for the resources
+// already, and requests equal to their limits follow them; other requests
+// are lowered where they are above the new limits.
+func resizePod(name string, limits map[string]string) (*resizedPod, error) {
+	cmd := targetOf(name).command(runContext, "get", "pod", name, "-o", "json")
+	var out, errout bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &out, &errout
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("could not get pod %s: %s", name, strings.TrimSpace(errout.String()))
+	}
+	var pod containerResources
+	err = json.Unmarshal(out.Bytes(), &pod)
+	if err != nil || len(pod.Spec.Containers) == 0 {
+		return nil, fmt.Errorf("could not read the containers of pod %s", name)
+	}
+	container := pod.Spec.Containers[0]
+	resize := map[string]map[string]interface{}{"limits": {}, "requests": {}}
+	restore := map[string]map[string]interface{}{"limits": {}, "requests": {}}
+	for resource, limit := range limits {
+		previous, ok := container.Resources.Limits[resource]
+		if !ok {
+			return nil, fmt.Errorf("container %s of pod %s has no %s limit to resize", container.Name, name, resource)
+		}
+		request, ok := container.Resources.Requests[resource]
+		if !ok {
+			return nil, fmt.Errorf("container %s of pod %s has no %s request", container.Name, name, resource)
+		}
+		resize["limits"][resource] = limit
+		restore["limits"][resource] = previous
+		// Kubernetes rejects a request above its limit.
+		if request == previous || quantityAbove(request, limit) {
+			resize["requests"][resource] = limit
+			restore["requests"][resource] = request
+		}
+	}
+	err = patchResources(name, container.Name, resize)
+	if err != nil {
+		return nil, err
+	}
+	return &resizedPod{name: name, container: container.Name, resources: restore}, nil
+}
+
+// restore gives the pod the resources it had before it was resized.
+func (pod *resizedPod) restore() error {
+	return patchResources(pod.name, pod.container, pod.resources)
+}
+
+// patchResources resizes a container of a pod in place.
+func patchResources(pod string, container string, resources map[string]map[string]interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"spec": map[string]interface{}{
+			"containers": []interface{}{map[string]interface{}{"name": container, "resources": resources}},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	return targetOf(pod).kubectl("patch", "pod", pod, "--subresource=resize", "--patch", string(patch))
+}
+
+// quantityAbove tells whether a Kubernetes quantity is above another, false
+// when either doesn't parse.
+func quantityAbove(a string, b string) bool {
+	x, err := parseQuantity(a)
+	if err != nil {
+		return false
+	}
+	y, err := parseQuantity(b)
+	return err == nil && x > y
+}
@@ -0,0 +1,287 @@
+package runner
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+)
+
+// topologyShapes are the shapes a topology can have.
+var topologyShapes = map[string]bool{"mesh": true, "ring": true, "star": true, "random": true, "explicit": true}
+
+// link connects two nodes, the lower one first.
+type link [2]int
+
+func newLink(a, b int) link {
+	if a > b {
+		a, b = b, a
+	}
+	return link{a, b}
+}
+
+// activeTopology holds the links the nodes of the test were connected with,
+// to connect the nodes whose daemons restarted again.
+var activeTopology []link
+
+// setupTopology connects the nodes as the test's topology says, then checks
+// that every node is connected to each of its peers in it. Nodes are free to
+// find more peers, so the topology is the least they are connected to.
+func setupTopology(cfg *config.Config, pods []Pod, seed int64) error {
+	links, err := topologyLinks(cfg.Topology, len(pods), seed)
+	if err != nil {
+		return err
+	}
+	color.Blue("### Connecting %d nodes as a %s of %d links", len(pods), cfg.Topology.Shape, len(links))
+	err = connectLinks(pods, links)
+	if err != nil {
+		return err
+	}
+	activeTopology = links
+	color.Green("Topology of %d nodes and %d links verified", len(pods), len(links))
+	return nil
+}
+
+// reconnect connects nodes whose daemons restarted, or the pods that took
+// over nodes, to their peers in the topology of the test again.
+func (fleet *Fleet) reconnect(restarted []Pod) error {
+	if len(activeTopology) == 0 {
+		return nil
+	}
+	pods := fleet.testPods()
+	names := make(map[string]bool)
+	for _, pod := range restarted {
+		names[pod.Metadata.Name] = true
+	}
+	var links []link
+	for _, l := range activeTopology {
+		if l[1] <= len(pods) && (names[pods[l[0]-1].Metadata.Name] || names[pods[l[1]-1].Metadata.Name]) {
+			links = append(links, l)
+		}
+	}
+	if len(links) == 0 {
+		return nil
+	}
+	color.Blue("### Connecting %d restarted nodes to their peers in the topology", len(restarted))
+	return connectLinks(pods, links)
+}
+
+// testPods returns the pods of the fleet numbered as the nodes of the test:
+// its main nodes, then those of its groups.
+func (fleet *Fleet) testPods() []Pod {
+	fleetMutex.Lock()
+	defer fleetMutex.Unlock()
+	var pods []Pod
+	if fleet.Config.Selector != "" {
+		items := fleet.Pods.Items
+		if fleet.Config.Nodes < len(items) {
+			items = items[:fleet.Config.Nodes]
+		}
+		pods = append(pods, items...)
+	}
+	for _, group := range fleet.Config.Groups {
+		pods = append(pods, fleet.Groups[group.Name].Items...)
+	}
+	return pods
+}
+
+// connectLinks connects the nodes of links, numbered from 1 among pods, and
+// checks that they are.
+func connectLinks(pods []Pod, links []link) error {
+	ids := make(map[int]string)
+	addrs := make(map[int]string)
+	for _, l := range links {
+		for _, node := range l {
+			if _, ok := ids[node]; ok {
+				continue
+			}
+			var err error
+			ids[node], addrs[node], err = swarmAddress(pods[node-1])
+			if err != nil {
+				return err
+			}
+		}
+	}
+	connects := make(map[int][]string)
+	for _, l := range links {
+		connects[l[0]] = append(connects[l[0]], addrs[l[1]])
+	}
+	var wg sync.WaitGroup
+	for node, targets := range connects {
+		wg.Add(1)
+		go func(name string, targets []string) {
+			defer wg.Done()
+			RunInPod(name, "ipfs swarm connect "+strings.Join(targets, " "), nil, 60)
+		}(pods[node-1].Metadata.Name, targets)
+	}
+	wg.Wait()
+
+	connected := make(map[int]map[string]bool)
+	for node := range ids {
+		connected[node] = make(map[string]bool)
+		peers, _ := RunInPod(pods[node-1].Metadata.Name, "ipfs swarm peers", nil, 10)
+		for _, addr := range peers {
+			parts := strings.Split(strings.TrimSpace(addr), "/")
+			connected[node][parts[len(parts)-1]] = true
+		}
+	}
+	var missing []string
+	for _, l := range links {
+		if !connected[l[0]][ids[l[1]]] && !connected[l[1]][ids[l[0]]] {
+			missing = append(missing, fmt.Sprintf("%d-%d", l[0], l[1]))
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("nodes still not connected after ipfs swarm connect: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// topologyLinks returns the links of a topology of nodes numbered from 1, in
+// order. Random topologies are drawn from the run's seed.
+func topologyLinks(topology *config.Topology, nodes int, seed int64) ([]link, error) {
+	links := make(map[link]bool)
+	switch topology.Shape {
+	case "mesh":
+		for a := 1; a <= nodes; a++ {
+			for b := a + 1; b <= nodes; b++ {
+				links[newLink(a, b)] = true
+			}
+		}
+	case "ring":
+		for a := 1; a < nodes; a++ {
+			links[newLink(a, a+1)] = true
+		}
+		if nodes > 2 {
+			links[newLink(nodes, 1)] = true
+		}
+	case "star":
+		center := topology.Center
+		if center == 0 {
+			center = 1
+		}
+		if center > nodes {
+			return nil, fmt.Errorf("the center of the star, node %d, is not one of the %d nodes", center, nodes)
+		}
+		for a := 1; a <= nodes; a++ {
+			if a != center {
+				links[newLink(a, center)] = true
+			}
+		}
+	case "random":
+		degree := topology.Degree
+		if degree >= nodes || nodes*degree%2 != 0 {
+			return nil, fmt.Errorf("%d nodes can't all have %d peers", nodes, degree)
+		}
+		random := rand.New(rand.NewSource(seed))
+		links = nil
+		for attempt := 0; attempt < 100 && links == nil; attempt++ {
+			links = randomRegular(nodes, degree, random)
+		}
+		if links == nil {
+			return nil, fmt.Errorf("could not draw %d nodes with %d peers each", nodes, degree)
+		}
+	case "explicit":
+		for node, peers := range topology.Edges {
+			for _, peer := range peers {
+				if node < 1 || node > nodes || peer < 1 || peer > nodes {
+					return nil, fmt.Errorf("edge %d-%d is outside of the %d nodes", node, peer, nodes)
+				}
+				if node != peer {
+					links[newLink(node, peer)] = true
+				}
+			}
+		}
+	}
+	sorted := make([]link, 0, len(links))
+	for l := range links {
+		sorted = append(sorted, l)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i][0] < sorted[j][0] || sorted[i][0] == sorted[j][0] && sorted[i][1] < sorted[j][1]
+	})
+	return sorted, nil
+}
+
+// randomRegular draws a graph where every node has degree peers, pairing the
+// free slots of the nodes at random, and returns nil when it ends up with
+// slots that can only pair with their own node or a peer it already has.
+func randomRegular(nodes, degree int, random *rand.Rand) map[link]bool {
+	var slots []int
+	for node := 1; node <= nodes; node++ {
+		for i := 0; i < degree; i++ {
+			slots = append(slots, node)
+		}
+	}
+	links := make(map[link]bool)
+	free := func(i, j int) bool {
+		return slots[i] != slots[j] && !links[newLink(slots[i], slots[j])]
+	}
+	for len(slots) != 0 {
+		i, j := random.Intn(len(slots)), random.Intn(len(slots))
+		if !free(i, j) {
+			// Draw among the pairs left when random ones keep failing.
+			var pairs [][2]int
+			for a := range slots {
+				for b := a + 1; b < len(slots); b++ {
+					if free(a, b) {
+						pairs = append(pairs, [2]int{a, b})
+					}
+				}
+			}
+			if len(pairs) == 0 {
+				return nil
+			}
+			pair := pairs[random.Intn(len(pairs))]
+			i, j = pair[0], pair[1]
+		}
+		links[newLink(slots[i], slots[j])] = true
+		if i < j {
+			i, j = j, i
+		}
+		slots = append(slots[:i], slots[i+1:]...)
+		slots = append(slots[:j], slots[j+1:]...)
+	}
+	return links
+}
+
+// swarmAddress returns the peer id of a node and the address the others
+// connect to it at: its pod IP in a cluster, otherwise the first IPv4
+// address it listens on, loopback ones last for nodes all on one machine.
+func swarmAddress(pod Pod) (string, string, error) {
+	out, _ := RunInPod(pod.Metadata.Name, "ipfs id -f='<id>\\n<addrs>\\n'", nil, 10)
+	if len(out) == 0 || strings.TrimSpace(out[0]) == "" {
+		return "", "", fmt.Errorf("could not get peer id of %s", pod.Metadata.Name)
+	}
+	id := strings.TrimSpace(out[0])
+	if pod.Status.PodIP != "" {
+		return id, fmt.Sprintf("/ip4/%s/tcp/4001/ipfs/%s", pod.Status.PodIP, id), nil
+	}
+	loopback := ""
+	for _, addr := range out[1:] {
+		addr = strings.TrimSpace(addr)
+		if !strings.HasPrefix(addr, "/ip4/") || !strings.Contains(addr, "/tcp/") {
+			continue
+		}
+		for _, protocol := range []string{"/p2p/", "/ipfs/"} {
+			if i := strings.Index(addr, protocol); i != -1 {
+				addr = addr[:i]
+			}
+		}
+		addr += "/ipfs/" + id
+		if !strings.HasPrefix(addr, "/ip4/127.") {
+			return id, addr, nil
+		}
+		if loopback == "" {
+			loopback = addr
+		}
+	}
+	if loopback == "" {
+		return "", "", fmt.Errorf("%s announces no IPv4 address to connect to", pod.Metadata.Name)
+	}
+	return id, loopback, nil
+}
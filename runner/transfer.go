@@ -0,0 +1,80 @@
+package runner
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/fatih/color"
+)
+
+// timedCat is the shell command fetching a CID with ipfs cat inside a pod and
+// printing, in nanoseconds, when it started, when the first byte came out and
+// when the last one did, then how many bytes there were. It exits with the
+// status of the cat.
+func timedCat(cid string) string {
+	return "s=$(mktemp); t0=$(date +%s%N); { ipfs cat " + ShellQuote(cid) + "; echo $? > $s; } | " +
+		"{ b=$(dd bs=1 count=1 2> /dev/null | wc -c); t1=$(date +%s%N); n=$(wc -c); t2=$(date +%s%N); echo $t0 $t1 $t2 $((b + n)); }; " +
+		"e=$(cat $s); rm -f $s; exit $e"
+}
+
+// runTimedCat fetches the CID of a cat operation with measure_transfer on a
+// pod, timing it there so that kubectl doesn't add to the measures. The
+// node's output is the timing as a line of JSON, for jq assertions.
+func runTimedCat(pod Pod, args map[string]string, timeout int, result *report.NodeResult) {
+	command := podCommand(pod.Metadata.Name, timedCat(args["cid"]), nil, timeout)
+	var out, errout bytes.Buffer
+	result.TimedOut, result.ExitCode = command.run(&out, &errout)
+	result.Retries, result.Error = command.retries, command.execErr
+	if errout.Len() != 0 {
+		result.Stderr = strings.Split(strings.TrimRight(errout.String(), "\n"), "\n")
+	}
+	if result.ExitCode != 0 {
+		color.Red("Timed cat failed on node %d with exit code %d", result.Node, result.ExitCode)
+	}
+	if result.TimedOut || result.Error != "" || result.ExitCode != 0 {
+		return
+	}
+	transfer, err := parseTiming(out.String())
+	if err != nil {
+		result.Error = err.Error()
+		return
+	}
+	result.Transfer = transfer
+	line, _ := json.Marshal(map[string]interface{}{"bytes": transfer.Bytes, "ttfb": transfer.TTFB, "seconds": transfer.Seconds, "throughput": transfer.Throughput})
+	result.Output = []string{string(line)}
+	result.Fields = map[string]string{
+		"size":       strconv.FormatInt(transfer.Bytes, 10),
+		"ttfb":       strconv.FormatFloat(transfer.TTFB, 'f', 3, 64),
+		"seconds":    strconv.FormatFloat(transfer.Seconds, 'f', 3, 64),
+		"throughput": strconv.FormatFloat(transfer.Throughput, 'f', 3, 64),
+	}
+}
+
+// parseTiming reads the line printed by timedCat.
+func parseTiming(out string) (*report.Transfer, error) {
+	fields := strings.Fields(out)
+	if len(fields) != 4 {
+		return nil, fmt.Errorf("could not read the timing of cat: %q", out)
+	}
+	var numbers [4]int64
+	for i, field := range fields {
+		var err error
+		numbers[i], err = strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the timing of cat, does date support %%N in the pods? %q", out)
+		}
+	}
+	transfer := &report.Transfer{
+		Bytes:   numbers[3],
+		TTFB:    float64(numbers[1]-numbers[0]) / 1e9,
+		Seconds: float64(numbers[2]-numbers[0]) / 1e9,
+	}
+	if transfer.Seconds > 0 {
+		transfer.Throughput = float64(transfer.Bytes) / 1e6 / transfer.Seconds
+	}
+	return transfer, nil
+}
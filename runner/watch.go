@@ -0,0 +1,105 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/fatih/color"
+)
+
+// watchInterval is how often --watch looks for changes, and how long a
+// change has to settle before the test runs again, so an editor saving in
+// several writes starts one run.
+const watchInterval = 500 * time.Millisecond
+
+// WatchTest runs the test every time its file or one of its libraries
+// changes, cancelling the run going on. A test that fails to load, or a run
+// that fails, is reported and waits for the next change. It only returns by
+// exiting.
+func WatchTest(filePath string, opts *Options) {
+	if strings.HasPrefix(filePath, config.BuiltinPrefix) {
+		Fatal("--watch needs a test file")
+	}
+	if opts.StateFile != "" {
+		Fatal("--watch can't be used with --state-file")
+	}
+	for {
+		files := []string{filePath}
+		var cancel context.CancelFunc
+		var done chan struct{}
+		test, err := config.LoadTest(filePath, opts.Values)
+		if err == nil {
+			files = append(files, test.Libraries...)
+			opts.prepareTest(test)
+			err = ValidateTest(test)
+		}
+		if err == nil && len(test.Matrix) != 0 {
+			color.Yellow("## --watch runs the test with the matrix values given with --set only")
+		}
+		if err != nil {
+			color.Red("%s: %s", filePath, err)
+		} else {
+			run := *opts
+			run.ctx, cancel = context.WithCancel(context.Background())
+			done = make(chan struct{})
+			go func() {
+				defer close(done)
+				watchRun(test, &run)
+				color.Cyan("## Watching %s for changes", filePath)
+			}()
+		}
+
+		waitForChange(files)
+		if cancel != nil {
+			color.Yellow("## %s changed, running it again", filePath)
+			cancel()
+			<-done
+		}
+	}
+}
+
+// watchRun runs the test once for WatchTest. A failure stopping the run
+// makes it return, as under Run, rather than exit, so watching goes on.
+func watchRun(test *config.Test, opts *Options) {
+	err := Recovering(func() {
+		ExecuteTest(test, opts)
+	})
+	if err != nil {
+		color.Red("## The run of %s stopped", test.Name)
+	}
+}
+
+// waitForChange returns once one of the files changed and then stayed
+// unchanged for a watchInterval.
+func waitForChange(files []string) {
+	last := modTimes(files)
+	changed := false
+	for {
+		time.Sleep(watchInterval)
+		now := modTimes(files)
+		if now != last {
+			last, changed = now, true
+		} else if changed {
+			return
+		}
+	}
+}
+
+// modTimes sums up when the files were last modified, to tell whether any of
+// them changed.
+func modTimes(files []string) string {
+	var times []string
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			times = append(times, "missing")
+			continue
+		}
+		times = append(times, fmt.Sprintf("%s/%d", info.ModTime(), info.Size()))
+	}
+	return strings.Join(times, ",")
+}
@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"fmt"
+
+	"github.com/dgrisham/kubernetes-ipfs/assert"
+	"github.com/dgrisham/kubernetes-ipfs/config"
+)
+
+// shouldRun evaluates the `when` expression of a step, a jq expression over
+// the state of the run:
+//
+//	.iteration  the iteration, from 1
+//	.times      the number of iterations
+//	.warmup     whether the iteration is a warm-up, counted apart
+//	.nodes      the number of test nodes
+//	.vars       the variables saved by earlier steps, as strings
+//
+// The step runs unless the expression gives false or null.
+func shouldRun(when string, iteration int, times int, warmup bool, nodes int, env []string) (bool, error) {
+	query, err := assert.ParseJQ(when)
+	if err != nil {
+		return false, err
+	}
+	vars := make(map[string]interface{})
+	for _, e := range env {
+		found := config.EnvVarRegexp.FindStringSubmatch(e)
+		if len(found) == 3 {
+			vars[found[1]] = found[2]
+		}
+	}
+	state := map[string]interface{}{
+		"iteration": iteration,
+		"times":     times,
+		"warmup":    warmup,
+		"nodes":     nodes,
+		"vars":      vars,
+	}
+	value, ok := query.Run(state).Next()
+	if !ok {
+		return false, fmt.Errorf("%s gave no result", when)
+	}
+	if err, ok := value.(error); ok {
+		return false, err
+	}
+	return value != nil && value != false, nil
+}
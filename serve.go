@@ -0,0 +1,347 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/dgrisham/kubernetes-ipfs/runner"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// eventsPollInterval is how often a stream of events looks for new ones.
+const eventsPollInterval = 500 * time.Millisecond
+
+// maxTestSize is the largest test file the server takes.
+const maxTestSize = 1 << 20
+
+// serveOptions are the flags of the serve command on top of those of run.
+type serveOptions struct {
+	listen string
+	dir    string
+	token  string
+}
+
+// ServerRun is a test submitted to the server, as its API shows it.
+type ServerRun struct {
+	ID   string
+	Name string
+	// Status is queued, running, passed, failed or error, when the test
+	// couldn't run.
+	Status    string
+	Error     string `json:",omitempty"`
+	Submitted time.Time
+	Summary   *report.Summary `json:",omitempty"`
+
+	test *config.Test
+}
+
+// server runs the tests submitted to its API one after the other, with the
+// credentials of the machine it runs on.
+type server struct {
+	opts  *runner.Options
+	dir   string
+	token string
+	queue chan *ServerRun
+
+	mutex sync.Mutex
+	runs  []*ServerRun
+	next  int
+}
+
+func newServeCommand() *cobra.Command {
+	opts := &runner.Options{Values: make(config.SetValues)}
+	serve := &serveOptions{}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Run the tests submitted to an HTTP API",
+		Long: "Serve an HTTP API to submit tests, follow their runs and fetch their\n" +
+			"results, so a team shares one runner and its cluster credentials. Tests\n" +
+			"run one at a time, with the run flags given to serve.",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.StateFile != "" || opts.Resume || opts.Watch || opts.Step {
+				return fmt.Errorf("--state-file, --resume, --watch and --step only apply to run")
+			}
+			if serve.token == "" {
+				serve.token = os.Getenv("KUBERNETES_IPFS_TOKEN")
+			}
+			// Submitted tests run commands in the cluster with the
+			// credentials of the runner.
+			if serve.token == "" && !loopback(serve.listen) {
+				return fmt.Errorf("serve needs a --token unless it listens on 127.0.0.1")
+			}
+			var err error
+			opts.Defaults, err = runner.LoadDefaults(configPath)
+			if err != nil {
+				return err
+			}
+			err = os.MkdirAll(serve.dir, 0775)
+			if err != nil {
+				return err
+			}
+			s := &server{opts: opts, dir: serve.dir, token: serve.token, queue: make(chan *ServerRun, 100)}
+			go s.work()
+			color.Cyan("## Serving on %s", serve.listen)
+			return http.ListenAndServe(serve.listen, s)
+		},
+	}
+	addRunFlags(cmd.Flags(), opts)
+	cmd.Flags().StringVar(&serve.listen, "listen", "127.0.0.1:8080", "address to serve the API on")
+	cmd.Flags().StringVar(&serve.dir, "dir", "kubernetes-ipfs-runs", "directory keeping the submitted tests and the events of their runs")
+	cmd.Flags().StringVar(&serve.token, "token", "", "token the requests must carry as a bearer token (default $KUBERNETES_IPFS_TOKEN)")
+	return cmd
+}
+
+// loopback tells whether an address to listen on only takes connections from
+// the machine itself.
+func loopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return host == "localhost" || ip != nil && ip.IsLoopback()
+}
+
+// ServeHTTP routes the requests of the API, and serves the dashboard at /:
+//
+//	POST /runs                 submit a test file, with ?set=key=value
+//	GET  /runs                 list the runs
+//	GET  /runs/<id>            a run, with its summary once it finished
+//	GET  /runs/<id>/events     stream its events until it finishes
+//	GET  /runs/<id>/report     the summary of the run, once it finished
+//	GET  /runs/<id>/html       its HTML report
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// The dashboard holds nothing, it reads the API with the token.
+	if r.URL.Path == "/" && r.Method == http.MethodGet {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		io.WriteString(w, dashboardPage)
+		return
+	}
+	authorization := r.Header.Get("Authorization")
+	if s.token != "" && subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+s.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "runs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.mutex.Lock()
+			defer s.mutex.Unlock()
+			writeJSON(w, http.StatusOK, s.runs)
+		case http.MethodPost:
+			s.submit(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	run := s.find(parts[1])
+	if run == nil {
+		http.NotFound(w, r)
+		return
+	}
+	switch {
+	case len(parts) == 2:
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		writeJSON(w, http.StatusOK, run)
+	case parts[2] == "events":
+		s.streamEvents(w, r, run)
+	case parts[2] == "report" || parts[2] == "html":
+		s.mutex.Lock()
+		defer s.mutex.Unlock()
+		if run.Summary == nil {
+			http.Error(w, "the run has no summary, it hasn't finished or couldn't start", http.StatusNotFound)
+			return
+		}
+		if parts[2] == "html" {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			report.HTMLReport(w, run.Summary)
+			return
+		}
+		writeJSON(w, http.StatusOK, run.Summary)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// submit loads a submitted test and queues its run.
+func (s *server) submit(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxTestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	values := make(config.SetValues)
+	for key, value := range s.opts.Values {
+		values[key] = value
+	}
+	for _, pair := range r.URL.Query()["set"] {
+		err = values.Set(pair)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	s.mutex.Lock()
+	s.next++
+	id := time.Now().Format("20060102-150405") + "-" + strconv.Itoa(s.next)
+	s.mutex.Unlock()
+	path := filepath.Join(s.dir, id+".yml")
+	err = ioutil.WriteFile(path, data, 0664)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// The test is validated as it runs, with the state of the runner only
+	// the run going on may touch.
+	test, err := config.LoadTest(path, values)
+	if err == nil && len(test.Matrix) != 0 {
+		err = fmt.Errorf("the server doesn't run matrix tests, pin their values with set")
+	}
+	if err == nil {
+		err = runner.ValidateRemote(test)
+	}
+	if err != nil {
+		os.Remove(path)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	run := &ServerRun{ID: id, Name: test.Name, Status: "queued", Submitted: time.Now(), test: test}
+	s.mutex.Lock()
+	s.runs = append(s.runs, run)
+	s.mutex.Unlock()
+	select {
+	case s.queue <- run:
+	default:
+		s.finish(run, nil, fmt.Errorf("too many runs queued"))
+		http.Error(w, "too many runs queued", http.StatusServiceUnavailable)
+		return
+	}
+	color.Cyan("## Queued '%s' as run %s", test.Name, id)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// work runs the queued tests one after the other, as runs share the state
+// of the runner.
+func (s *server) work() {
+	for run := range s.queue {
+		opts := *s.opts
+		// Preparing the run sets values, which submit reads meanwhile.
+		opts.Values = make(config.SetValues)
+		for key, value := range s.opts.Values {
+			opts.Values[key] = value
+		}
+		opts.ReportFile = runner.MatrixFile(s.opts.ReportFile, run.ID, opts.ReportFormat != "sqlite" && opts.ReportFormat != "influx")
+		opts.HTMLReport = runner.MatrixFile(s.opts.HTMLReport, run.ID, true)
+		opts.OutputDir = runner.MatrixFile(s.opts.OutputDir, run.ID, true)
+		opts.EventsOut = s.eventsFile(run)
+		opts.Remote = true
+		s.mutex.Lock()
+		run.Status = "running"
+		s.mutex.Unlock()
+		color.Cyan("## Running '%s', run %s", run.Name, run.ID)
+		summary, err := runner.Run(*run.test, opts)
+		s.finish(run, &summary, err)
+	}
+}
+
+// finish records the outcome of a run.
+func (s *server) finish(run *ServerRun, summary *report.Summary, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	run.test = nil
+	switch {
+	case err == nil:
+		run.Status = "passed"
+	case summary == nil || summary.Start.IsZero():
+		run.Status = "error"
+	default:
+		run.Status = "failed"
+	}
+	if err != nil {
+		run.Error = err.Error()
+	}
+	if summary != nil && !summary.Start.IsZero() {
+		run.Summary = summary
+	}
+}
+
+func (s *server) find(id string) *ServerRun {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, run := range s.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+func (s *server) eventsFile(run *ServerRun) string {
+	return filepath.Join(s.dir, run.ID+".events.jsonl")
+}
+
+// streamEvents copies the events of a run to the response as they are
+// written, until the run finishes or the client goes away.
+func (s *server) streamEvents(w http.ResponseWriter, r *http.Request, run *ServerRun) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	flusher, _ := w.(http.Flusher)
+	var offset int64
+	for {
+		// Events written before the run finished are all read below.
+		s.mutex.Lock()
+		finished := run.Status != "queued" && run.Status != "running"
+		s.mutex.Unlock()
+		file, err := os.Open(s.eventsFile(run))
+		if err == nil {
+			file.Seek(offset, io.SeekStart)
+			n, _ := io.Copy(w, file)
+			offset += n
+			file.Close()
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		if finished {
+			return
+		}
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(eventsPollInterval):
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/dgrisham/kubernetes-ipfs/config"
+	"github.com/dgrisham/kubernetes-ipfs/report"
+	"github.com/dgrisham/kubernetes-ipfs/runner"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+// soakOptions are the flags of the soak command on top of those of run.
+type soakOptions struct {
+	every        time.Duration
+	duration     time.Duration
+	untilFailure bool
+	soakReport   string
+}
+
+// SoakRound is the outcome of one run of a soak, as appended to the soak
+// report.
+type SoakRound struct {
+	Name      string
+	Round     int
+	Start     time.Time
+	End       time.Time
+	Successes int
+	Failures  int
+	Timeouts  int
+	Passed    bool
+	Aborted   string `json:",omitempty"`
+	Error     string `json:",omitempty"`
+}
+
+func newSoakCommand() *cobra.Command {
+	opts := &runner.Options{Values: make(config.SetValues)}
+	soak := &soakOptions{}
+	cmd := &cobra.Command{
+		Use:   "soak <testfile|builtin:name>",
+		Short: "Run a test over and over for hours",
+		Long: "Run a test every --every for --for, or until it fails with\n" +
+			"--until-failure, appending the outcome of every round to --soak-report.\n" +
+			"The exit code is 0 when every round met the expectations of the test.",
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if soak.duration <= 0 && !soak.untilFailure {
+				return fmt.Errorf("soak needs --for, --until-failure or both")
+			}
+			if opts.StateFile != "" || opts.Watch {
+				return fmt.Errorf("--state-file and --watch only apply to run")
+			}
+			var err error
+			opts.Defaults, err = runner.LoadDefaults(configPath)
+			if err != nil {
+				return err
+			}
+			os.Exit(runSoak(args[0], opts, soak))
+			return nil
+		},
+	}
+	addRunFlags(cmd.Flags(), opts)
+	cmd.Flags().DurationVar(&soak.every, "every", 0, "time between the starts of two rounds, back to back when not set")
+	cmd.Flags().DurationVar(&soak.duration, "for", 0, "stop starting rounds after this long")
+	cmd.Flags().BoolVar(&soak.untilFailure, "until-failure", false, "stop after the first round that fails")
+	cmd.Flags().StringVar(&soak.soakReport, "soak-report", "", "append the outcome of every round to this file as newline-delimited JSON")
+	return cmd
+}
+
+// runSoak runs the test in rounds until the soak is over. Every round loads
+// the test again and writes its own reports, named after the round. A round
+// that fails to load or stops on a failure counts as failed, so one bad
+// round doesn't end the soak without its summary. It returns 0 when every
+// round passed and 1 otherwise.
+func runSoak(filePath string, opts *runner.Options, soak *soakOptions) int {
+	opts.Prepare()
+	deadline := time.Now().Add(soak.duration)
+	var rounds []SoakRound
+	outcome := 0
+	for round := 1; ; round++ {
+		start := time.Now()
+		run := *opts
+		label := fmt.Sprintf("round-%d", round)
+		run.ReportFile = runner.MatrixFile(opts.ReportFile, label, opts.ReportFormat != "sqlite" && opts.ReportFormat != "influx")
+		run.HTMLReport = runner.MatrixFile(opts.HTMLReport, label, true)
+		run.EventsOut = runner.MatrixFile(opts.EventsOut, label, true)
+		run.OutputDir = runner.MatrixFile(opts.OutputDir, label, true)
+		name := filePath
+		var summary report.Summary
+		result := 0
+		err := runner.Recovering(func() {
+			test := runner.LoadRunTest(filePath, &run)
+			name = test.Name
+			if len(test.Matrix) != 0 {
+				runner.Fatal("soak doesn't run matrix tests, pin their values with --set")
+			}
+			color.Cyan("## Soak round %d of '%s'", round, test.Name)
+			summary, result = runner.ExecuteTest(test, &run)
+		})
+
+		soakRound := SoakRound{
+			Name:      name,
+			Round:     round,
+			Start:     summary.Start,
+			End:       summary.End,
+			Successes: summary.Successes,
+			Failures:  summary.Failures,
+			Timeouts:  summary.Timeouts,
+			Passed:    result == 0,
+			Aborted:   summary.Aborted,
+		}
+		if err != nil {
+			soakRound.Start, soakRound.End = start, time.Now()
+			soakRound.Passed = false
+			soakRound.Error = err.Error()
+			result = 1
+		}
+		rounds = append(rounds, soakRound)
+		if soak.soakReport != "" {
+			data, err := json.Marshal(rounds[len(rounds)-1])
+			if err == nil {
+				err = report.AppendToFile(soak.soakReport, append(data, '\n'))
+			}
+			if err != nil {
+				color.Red("Failed to write the soak report: %s", err)
+			}
+		}
+		if result != 0 {
+			outcome = 1
+			if soak.untilFailure {
+				break
+			}
+		}
+		next := start.Add(soak.every)
+		if soak.duration > 0 && !next.Before(deadline) {
+			break
+		}
+		if wait := time.Until(next); wait > 0 {
+			color.Cyan("## Next soak round in %s", wait.Round(time.Second))
+			time.Sleep(wait)
+		}
+	}
+	printSoakSummary(rounds)
+	return outcome
+}
+
+func printSoakSummary(rounds []SoakRound) {
+	passed := 0
+	for _, round := range rounds {
+		if round.Passed {
+			passed++
+		}
+	}
+	fmt.Println("============================")
+	fmt.Println("== Soak Summary")
+	fmt.Println("===============")
+	fmt.Printf("== %d/%d rounds passed\n", passed, len(rounds))
+	for _, round := range rounds {
+		if round.Error != "" {
+			fmt.Printf("== round %d stopped: %s\n", round.Round, round.Error)
+		} else if !round.Passed {
+			fmt.Printf("== round %d failed: %d/%d (success/failure), %d timeouts, %s\n",
+				round.Round, round.Successes, round.Failures, round.Timeouts, round.End.Sub(round.Start))
+		}
+	}
+}